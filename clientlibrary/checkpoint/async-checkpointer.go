@@ -0,0 +1,427 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// DefaultAsyncFlushIntervalMillis is how long an AsyncCheckpointer holds checkpoints before writing them
+const DefaultAsyncFlushIntervalMillis = 1000
+
+// ErrNotSupported is returned by AsyncCheckpointer when the wrapped checkpointer doesn't implement an optional interface
+var ErrNotSupported = errors.New("NotSupported")
+
+// CheckpointFlusher is implemented by checkpointers that buffer checkpoints. The worker flushes them once its
+// shard consumers have stopped.
+type CheckpointFlusher interface {
+	// Flush writes all buffered checkpoints
+	Flush() error
+}
+
+// AsyncCheckpointer is a Checkpointer decorator that accepts checkpoints without waiting for them to be written.
+// Only the latest checkpoint of each shard is kept and written by the wrapped checkpointer on an interval, before
+// the lease is released, read back, changed or removed, and on Flush. The optional interfaces of the wrapped
+// checkpointer are forwarded and return ErrNotSupported if it doesn't implement them. A failed write is returned by the next
+// CheckpointSequence of the shard, so a lost lease still stops the shard consumer.
+type AsyncCheckpointer struct {
+	Checkpointer
+	log           logger.Logger
+	flushInterval time.Duration
+
+	mux     sync.Mutex
+	pending map[string]*asyncCheckpoint
+	failed  map[string]error
+	timer   *time.Timer
+
+	// flushMux keeps the writes of a shard in order
+	flushMux sync.Mutex
+}
+
+// asyncCheckpoint is the latest checkpoint of a shard waiting to be written
+type asyncCheckpoint struct {
+	shard *par.ShardStatus
+	acks  []*CheckpointAck
+}
+
+// CheckpointAck is resolved once a checkpoint accepted by an AsyncCheckpointer, or a later checkpoint of the same
+// shard that superseded it, has been written.
+type CheckpointAck struct {
+	done chan struct{}
+	err  error
+}
+
+// Done is closed once the checkpoint has been written or failed to be written
+func (ack *CheckpointAck) Done() <-chan struct{} {
+	return ack.done
+}
+
+// Wait blocks until the checkpoint has been written and returns the error of the write
+func (ack *CheckpointAck) Wait() error {
+	<-ack.done
+	return ack.err
+}
+
+func (ack *CheckpointAck) resolve(err error) {
+	ack.err = err
+	close(ack.done)
+}
+
+// NewAsyncCheckpointer wraps a checkpointer so that checkpoints are written in the background
+func NewAsyncCheckpointer(checkpointer Checkpointer, kclConfig *config.KinesisClientLibConfiguration) *AsyncCheckpointer {
+	return &AsyncCheckpointer{
+		Checkpointer:  checkpointer,
+		log:           kclConfig.Logger,
+		flushInterval: DefaultAsyncFlushIntervalMillis * time.Millisecond,
+		pending:       make(map[string]*asyncCheckpoint),
+		failed:        make(map[string]error),
+	}
+}
+
+// WithFlushIntervalMillis sets how long checkpoints are held before they are written
+func (checkpointer *AsyncCheckpointer) WithFlushIntervalMillis(flushIntervalMillis int) *AsyncCheckpointer {
+	checkpointer.flushInterval = time.Duration(flushIntervalMillis) * time.Millisecond
+	return checkpointer
+}
+
+// CheckpointSequence accepts the checkpoint of the shard, to be written on the next flush
+func (checkpointer *AsyncCheckpointer) CheckpointSequence(shard *par.ShardStatus) error {
+	_, err := checkpointer.checkpointAsync(shard)
+	return err
+}
+
+// CheckpointSequenceAsync accepts the checkpoint of the shard and returns an acknowledgement resolved once it is
+// durable
+func (checkpointer *AsyncCheckpointer) CheckpointSequenceAsync(shard *par.ShardStatus) (*CheckpointAck, error) {
+	return checkpointer.checkpointAsync(shard)
+}
+
+func (checkpointer *AsyncCheckpointer) checkpointAsync(shard *par.ShardStatus) (*CheckpointAck, error) {
+	ack := &CheckpointAck{done: make(chan struct{})}
+
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	// report the failed write of an earlier checkpoint, e.g. because the lease was lost
+	if err, ok := checkpointer.failed[shard.ID]; ok {
+		delete(checkpointer.failed, shard.ID)
+		return nil, err
+	}
+
+	acks := []*CheckpointAck{ack}
+	if previous, ok := checkpointer.pending[shard.ID]; ok {
+		acks = append(previous.acks, ack)
+	}
+	checkpointer.pending[shard.ID] = &asyncCheckpoint{shard: snapshotShard(shard), acks: acks}
+
+	if checkpointer.timer == nil {
+		checkpointer.timer = time.AfterFunc(checkpointer.flushInterval, func() {
+			if err := checkpointer.Flush(); err != nil {
+				checkpointer.log.Errorf("Error writing checkpoints: %+v", err)
+			}
+		})
+	}
+	return ack, nil
+}
+
+// Flush writes the checkpoints of all shards
+func (checkpointer *AsyncCheckpointer) Flush() error {
+	checkpointer.flushMux.Lock()
+	defer checkpointer.flushMux.Unlock()
+
+	checkpointer.mux.Lock()
+	pending := checkpointer.pending
+	checkpointer.pending = make(map[string]*asyncCheckpoint)
+	if checkpointer.timer != nil {
+		checkpointer.timer.Stop()
+		checkpointer.timer = nil
+	}
+	checkpointer.mux.Unlock()
+
+	var errs []error
+	for _, checkpoint := range pending {
+		errs = append(errs, checkpointer.write(checkpoint))
+	}
+	return errors.Join(errs...)
+}
+
+// flushShard writes the checkpoint of a single shard
+func (checkpointer *AsyncCheckpointer) flushShard(shardID string) error {
+	checkpointer.flushMux.Lock()
+	defer checkpointer.flushMux.Unlock()
+
+	checkpointer.mux.Lock()
+	checkpoint, ok := checkpointer.pending[shardID]
+	delete(checkpointer.pending, shardID)
+	checkpointer.mux.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return checkpointer.write(checkpoint)
+}
+
+func (checkpointer *AsyncCheckpointer) write(checkpoint *asyncCheckpoint) error {
+	err := checkpointer.Checkpointer.CheckpointSequence(checkpoint.shard)
+	if err != nil {
+		checkpointer.mux.Lock()
+		checkpointer.failed[checkpoint.shard.ID] = err
+		checkpointer.mux.Unlock()
+	}
+
+	for _, ack := range checkpoint.acks {
+		ack.resolve(err)
+	}
+	return err
+}
+
+// FetchCheckpoint writes the pending checkpoint of the shard before reading it back
+func (checkpointer *AsyncCheckpointer) FetchCheckpoint(shard *par.ShardStatus) error {
+	if err := checkpointer.flushShard(shard.ID); err != nil {
+		checkpointer.log.Warnf("Error writing checkpoint of shard %s: %+v", shard.ID, err)
+	}
+	return checkpointer.Checkpointer.FetchCheckpoint(shard)
+}
+
+// RemoveLeaseOwner writes the pending checkpoint of the shard before releasing its lease
+func (checkpointer *AsyncCheckpointer) RemoveLeaseOwner(shardID string) error {
+	if err := checkpointer.flushShard(shardID); err != nil {
+		checkpointer.log.Errorf("Error writing checkpoint of shard %s before releasing its lease: %+v", shardID, err)
+	}
+	return checkpointer.Checkpointer.RemoveLeaseOwner(shardID)
+}
+
+// RemoveLeaseInfo writes the pending checkpoint of the shard before removing its lease
+func (checkpointer *AsyncCheckpointer) RemoveLeaseInfo(shardID string) error {
+	if err := checkpointer.flushShard(shardID); err != nil {
+		checkpointer.log.Warnf("Error writing checkpoint of shard %s: %+v", shardID, err)
+	}
+
+	checkpointer.mux.Lock()
+	delete(checkpointer.failed, shardID)
+	checkpointer.mux.Unlock()
+	return checkpointer.Checkpointer.RemoveLeaseInfo(shardID)
+}
+
+// PrepareCheckpoint writes the pending checkpoint of the shard and stores the prepared checkpoint through the
+// wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) PrepareCheckpoint(shard *par.ShardStatus) error {
+	pendingCheckpointer, ok := checkpointer.Checkpointer.(PendingCheckpointer)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := checkpointer.flushShard(shard.ID); err != nil {
+		return err
+	}
+	return pendingCheckpointer.PrepareCheckpoint(shard)
+}
+
+// MarkLeaseDraining writes the pending checkpoint of the shard and flags its lease through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) MarkLeaseDraining(shard *par.ShardStatus) error {
+	drainer, ok := checkpointer.Checkpointer.(LeaseDrainer)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := checkpointer.flushShard(shard.ID); err != nil {
+		return err
+	}
+	return drainer.MarkLeaseDraining(shard)
+}
+
+// CheckpointSequenceTx writes the pending checkpoint of the shard and then the checkpoint within the transaction
+// through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) CheckpointSequenceTx(ctx context.Context, tx *sql.Tx, shard *par.ShardStatus) error {
+	txCheckpointer, ok := checkpointer.Checkpointer.(TxCheckpointer)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := checkpointer.flushShard(shard.ID); err != nil {
+		return err
+	}
+	return txCheckpointer.CheckpointSequenceTx(ctx, tx, shard)
+}
+
+// ScanLeases writes all pending checkpoints before scanning the lease table through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) ScanLeases() (*LeaseSnapshot, error) {
+	scanner, ok := checkpointer.Checkpointer.(LeaseScanner)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	checkpointer.flushAll()
+	return scanner.ScanLeases()
+}
+
+// FetchCheckpointFromSnapshot writes the pending checkpoint of the shard before reading it from the snapshot. The
+// lease is read from the lease table if the wrapped checkpointer can't read snapshots.
+func (checkpointer *AsyncCheckpointer) FetchCheckpointFromSnapshot(snapshot *LeaseSnapshot, shard *par.ShardStatus) error {
+	reader, ok := checkpointer.Checkpointer.(LeaseSnapshotReader)
+	if !ok {
+		return checkpointer.FetchCheckpoint(shard)
+	}
+	if err := checkpointer.flushShard(shard.ID); err != nil {
+		checkpointer.log.Warnf("Error writing checkpoint of shard %s: %+v", shard.ID, err)
+	}
+	return reader.FetchCheckpointFromSnapshot(snapshot, shard)
+}
+
+// ListLeases writes all pending checkpoints before listing the leases through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) ListLeases() ([]*par.ShardStatus, error) {
+	lister, ok := checkpointer.Checkpointer.(LeaseLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	checkpointer.flushAll()
+	return lister.ListLeases()
+}
+
+// ListLeasesByOwner writes all pending checkpoints before listing the leases of the worker through the wrapped
+// checkpointer
+func (checkpointer *AsyncCheckpointer) ListLeasesByOwner(workerID string) ([]*par.ShardStatus, error) {
+	lister, ok := checkpointer.Checkpointer.(LeaseOwnerLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	checkpointer.flushAll()
+	return lister.ListLeasesByOwner(workerID)
+}
+
+// PinShard writes the pending checkpoint of the shard before pinning it through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) PinShard(shardID, workerID string) error {
+	stickyAdmin, ok := checkpointer.Checkpointer.(StickyAdmin)
+	if !ok {
+		return ErrNotSupported
+	}
+	checkpointer.flushBefore(shardID)
+	return stickyAdmin.PinShard(shardID, workerID)
+}
+
+// PinShardUntil writes the pending checkpoint of the shard before pinning it through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) PinShardUntil(shardID, workerID string, expiry time.Time) error {
+	stickyAdmin, ok := checkpointer.Checkpointer.(StickyAdmin)
+	if !ok {
+		return ErrNotSupported
+	}
+	checkpointer.flushBefore(shardID)
+	return stickyAdmin.PinShardUntil(shardID, workerID, expiry)
+}
+
+// PinShardToGroup writes the pending checkpoint of the shard before pinning it through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) PinShardToGroup(shardID, selector string) error {
+	stickyAdmin, ok := checkpointer.Checkpointer.(StickyAdmin)
+	if !ok {
+		return ErrNotSupported
+	}
+	checkpointer.flushBefore(shardID)
+	return stickyAdmin.PinShardToGroup(shardID, selector)
+}
+
+// UnpinShard writes the pending checkpoint of the shard before unpinning it through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) UnpinShard(shardID string) error {
+	stickyAdmin, ok := checkpointer.Checkpointer.(StickyAdmin)
+	if !ok {
+		return ErrNotSupported
+	}
+	checkpointer.flushBefore(shardID)
+	return stickyAdmin.UnpinShard(shardID)
+}
+
+// RequestShardRelease writes the pending checkpoint of the shard before asking for its release through the wrapped
+// checkpointer
+func (checkpointer *AsyncCheckpointer) RequestShardRelease(shardID string) error {
+	stickyAdmin, ok := checkpointer.Checkpointer.(StickyAdmin)
+	if !ok {
+		return ErrNotSupported
+	}
+	checkpointer.flushBefore(shardID)
+	return stickyAdmin.RequestShardRelease(shardID)
+}
+
+// ListPinnedShards writes all pending checkpoints before listing the pinned shards through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) ListPinnedShards() ([]*par.ShardStatus, error) {
+	stickyAdmin, ok := checkpointer.Checkpointer.(StickyAdmin)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	checkpointer.flushAll()
+	return stickyAdmin.ListPinnedShards()
+}
+
+// RewindShard writes the pending checkpoint of the shard before rewinding it through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) RewindShard(shardID string, target RewindTarget) error {
+	rewinder, ok := checkpointer.Checkpointer.(LeaseRewinder)
+	if !ok {
+		return ErrNotSupported
+	}
+	checkpointer.flushBefore(shardID)
+	return rewinder.RewindShard(shardID, target)
+}
+
+// RewindAllShards writes all pending checkpoints before rewinding the shards through the wrapped checkpointer
+func (checkpointer *AsyncCheckpointer) RewindAllShards(target RewindTarget) ([]string, error) {
+	rewinder, ok := checkpointer.Checkpointer.(LeaseRewinder)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	checkpointer.flushAll()
+	return rewinder.RewindAllShards(target)
+}
+
+// flushBefore writes the pending checkpoint of the shard before the lease is read or changed through the wrapped
+// checkpointer. A failed write is reported by the next CheckpointSequence of the shard.
+func (checkpointer *AsyncCheckpointer) flushBefore(shardID string) {
+	if err := checkpointer.flushShard(shardID); err != nil {
+		checkpointer.log.Warnf("Error writing checkpoint of shard %s: %+v", shardID, err)
+	}
+}
+
+// flushAll writes all pending checkpoints before the lease table is read or changed through the wrapped
+// checkpointer. Failed writes are reported by the next CheckpointSequence of their shard.
+func (checkpointer *AsyncCheckpointer) flushAll() {
+	if err := checkpointer.Flush(); err != nil {
+		checkpointer.log.Warnf("Error writing checkpoints: %+v", err)
+	}
+}
+
+// snapshotShard copies the state of the shard written by CheckpointSequence
+func snapshotShard(shard *par.ShardStatus) *par.ShardStatus {
+	snapshot := &par.ShardStatus{
+		ID:                    shard.ID,
+		ParentShardId:         shard.ParentShardId,
+		AdjacentParentShardId: shard.AdjacentParentShardId,
+		Checkpoint:            shard.GetCheckpoint(),
+		AssignedTo:            shard.GetLeaseOwner(),
+		RewindRequest:         shard.GetRewindRequest(),
+		Mux:                   &sync.RWMutex{},
+	}
+	if subSequenceNumber := shard.GetCheckpointSubSequenceNumber(); subSequenceNumber != nil {
+		value := *subSequenceNumber
+		snapshot.CheckpointSubSequenceNumber = &value
+	}
+	return snapshot
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// recordingCheckpointer records the checkpoints and lease releases reaching the wrapped checkpointer
+type recordingCheckpointer struct {
+	Checkpointer
+	mux    sync.Mutex
+	writes []string
+	err    error
+}
+
+func (m *recordingCheckpointer) CheckpointSequence(shard *par.ShardStatus) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.writes = append(m.writes, shard.ID+"="+shard.GetCheckpoint())
+	return m.err
+}
+
+func (m *recordingCheckpointer) RemoveLeaseOwner(shardID string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.writes = append(m.writes, "release "+shardID)
+	return nil
+}
+
+// preparingCheckpointer also records the pending checkpoints reaching the wrapped checkpointer
+type preparingCheckpointer struct {
+	recordingCheckpointer
+}
+
+func (m *preparingCheckpointer) PrepareCheckpoint(shard *par.ShardStatus) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.writes = append(m.writes, "prepare "+shard.ID+"="+shard.GetPendingCheckpoint())
+	return m.err
+}
+
+func (m *recordingCheckpointer) recorded() []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([]string(nil), m.writes...)
+}
+
+func newAsyncCheckpointer(delegate Checkpointer) *AsyncCheckpointer {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	return NewAsyncCheckpointer(delegate, kclConfig).WithFlushIntervalMillis(60000)
+}
+
+func TestAsyncCheckpointerCoalesces(t *testing.T) {
+	delegate := &recordingCheckpointer{}
+	checkpointer := newAsyncCheckpointer(delegate)
+	shard := &par.ShardStatus{ID: "0001", AssignedTo: "abc", Mux: &sync.RWMutex{}}
+
+	var acks []*CheckpointAck
+	for _, checkpoint := range []string{"100", "200", "300"} {
+		shard.SetCheckpoint(checkpoint)
+		ack, err := checkpointer.CheckpointSequenceAsync(shard)
+		assert.Nil(t, err)
+		acks = append(acks, ack)
+	}
+	// the shard moves on, the accepted checkpoint doesn't
+	shard.SetCheckpoint("400")
+	assert.Empty(t, delegate.recorded())
+
+	assert.Nil(t, checkpointer.Flush())
+	assert.Equal(t, []string{"0001=300"}, delegate.recorded())
+	for _, ack := range acks {
+		assert.Nil(t, ack.Wait())
+	}
+
+	// nothing left to write
+	assert.Nil(t, checkpointer.Flush())
+	assert.Equal(t, []string{"0001=300"}, delegate.recorded())
+}
+
+func TestAsyncCheckpointerFlushInterval(t *testing.T) {
+	delegate := &recordingCheckpointer{}
+	checkpointer := newAsyncCheckpointer(delegate).WithFlushIntervalMillis(10)
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+
+	ack, err := checkpointer.CheckpointSequenceAsync(shard)
+	assert.Nil(t, err)
+
+	select {
+	case <-ack.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("checkpoint was not flushed")
+	}
+	assert.Nil(t, ack.Wait())
+	assert.Equal(t, []string{"0001=100"}, delegate.recorded())
+}
+
+func TestAsyncCheckpointerFailedWrite(t *testing.T) {
+	delegate := &recordingCheckpointer{err: leaseLostError("0001", "abc")}
+	checkpointer := newAsyncCheckpointer(delegate)
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", AssignedTo: "abc", Mux: &sync.RWMutex{}}
+
+	ack, err := checkpointer.CheckpointSequenceAsync(shard)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, checkpointer.Flush(), ErrLeaseLost)
+	assert.ErrorIs(t, ack.Wait(), ErrLeaseLost)
+
+	// the next checkpoint of the shard reports the lost lease
+	assert.ErrorIs(t, checkpointer.CheckpointSequence(shard), ErrLeaseLost)
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+}
+
+func TestAsyncCheckpointerFlushesBeforeRelease(t *testing.T) {
+	delegate := &recordingCheckpointer{}
+	checkpointer := newAsyncCheckpointer(delegate)
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	other := &par.ShardStatus{ID: "0002", Checkpoint: "500", Mux: &sync.RWMutex{}}
+
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.Nil(t, checkpointer.CheckpointSequence(other))
+	assert.Nil(t, checkpointer.RemoveLeaseOwner("0001"))
+	assert.Equal(t, []string{"0001=100", "release 0001"}, delegate.recorded())
+
+	assert.Nil(t, checkpointer.Flush())
+	assert.Equal(t, []string{"0001=100", "release 0001", "0002=500"}, delegate.recorded())
+}
+
+func TestAsyncCheckpointerFlushesBeforePrepare(t *testing.T) {
+	delegate := &preparingCheckpointer{}
+	checkpointer := newAsyncCheckpointer(delegate)
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	shard.SetPendingCheckpoint("200")
+	assert.Nil(t, checkpointer.PrepareCheckpoint(shard))
+	assert.Equal(t, []string{"0001=100", "prepare 0001=200"}, delegate.recorded())
+
+	// a failed write of the buffered checkpoint isn't overtaken by the prepared checkpoint
+	delegate.err = ErrLeaseLost
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.ErrorIs(t, checkpointer.PrepareCheckpoint(shard), ErrLeaseLost)
+	assert.Equal(t, []string{"0001=100", "prepare 0001=200", "0001=100"}, delegate.recorded())
+}
+
+func TestAsyncCheckpointerOptionalInterfaces(t *testing.T) {
+	checkpointer := newAsyncCheckpointer(&recordingCheckpointer{})
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+
+	var _ LeaseSnapshotReader = checkpointer
+	var _ LeaseLister = checkpointer
+	var _ LeaseOwnerLister = checkpointer
+	var _ StickyAdmin = checkpointer
+	var _ TxCheckpointer = checkpointer
+	var _ LeaseRewinder = checkpointer
+
+	assert.ErrorIs(t, checkpointer.PrepareCheckpoint(shard), ErrNotSupported)
+	assert.ErrorIs(t, checkpointer.MarkLeaseDraining(shard), ErrNotSupported)
+	assert.ErrorIs(t, checkpointer.PinShard("0001", "abc"), ErrNotSupported)
+	assert.ErrorIs(t, checkpointer.RewindShard("0001", RewindTarget{}), ErrNotSupported)
+	_, err := checkpointer.ScanLeases()
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, err = checkpointer.ListLeases()
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestAsyncCheckpointerForwardsOptionalInterfaces(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	delegate := NewMemoryCheckpoint(kclConfig)
+	checkpointer := newAsyncCheckpointer(delegate)
+
+	shard := &par.ShardStatus{ID: "0001", Sticky: int(par.StickyUnset), Mux: &sync.RWMutex{}}
+	assert.Nil(t, delegate.GetLease(shard, "abc"))
+	shard.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	// the buffered checkpoint is written before the leases are listed
+	leases, err := checkpointer.ListLeasesByOwner("abc")
+	assert.Nil(t, err)
+	if assert.Len(t, leases, 1) {
+		assert.Equal(t, "100", leases[0].GetCheckpoint())
+	}
+
+	assert.Nil(t, checkpointer.PinShard("0001", "abc"))
+	pinned, err := checkpointer.ListPinnedShards()
+	assert.Nil(t, err)
+	assert.Len(t, pinned, 1)
+	assert.Nil(t, checkpointer.UnpinShard("0001"))
+}
@@ -37,14 +37,23 @@ import (
 )
 
 const (
-	LeaseKeyKey       = "ShardID"
-	LeaseOwnerKey     = "AssignedTo"
-	LeaseTimeoutKey   = "LeaseTimeout"
-	SequenceNumberKey = "Checkpoint"
-	ParentShardIdKey  = "ParentShardId"
-	ClaimRequestKey   = "ClaimRequest"
-	StickyKey         = "Sticky"
-	StickyWorkerKey   = "StickyWorker" // The worker ID this shard is pinned to
+	LeaseKeyKey              = "ShardID"
+	LeaseOwnerKey            = "AssignedTo"
+	LeaseTimeoutKey          = "LeaseTimeout"
+	SequenceNumberKey        = "Checkpoint"
+	ParentShardIdKey         = "ParentShardId"
+	AdjacentParentShardIdKey = "AdjacentParentShardId" // The second parent of a shard created by merging two shards
+	ClaimRequestKey          = "ClaimRequest"
+	StickyKey                = "Sticky"
+	StickyWorkerKey          = "StickyWorker"                 // The worker ID this shard is pinned to
+	StickyGroupKey           = "StickyGroup"                  // Label selector of the worker group this shard is pinned to
+	StickyExpiryKey          = "StickyExpiry"                 // Optional expiry of the pin (RFC3339Nano)
+	DrainingKey              = "Draining"                     // The draining worker waiting for another worker to claim the shard
+	PendingCheckpointKey     = "PendingCheckpoint"            // Sequence number prepared by a two-phase checkpoint
+	SubSequenceNumberKey     = "CheckpointSubSequenceNumber"  // Last processed user record of the aggregated record at Checkpoint
+	RewindRequestKey         = "RewindRequest"                // Position to process the shard again from, cleared by the next checkpoint
+	LeaseCounterKey          = "LeaseCounter"                 // Incremented by every acquisition and renewal of a counter-based lease
+	OwnerSwitchesKey         = "OwnerSwitchesSinceCheckpoint" // Number of owners of a counter-based lease since its last checkpoint
 
 	// ShardEnd We've completely processed all records in this shard.
 	ShardEnd = "SHARD_END"
@@ -94,5 +103,11 @@ type Checkpointer interface {
 // ErrSequenceIDNotFound is returned by FetchCheckpoint when no SequenceID is found
 var ErrSequenceIDNotFound = errors.New("SequenceIDNotFoundForShard")
 
+// ErrLeaseLost is returned by CheckpointSequence when the lease is no longer held by the shard's owner
+var ErrLeaseLost = errors.New("LeaseLost")
+
+// ErrRewindRequested is returned by GetLease and CheckpointSequence when the shard was rewound since its consumer started
+var ErrRewindRequested = errors.New("RewindRequested")
+
 // ErrShardNotAssigned is returned by ListActiveWorkers when no AssignedTo is found
 var ErrShardNotAssigned = errors.New("AssignedToNotFoundForShard")
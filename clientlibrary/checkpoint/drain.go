@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// LeaseDrainer is implemented by checkpointers that let a draining worker hand its leases over.
+// A draining lease is claimed by another worker through ClaimShard, and the owner gives it up
+// on its next renewal when GetLease returns ErrShardClaimed.
+type LeaseDrainer interface {
+	// MarkLeaseDraining flags the lease held by this worker as waiting for another worker to claim it
+	MarkLeaseDraining(shard *par.ShardStatus) error
+}
+
+// MarkLeaseDraining sets the Draining column of a lease held by this worker
+func (checkpointer *DynamoCheckpoint) MarkLeaseDraining(shard *par.ShardStatus) error {
+	workerID := checkpointer.kclConfig.WorkerID
+	err := checkpointer.updateItem(shard.ID, "SET "+DrainingKey+" = :draining", LeaseOwnerKey+" = :draining",
+		map[string]types.AttributeValue{
+			":draining": &types.AttributeValueMemberS{Value: workerID},
+		})
+	if err != nil {
+		var conditionalCheckErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionalCheckErr) {
+			return ErrLeaseNotAcquired{"lease is not held by " + workerID}
+		}
+		return err
+	}
+
+	shard.SetDraining(workerID)
+	checkpointer.log.Infof("Lease on shard %s is waiting to be claimed by another worker", shard.ID)
+	return nil
+}
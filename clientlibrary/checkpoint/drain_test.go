@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestMarkLeaseDraining(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:   &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey: &types.AttributeValueMemberS{Value: "abc"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+	shard := &par.ShardStatus{ID: "0001", AssignedTo: "abc", Mux: &sync.RWMutex{}}
+
+	err := checkpoint.MarkLeaseDraining(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", svc.item[DrainingKey].(*types.AttributeValueMemberS).Value)
+	assert.True(t, shard.IsDraining())
+
+	// only the lease owner can hand its lease over
+	svc.item[LeaseOwnerKey] = &types.AttributeValueMemberS{Value: "ijkl-mnop"}
+	err = checkpoint.MarkLeaseDraining(shard)
+	assert.True(t, errors.As(err, &ErrLeaseNotAcquired{}))
+}
+
+func TestGetLeaseDrainingHandover(t *testing.T) {
+	leaseTimeout := time.Now().Add(10 * time.Second).UTC()
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:     &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:   &types.AttributeValueMemberS{Value: "abc"},
+			LeaseTimeoutKey: &types.AttributeValueMemberS{Value: leaseTimeout.Format(time.RFC3339Nano)},
+			DrainingKey:     &types.AttributeValueMemberS{Value: "abc"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+	shard := &par.ShardStatus{ID: "0001", LeaseTimeout: leaseTimeout, Mux: &sync.RWMutex{}}
+
+	// renewing keeps the lease draining
+	err := checkpoint.GetLease(shard, "abc")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", svc.putItemInput.Item[DrainingKey].(*types.AttributeValueMemberS).Value)
+
+	// once claimed, the draining owner gives the lease up even without lease stealing
+	svc.item[ClaimRequestKey] = &types.AttributeValueMemberS{Value: "ijkl-mnop"}
+	err = checkpoint.GetLease(shard, "abc")
+	if err == nil || err.Error() != ErrShardClaimed {
+		t.Errorf("Renewed a draining lease claimed by ijkl-mnop: %s", err)
+	}
+
+	// the claimant acquires the released lease, which is no longer draining
+	delete(svc.item, LeaseOwnerKey)
+	err = checkpoint.GetLease(shard, "ijkl-mnop")
+	assert.Nil(t, err)
+	_, hasDraining := svc.putItemInput.Item[DrainingKey]
+	assert.False(t, hasDraining)
+}
@@ -43,6 +43,17 @@ type DynamoDBAPI interface {
 	// you need to paginate the result set.
 	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
 
+	// You must provide the name of the partition key attribute and a single value for
+	// that attribute. Query returns all items with that partition key value.
+	// Optionally, you can provide a sort key attribute and use a comparison operator
+	// to refine the search results. Use the KeyConditionExpression parameter to
+	// provide a specific value for the partition key. The Query operation will return
+	// all of the items from the table or index with that partition key value. A single
+	// Query operation will read up to the maximum number of items set (if using the
+	// Limit parameter) or a maximum of 1 MB of data. If LastEvaluatedKey is present in
+	// the response, you will need to paginate the result set.
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+
 	// DescribeTable returns information about the table, including the current status of the table,
 	// when it was created, the primary key schema, and any indexes on the table. If
 	// you issue a DescribeTable request immediately after a CreateTable request,
@@ -66,6 +77,16 @@ type DynamoDBAPI interface {
 	// the table status.
 	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
 
+	// Modifies the provisioned throughput settings, global secondary indexes, or
+	// DynamoDB Streams settings for a given table. You can only perform one of the
+	// following operations at once: modify the provisioned throughput settings of the
+	// table, enable or disable DynamoDB Streams on the table, remove a global secondary
+	// index from the table, or create a new global secondary index on the table. After
+	// the index begins backfilling, you can use UpdateTable to perform other
+	// operations. UpdateTable is an asynchronous operation; while it is executing, the
+	// table status changes from ACTIVE to UPDATING.
+	UpdateTable(ctx context.Context, params *dynamodb.UpdateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error)
+
 	// PutItem creates a new item, or replaces an old item with a new item. If an item that has
 	// the same primary key as the new item already exists in the specified table, the
 	// new item completely replaces the existing item. You can perform a conditional
@@ -33,6 +33,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -53,6 +55,9 @@ const (
 
 var (
 	NoLeaseOwnerErr = errors.New("no LeaseOwner in checkpoints table")
+
+	// ErrLeaseTableNotFound is returned by Open when the lease table doesn't exist
+	ErrLeaseTableNotFound = errors.New("LeaseTableNotFound")
 )
 
 // DynamoCheckpoint implements the Checkpoint interface using DynamoDB as a backend
@@ -66,7 +71,24 @@ type DynamoCheckpoint struct {
 	svc           DynamoDBAPI
 	kclConfig     *config.KinesisClientLibConfiguration
 	Retries       int
-	lastLeaseSync time.Time
+	// leaseSnapshot is the last scan of the lease table, reused by syncLeases
+	leaseSnapshot    *LeaseSnapshot
+	leaseSnapshotMux sync.Mutex
+	// leaseOwnerIndexState tells whether ListLeasesByOwner can query the lease owner index
+	leaseOwnerIndexState leaseOwnerIndexState
+	// history, if set, receives every checkpoint change
+	history CheckpointHistorySink
+	// leaseCounters judges the expiry of counter-based leases, nil if leases expire at their LeaseTimeout
+	leaseCounters *leaseCounters
+}
+
+// leaseTableColumns are the columns of the lease table written by the checkpointer. The other columns of a lease,
+// written by other KCL implementations or tools, are preserved when the lease is written.
+var leaseTableColumns = map[string]bool{
+	LeaseKeyKey: true, LeaseOwnerKey: true, LeaseTimeoutKey: true, SequenceNumberKey: true, ParentShardIdKey: true,
+	ClaimRequestKey: true, StickyKey: true, StickyWorkerKey: true, StickyGroupKey: true, StickyExpiryKey: true,
+	DrainingKey: true, PendingCheckpointKey: true, SubSequenceNumberKey: true, RewindRequestKey: true,
+	LeaseCounterKey: true, OwnerSwitchesKey: true, AdjacentParentShardIdKey: true,
 }
 
 func NewDynamoCheckpoint(kclConfig *config.KinesisClientLibConfiguration) *DynamoCheckpoint {
@@ -80,17 +102,65 @@ func NewDynamoCheckpoint(kclConfig *config.KinesisClientLibConfiguration) *Dynam
 		Retries:                 NumMaxRetries,
 	}
 
+	// the Java KCL judges lease expiry from lease counters too
+	if kclConfig.CounterBasedLeases || kclConfig.LeaseTableSchema == config.LeaseTableSchemaJava {
+		checkpointer.leaseCounters = newLeaseCounters()
+	}
+
 	return checkpointer
 }
 
 // WithDynamoDB is used to provide DynamoDB service
 func (checkpointer *DynamoCheckpoint) WithDynamoDB(svc DynamoDBAPI) *DynamoCheckpoint {
-	checkpointer.svc = svc
+	checkpointer.svc = checkpointer.leaseTableDynamoDB(svc)
+	return checkpointer
+}
+
+// WithCheckpointHistory is used to record every checkpoint change in a history store
+func (checkpointer *DynamoCheckpoint) WithCheckpointHistory(history CheckpointHistorySink) *DynamoCheckpoint {
+	checkpointer.history = history
 	return checkpointer
 }
 
 // Init initialises the DynamoDB Checkpoint
 func (checkpointer *DynamoCheckpoint) Init() error {
+	checkpointer.initDynamoDB()
+
+	if err := checkpointer.initLeaseTable(); err != nil {
+		return err
+	}
+
+	// the history table shares the DynamoDB client of the lease table unless it was given its own
+	if history, ok := checkpointer.history.(*DynamoCheckpointHistory); ok {
+		if history.svc == nil {
+			history.svc = unwrapDynamoDB(checkpointer.svc)
+		}
+		return history.Init()
+	}
+
+	return nil
+}
+
+// Open connects to an existing lease table, unlike Init it never creates it. It returns ErrLeaseTableNotFound if
+// the lease table doesn't exist.
+func (checkpointer *DynamoCheckpoint) Open() error {
+	checkpointer.initDynamoDB()
+
+	_, err := checkpointer.svc.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(checkpointer.TableName),
+	})
+	var notFoundErr *types.ResourceNotFoundException
+	if errors.As(err, &notFoundErr) {
+		return fmt.Errorf("%w: %s", ErrLeaseTableNotFound, checkpointer.TableName)
+	}
+	if err != nil {
+		return checkpointer.dynamoDBError(err)
+	}
+	return nil
+}
+
+// initDynamoDB creates the DynamoDB client of the lease table, unless it was given one
+func (checkpointer *DynamoCheckpoint) initDynamoDB() {
 	checkpointer.log.Infof("Creating DynamoDB session")
 
 	if checkpointer.svc == nil {
@@ -120,14 +190,16 @@ func (checkpointer *DynamoCheckpoint) Init() error {
 			checkpointer.log.Fatalf("unable to load SDK config, %v", err)
 		}
 
-		checkpointer.svc = dynamodb.NewFromConfig(cfg)
+		checkpointer.svc = checkpointer.leaseTableDynamoDB(dynamodb.NewFromConfig(cfg))
 	}
+}
 
-	if !checkpointer.doesTableExist() {
-		return checkpointer.createTable()
+// leaseTableDynamoDB returns the client reading and writing the lease table in the configured layout
+func (checkpointer *DynamoCheckpoint) leaseTableDynamoDB(svc DynamoDBAPI) DynamoDBAPI {
+	if checkpointer.kclConfig.LeaseTableSchema == config.LeaseTableSchemaJava {
+		return newJavaSchemaDynamoDB(svc)
 	}
-
-	return nil
+	return svc
 }
 
 // GetLease attempts to gain a lock on the given shard
@@ -140,38 +212,38 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 	}
 
 	// Refresh sticky value from DynamoDB during lease acquisition/renewal
-	sticky := -1
-	var stickyWorker string
-	if stickyAttr, ok := currentCheckpoint[StickyKey]; ok {
-		if numAttr, ok := stickyAttr.(*types.AttributeValueMemberN); ok {
-			var parsedSticky int64
-			_, err := fmt.Sscanf(numAttr.Value, "%d", &parsedSticky)
-			if err == nil {
-				sticky = int(parsedSticky)
-			}
-		}
-	}
-	// Read StickyWorker attribute if present
-	if stickyWorkerAttr, ok := currentCheckpoint[StickyWorkerKey]; ok {
-		if strAttr, ok := stickyWorkerAttr.(*types.AttributeValueMemberS); ok {
-			stickyWorker = strAttr.Value
-		}
-	}
-	shard.SetSticky(sticky)
-	shard.SetStickyWorker(stickyWorker)
+	readStickyColumns(shard, currentCheckpoint)
 
 	isClaimRequestExpired := shard.IsClaimRequestExpired(checkpointer.kclConfig)
 
 	var claimRequest string
-	if checkpointer.kclConfig.EnableLeaseStealing {
-		if currentCheckpointClaimRequest, ok := currentCheckpoint[ClaimRequestKey]; ok &&
-			currentCheckpointClaimRequest.(*types.AttributeValueMemberS).Value != "" {
-			claimRequest = currentCheckpointClaimRequest.(*types.AttributeValueMemberS).Value
-			if newAssignTo != claimRequest && !isClaimRequestExpired {
-				checkpointer.log.Debugf("another worker: %s has a claim on this shard. Not going to renew the lease", claimRequest)
-				return errors.New(ErrShardClaimed)
-			}
-		}
+	if currentCheckpointClaimRequest, ok := currentCheckpoint[ClaimRequestKey]; ok &&
+		currentCheckpointClaimRequest.(*types.AttributeValueMemberS).Value != "" {
+		claimRequest = currentCheckpointClaimRequest.(*types.AttributeValueMemberS).Value
+	}
+
+	var draining string
+	if drainingVar, ok := currentCheckpoint[DrainingKey]; ok {
+		draining = drainingVar.(*types.AttributeValueMemberS).Value
+	}
+	shard.SetDraining(draining)
+	shard.SetPendingCheckpoint(readPendingCheckpoint(currentCheckpoint))
+
+	// A shard rewound since its consumer started is given up by its owner and restarted from the rewind target
+	rewindRequest := readRewindRequest(currentCheckpoint)
+	if owner, ok := currentCheckpoint[LeaseOwnerKey]; ok && owner.(*types.AttributeValueMemberS).Value == newAssignTo &&
+		shard.GetLeaseOwner() == newAssignTo && rewindRequest != "" && rewindRequest != shard.GetRewindRequest() {
+		checkpointer.log.Infof("Shard %s was rewound: %s. Not going to renew the lease", shard.ID, rewindRequest)
+		return rewindRequestedError(shard.ID)
+	}
+
+	// The worker a shard is pinned to can always claim it back and a draining owner hands its shards
+	// over to any claimant, even without lease stealing
+	honorClaim := checkpointer.kclConfig.EnableLeaseStealing || isStickyWorkerClaim(shard, claimRequest) ||
+		isDrainingLease(currentCheckpoint)
+	if honorClaim && claimRequest != "" && newAssignTo != claimRequest && !isClaimRequestExpired {
+		checkpointer.log.Debugf("another worker: %s has a claim on this shard. Not going to renew the lease", claimRequest)
+		return errors.New(ErrShardClaimed)
 	}
 
 	assignedVar, assignedToOk := currentCheckpoint[LeaseOwnerKey]
@@ -180,7 +252,12 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 	var conditionalExpression string
 	var expressionAttributeValues map[string]types.AttributeValue
 
-	if !leaseTimeoutOk || !assignedToOk {
+	if checkpointer.leaseCounters != nil {
+		conditionalExpression, expressionAttributeValues, err = checkpointer.leaseCounterCondition(shard.ID, currentCheckpoint, newAssignTo, isClaimRequestExpired)
+		if err != nil {
+			return err
+		}
+	} else if !leaseTimeoutOk || !assignedToOk {
 		conditionalExpression = "attribute_not_exists(AssignedTo)"
 	} else {
 		assignedTo := assignedVar.(*types.AttributeValueMemberS).Value
@@ -233,26 +310,47 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 			Value: shard.ParentShardId,
 		}
 	}
+	if len(shard.AdjacentParentShardId) > 0 {
+		marshalledCheckpoint[AdjacentParentShardIdKey] = &types.AttributeValueMemberS{
+			Value: shard.AdjacentParentShardId,
+		}
+	}
 
 	if checkpoint := shard.GetCheckpoint(); checkpoint != "" {
 		marshalledCheckpoint[SequenceNumberKey] = &types.AttributeValueMemberS{
 			Value: checkpoint,
 		}
+		writeSubSequenceNumber(shard, marshalledCheckpoint)
 	}
 
-	// Preserve Sticky attribute if it exists (>=0 means it's been set)
-	if sticky := shard.GetSticky(); sticky >= 0 {
-		marshalledCheckpoint[StickyKey] = &types.AttributeValueMemberN{
-			Value: fmt.Sprintf("%d", sticky),
-		}
+	// Preserve the sticky columns if they exist
+	writeStickyColumns(shard, marshalledCheckpoint)
+
+	// A prepared checkpoint survives lease renewal and handover until it is committed, and so does a rewind
+	writePendingCheckpoint(shard, marshalledCheckpoint)
+	if rewindRequest != "" {
+		marshalledCheckpoint[RewindRequestKey] = &types.AttributeValueMemberS{Value: rewindRequest}
 	}
 
-	// Preserve StickyWorker attribute if it exists
-	if stickyWorker := shard.GetStickyWorker(); stickyWorker != "" {
-		marshalledCheckpoint[StickyWorkerKey] = &types.AttributeValueMemberS{
-			Value: stickyWorker,
-		}
+	// Keep draining while the owner renews its lease, a new owner starts afresh
+	if draining != "" && draining == newAssignTo {
+		marshalledCheckpoint[DrainingKey] = &types.AttributeValueMemberS{Value: draining}
+	}
+
+	var leaseCounter int64
+	if checkpointer.leaseCounters != nil {
+		leaseCounter = writeLeaseCounterColumns(currentCheckpoint, newAssignTo, marshalledCheckpoint)
 	}
+	writeForeignColumns(currentCheckpoint, marshalledCheckpoint)
+
+	// The sticky columns are written back as they were read, the write fails rather than overwrite an update of the
+	// StickyAdmin API made in the meantime
+	conditionalExpression += " AND " + stickyCondition(currentCheckpoint, func(placeholder string, value types.AttributeValue) {
+		if expressionAttributeValues == nil {
+			expressionAttributeValues = make(map[string]types.AttributeValue)
+		}
+		expressionAttributeValues[placeholder] = value
+	})
 
 	if checkpointer.kclConfig.EnableLeaseStealing {
 		if claimRequest != "" && claimRequest == newAssignTo && !isClaimRequestExpired {
@@ -275,6 +373,10 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 		return err
 	}
 
+	if checkpointer.leaseCounters != nil {
+		checkpointer.leaseCounters.observe(shard.ID, newAssignTo, leaseCounter)
+	}
+
 	shard.Mux.Lock()
 	shard.AssignedTo = newAssignTo
 	shard.LeaseTimeout = newLeaseTimeout
@@ -284,50 +386,100 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *par.ShardStatus, newAssign
 }
 
 // CheckpointSequence writes a checkpoint at the designated sequence ID
+// The write is fenced by the lease: it only succeeds while the shard's owner still holds the lease, otherwise
+// ErrLeaseLost is returned. Only the checkpoint columns are updated: the lease columns are managed by GetLease
+// and the Sticky and StickyWorker columns are managed externally.
 func (checkpointer *DynamoCheckpoint) CheckpointSequence(shard *par.ShardStatus) error {
-	leaseTimeout := shard.GetLeaseTimeout().UTC().Format(time.RFC3339Nano)
-	marshalledCheckpoint := map[string]types.AttributeValue{
-		LeaseKeyKey: &types.AttributeValueMemberS{
-			Value: shard.ID,
-		},
-		SequenceNumberKey: &types.AttributeValueMemberS{
+	owner := shard.GetLeaseOwner()
+	updateExpression := "SET " + SequenceNumberKey + " = :checkpoint"
+	expressionAttributeValues := map[string]types.AttributeValue{
+		":checkpoint": &types.AttributeValueMemberS{
 			Value: shard.GetCheckpoint(),
 		},
-		LeaseOwnerKey: &types.AttributeValueMemberS{
-			Value: shard.GetLeaseOwner(),
-		},
-		LeaseTimeoutKey: &types.AttributeValueMemberS{
-			Value: leaseTimeout,
+		":assigned_to": &types.AttributeValueMemberS{
+			Value: owner,
 		},
 	}
 
 	if len(shard.ParentShardId) > 0 {
-		marshalledCheckpoint[ParentShardIdKey] = &types.AttributeValueMemberS{Value: shard.ParentShardId}
+		updateExpression += ", " + ParentShardIdKey + " = :parent_shard"
+		expressionAttributeValues[":parent_shard"] = &types.AttributeValueMemberS{Value: shard.ParentShardId}
+	}
+	if len(shard.AdjacentParentShardId) > 0 {
+		updateExpression += ", " + AdjacentParentShardIdKey + " = :adjacent_parent_shard"
+		expressionAttributeValues[":adjacent_parent_shard"] = &types.AttributeValueMemberS{Value: shard.AdjacentParentShardId}
 	}
 
-	// Preserve Sticky attribute if it exists (>=0 means it's been set)
-	if sticky := shard.GetSticky(); sticky >= 0 {
-		marshalledCheckpoint[StickyKey] = &types.AttributeValueMemberN{
-			Value: fmt.Sprintf("%d", sticky),
+	// A checkpoint also commits the rewind the consumer started from, but never a rewind requested since
+	conditionExpression := LeaseOwnerKey + " = :assigned_to AND attribute_not_exists(" + RewindRequestKey + ")"
+	if rewindRequest := shard.GetRewindRequest(); rewindRequest != "" {
+		conditionExpression = LeaseOwnerKey + " = :assigned_to AND (attribute_not_exists(" + RewindRequestKey + ") OR " +
+			RewindRequestKey + " = :rewind_request)"
+		expressionAttributeValues[":rewind_request"] = &types.AttributeValueMemberS{Value: rewindRequest}
+	}
+
+	// like the Java KCL, count the owners of a counter-based lease since its last checkpoint
+	if checkpointer.leaseCounters != nil {
+		updateExpression += ", " + OwnerSwitchesKey + " = :owner_switches"
+		expressionAttributeValues[":owner_switches"] = &types.AttributeValueMemberN{Value: "0"}
+	}
+
+	removeExpression := " REMOVE " + ClaimRequestKey + ", " + PendingCheckpointKey + ", " + RewindRequestKey
+	if subSequenceNumber := shard.GetCheckpointSubSequenceNumber(); subSequenceNumber != nil {
+		updateExpression += ", " + SubSequenceNumberKey + " = :sub_sequence_number"
+		expressionAttributeValues[":sub_sequence_number"] = &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(*subSequenceNumber, 10),
 		}
+	} else {
+		removeExpression += ", " + SubSequenceNumberKey
 	}
 
-	// Preserve StickyWorker attribute if it exists
-	if stickyWorker := shard.GetStickyWorker(); stickyWorker != "" {
-		marshalledCheckpoint[StickyWorkerKey] = &types.AttributeValueMemberS{
-			Value: stickyWorker,
+	// A checkpoint supersedes any pending claim on the shard and commits or discards a prepared checkpoint
+	updateExpression += removeExpression
+
+	returnValues := types.ReturnValueNone
+	if checkpointer.history != nil {
+		returnValues = types.ReturnValueAllOld
+	}
+	previous, err := checkpointer.updateItemReturning(shard.ID, updateExpression, conditionExpression, expressionAttributeValues, returnValues)
+	if err != nil {
+		var conditionalCheckErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionalCheckErr) {
+			return checkpointer.checkpointRejectedError(shard.ID, owner)
+		}
+		return err
+	}
+
+	if checkpointer.history != nil {
+		reason := CheckpointReasonCheckpoint
+		if shard.GetCheckpoint() == ShardEnd {
+			reason = CheckpointReasonShardEnd
 		}
+		checkpointer.recordCheckpointChange(shard.ID, previous, shard.GetCheckpoint(), shard.GetCheckpointSubSequenceNumber(), owner, reason)
 	}
 
-	return checkpointer.saveItem(marshalledCheckpoint)
+	return nil
 }
 
 // FetchCheckpoint retrieves the checkpoint for the given shard
 func (checkpointer *DynamoCheckpoint) FetchCheckpoint(shard *par.ShardStatus) error {
+	_, err := checkpointer.fetchCheckpoint(shard)
+	return err
+}
+
+// fetchCheckpoint retrieves the checkpoint for the given shard and returns its lease row
+func (checkpointer *DynamoCheckpoint) fetchCheckpoint(shard *par.ShardStatus) (map[string]types.AttributeValue, error) {
 	checkpoint, err := checkpointer.getItem(shard.ID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return checkpoint, checkpointer.readCheckpoint(shard, checkpoint)
+}
+
+// readCheckpoint sets the checkpoint and the lease of the shard from its lease row
+func (checkpointer *DynamoCheckpoint) readCheckpoint(shard *par.ShardStatus, checkpoint map[string]types.AttributeValue) error {
+	// a rewound shard may have no checkpoint until its consumer checkpoints again
+	shard.SetRewindRequest(readRewindRequest(checkpoint))
 
 	sequenceID, ok := checkpoint[SequenceNumberKey]
 	if !ok {
@@ -336,13 +488,21 @@ func (checkpointer *DynamoCheckpoint) FetchCheckpoint(shard *par.ShardStatus) er
 
 	checkpointer.log.Debugf("Retrieved Shard Iterator %s", sequenceID.(*types.AttributeValueMemberS).Value)
 	shard.SetCheckpoint(sequenceID.(*types.AttributeValueMemberS).Value)
+	shard.SetCheckpointSubSequenceNumber(readSubSequenceNumber(checkpoint))
 
 	if assignedTo, ok := checkpoint[LeaseOwnerKey]; ok {
 		shard.SetLeaseOwner(assignedTo.(*types.AttributeValueMemberS).Value)
 	}
 
-	// Use up-to-date leaseTimeout to avoid ConditionalCheckFailedException when claiming
-	if leaseTimeout, ok := checkpoint[LeaseTimeoutKey]; ok && leaseTimeout.(*types.AttributeValueMemberS).Value != "" {
+	// Counter-based leases expire a lease duration after this worker first saw their counter, on its own clock
+	if owner, ok := checkpoint[LeaseOwnerKey].(*types.AttributeValueMemberS); ok && checkpointer.leaseCounters != nil {
+		counter, _ := readLeaseCounter(checkpoint)
+		leaseDuration := time.Duration(checkpointer.LeaseDuration) * time.Millisecond
+		unchangedFor := checkpointer.leaseCounters.unchangedFor(shard.ID, owner.Value, counter)
+		shard.SetLeaseTimeout(time.Now().UTC().Add(leaseDuration - unchangedFor))
+	} else if leaseTimeout, ok := checkpoint[LeaseTimeoutKey]; ok && checkpointer.leaseCounters == nil &&
+		leaseTimeout.(*types.AttributeValueMemberS).Value != "" {
+		// Use up-to-date leaseTimeout to avoid ConditionalCheckFailedException when claiming
 		currentLeaseTimeout, err := time.Parse(time.RFC3339Nano, leaseTimeout.(*types.AttributeValueMemberS).Value)
 		if err != nil {
 			return err
@@ -351,26 +511,20 @@ func (checkpointer *DynamoCheckpoint) FetchCheckpoint(shard *par.ShardStatus) er
 	}
 
 	// Read sticky column if present (optional, read-only)
-	// Default to -1 if missing or invalid
-	sticky := -1
-	var stickyWorker string
-	if stickyAttr, ok := checkpoint[StickyKey]; ok {
-		if numAttr, ok := stickyAttr.(*types.AttributeValueMemberN); ok {
-			var parsedSticky int64
-			_, err := fmt.Sscanf(numAttr.Value, "%d", &parsedSticky)
-			if err == nil {
-				sticky = int(parsedSticky)
-			}
-		}
+	readStickyColumns(shard, checkpoint)
+
+	claimRequest := ""
+	if claimRequestVar, ok := checkpoint[ClaimRequestKey]; ok {
+		claimRequest = claimRequestVar.(*types.AttributeValueMemberS).Value
 	}
-	// Read StickyWorker attribute if present
-	if stickyWorkerAttr, ok := checkpoint[StickyWorkerKey]; ok {
-		if strAttr, ok := stickyWorkerAttr.(*types.AttributeValueMemberS); ok {
-			stickyWorker = strAttr.Value
-		}
+	shard.SetClaimRequest(claimRequest)
+
+	draining := ""
+	if drainingVar, ok := checkpoint[DrainingKey]; ok {
+		draining = drainingVar.(*types.AttributeValueMemberS).Value
 	}
-	shard.SetSticky(sticky)
-	shard.SetStickyWorker(stickyWorker)
+	shard.SetDraining(draining)
+	shard.SetPendingCheckpoint(readPendingCheckpoint(checkpoint))
 
 	return nil
 }
@@ -408,7 +562,7 @@ func (checkpointer *DynamoCheckpoint) RemoveLeaseOwner(shardID string) error {
 
 	_, err := checkpointer.svc.UpdateItem(context.TODO(), input)
 
-	return err
+	return checkpointer.dynamoDBError(err)
 }
 
 // GetLeaseOwner returns current lease owner of given shard in checkpoints table
@@ -428,15 +582,20 @@ func (checkpointer *DynamoCheckpoint) GetLeaseOwner(shardID string) (string, err
 
 }
 
-// ListActiveWorkers returns a map of workers and their shards
+// ListActiveWorkers returns a map of workers and their shards. The shards are the leases of the last scan of the
+// lease table, or the given shard status for shards without lease.
 func (checkpointer *DynamoCheckpoint) ListActiveWorkers(shardStatus map[string]*par.ShardStatus) (map[string][]*par.ShardStatus, error) {
-	err := checkpointer.syncLeases(shardStatus)
+	snapshot, err := checkpointer.syncLeases(shardStatus)
 	if err != nil {
 		return nil, err
 	}
 
 	workers := map[string][]*par.ShardStatus{}
 	for _, shard := range shardStatus {
+		if lease, ok := snapshot.Lease(shard.ID); ok && lease.GetLeaseOwner() != "" && lease.GetCheckpoint() != "" {
+			shard = lease
+		}
+
 		if shard.GetCheckpoint() == ShardEnd {
 			continue
 		}
@@ -458,29 +617,21 @@ func (checkpointer *DynamoCheckpoint) ListActiveWorkers(shardStatus map[string]*
 
 // ClaimShard places a claim request on a shard to signal a steal attempt
 func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID string) error {
-	err := checkpointer.FetchCheckpoint(shard)
+	currentCheckpoint, err := checkpointer.fetchCheckpoint(shard)
 	if err != nil && err != ErrSequenceIDNotFound {
 		return err
 	}
-	leaseTimeoutString := shard.GetLeaseTimeout().Format(time.RFC3339Nano)
-
-	conditionalExpression := `ShardID = :id AND LeaseTimeout = :lease_timeout AND attribute_not_exists(ClaimRequest)`
+	conditionalExpression := `ShardID = :id AND attribute_not_exists(ClaimRequest)`
 	expressionAttributeValues := map[string]types.AttributeValue{
 		":id": &types.AttributeValueMemberS{
 			Value: shard.ID,
 		},
-		":lease_timeout": &types.AttributeValueMemberS{
-			Value: leaseTimeoutString,
-		},
 	}
 
 	marshalledCheckpoint := map[string]types.AttributeValue{
 		LeaseKeyKey: &types.AttributeValueMemberS{
 			Value: shard.ID,
 		},
-		LeaseTimeoutKey: &types.AttributeValueMemberS{
-			Value: leaseTimeoutString,
-		},
 		SequenceNumberKey: &types.AttributeValueMemberS{
 			Value: shard.Checkpoint,
 		},
@@ -489,14 +640,34 @@ func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID
 		},
 	}
 
+	if checkpointer.leaseCounters != nil {
+		// the claim is fenced by the lease counter, the lease timeout of the owner is kept as is
+		if counter, ok := readLeaseCounter(currentCheckpoint); ok {
+			conditionalExpression += " AND " + LeaseCounterKey + " = :lease_counter"
+			expressionAttributeValues[":lease_counter"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(counter, 10)}
+		} else {
+			conditionalExpression += " AND attribute_not_exists(" + LeaseCounterKey + ")"
+		}
+		if leaseTimeout, ok := currentCheckpoint[LeaseTimeoutKey]; ok {
+			marshalledCheckpoint[LeaseTimeoutKey] = leaseTimeout
+		}
+	} else {
+		leaseTimeoutString := shard.GetLeaseTimeout().Format(time.RFC3339Nano)
+		conditionalExpression += " AND LeaseTimeout = :lease_timeout"
+		expressionAttributeValues[":lease_timeout"] = &types.AttributeValueMemberS{Value: leaseTimeoutString}
+		marshalledCheckpoint[LeaseTimeoutKey] = &types.AttributeValueMemberS{Value: leaseTimeoutString}
+	}
+
 	if leaseOwner := shard.GetLeaseOwner(); leaseOwner == "" {
 		conditionalExpression += " AND attribute_not_exists(AssignedTo)"
 	} else {
 		marshalledCheckpoint[LeaseOwnerKey] = &types.AttributeValueMemberS{Value: leaseOwner}
-		conditionalExpression += "AND AssignedTo = :assigned_to"
+		conditionalExpression += " AND AssignedTo = :assigned_to"
 		expressionAttributeValues[":assigned_to"] = &types.AttributeValueMemberS{Value: leaseOwner}
 	}
 
+	writeSubSequenceNumber(shard, marshalledCheckpoint)
+
 	if checkpoint := shard.GetCheckpoint(); checkpoint == "" {
 		conditionalExpression += " AND attribute_not_exists(Checkpoint)"
 	} else if checkpoint == ShardEnd {
@@ -514,65 +685,216 @@ func (checkpointer *DynamoCheckpoint) ClaimShard(shard *par.ShardStatus, claimID
 		conditionalExpression += " AND ParentShardId = :parent_shard"
 		expressionAttributeValues[":parent_shard"] = &types.AttributeValueMemberS{Value: shard.ParentShardId}
 	}
+	if shard.AdjacentParentShardId != "" {
+		marshalledCheckpoint[AdjacentParentShardIdKey] = &types.AttributeValueMemberS{Value: shard.AdjacentParentShardId}
+	}
+
+	// Preserve the sticky columns if they exist
+	writeStickyColumns(shard, marshalledCheckpoint)
+
+	if draining := shard.GetDraining(); draining != "" {
+		marshalledCheckpoint[DrainingKey] = &types.AttributeValueMemberS{Value: draining}
+	}
+
+	writePendingCheckpoint(shard, marshalledCheckpoint)
+	if rewindRequest := shard.GetRewindRequest(); rewindRequest != "" {
+		marshalledCheckpoint[RewindRequestKey] = &types.AttributeValueMemberS{Value: rewindRequest}
+	}
+
+	// a claim doesn't renew the lease: its counter is kept as is
+	for _, key := range []string{LeaseCounterKey, OwnerSwitchesKey} {
+		if value, ok := currentCheckpoint[key]; ok {
+			marshalledCheckpoint[key] = value
+		}
+	}
+	writeForeignColumns(currentCheckpoint, marshalledCheckpoint)
+
+	return checkpointer.conditionalUpdate(conditionalExpression, expressionAttributeValues, marshalledCheckpoint)
+}
+
+// syncLeases scans the lease table unless it was scanned within LeaseSyncingTimeIntervalMillis, and copies the
+// owners and checkpoints of the leases to the shard status. It returns the snapshot of the last scan.
+func (checkpointer *DynamoCheckpoint) syncLeases(shardStatus map[string]*par.ShardStatus) (*LeaseSnapshot, error) {
+	log := checkpointer.kclConfig.Logger
+	syncInterval := time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis) * time.Millisecond
+
+	if snapshot := checkpointer.lastLeaseSnapshot(); snapshot != nil && snapshot.ScannedAt.Add(syncInterval).After(time.Now()) {
+		return snapshot, nil
+	}
+
+	snapshot, err := checkpointer.ScanLeases()
+	if err != nil {
+		log.Debugf("Error performing DynamoDB Scan. Error: %+v ", err)
+		return nil, err
+	}
+
+	for _, shard := range shardStatus {
+		lease, ok := snapshot.Lease(shard.ID)
+		if !ok || lease.GetLeaseOwner() == "" || lease.GetCheckpoint() == "" {
+			continue
+		}
+		shard.SetLeaseOwner(lease.GetLeaseOwner())
+		shard.SetCheckpoint(lease.GetCheckpoint())
+	}
+
+	log.Debugf("Lease sync completed, %d leases. Next lease sync will occur in %s", snapshot.Len(), syncInterval)
+	return snapshot, nil
+}
+
+// readStickyColumns copies the optional Sticky, StickyWorker, StickyGroup and StickyExpiry columns of a lease entry
+// into the shard status. Sticky defaults to StickyUnset if missing or not a number.
+func readStickyColumns(shard *par.ShardStatus, item map[string]types.AttributeValue) {
+	sticky := int(par.StickyUnset)
+	if stickyAttr, ok := item[StickyKey]; ok {
+		if numAttr, ok := stickyAttr.(*types.AttributeValueMemberN); ok {
+			var parsedSticky int64
+			_, err := fmt.Sscanf(numAttr.Value, "%d", &parsedSticky)
+			if err == nil {
+				sticky = int(parsedSticky)
+			}
+		}
+	}
+
+	var stickyWorker string
+	if stickyWorkerAttr, ok := item[StickyWorkerKey]; ok {
+		if strAttr, ok := stickyWorkerAttr.(*types.AttributeValueMemberS); ok {
+			stickyWorker = strAttr.Value
+		}
+	}
+
+	var stickyGroup string
+	if stickyGroupAttr, ok := item[StickyGroupKey]; ok {
+		if strAttr, ok := stickyGroupAttr.(*types.AttributeValueMemberS); ok {
+			stickyGroup = strAttr.Value
+		}
+	}
+
+	var stickyExpiry time.Time
+	if stickyExpiryAttr, ok := item[StickyExpiryKey]; ok {
+		if strAttr, ok := stickyExpiryAttr.(*types.AttributeValueMemberS); ok {
+			// an unparsable expiry is ignored, i.e. the pin never expires
+			if parsedExpiry, err := time.Parse(time.RFC3339Nano, strAttr.Value); err == nil {
+				stickyExpiry = parsedExpiry
+			}
+		}
+	}
+
+	shard.SetSticky(sticky)
+	shard.SetStickyWorker(stickyWorker)
+	shard.SetStickyGroup(stickyGroup)
+	shard.SetStickyExpiry(stickyExpiry)
+}
 
-	// Preserve Sticky attribute if it exists (>=0 means it's been set)
+// writeStickyColumns adds the sticky columns cached in the shard status to a lease entry
+// so that full-row writes do not erase them.
+func writeStickyColumns(shard *par.ShardStatus, item map[string]types.AttributeValue) {
+	// >=0 means the Sticky column has been set
 	if sticky := shard.GetSticky(); sticky >= 0 {
-		marshalledCheckpoint[StickyKey] = &types.AttributeValueMemberN{
+		item[StickyKey] = &types.AttributeValueMemberN{
 			Value: fmt.Sprintf("%d", sticky),
 		}
 	}
 
-	// Preserve StickyWorker attribute if it exists
 	if stickyWorker := shard.GetStickyWorker(); stickyWorker != "" {
-		marshalledCheckpoint[StickyWorkerKey] = &types.AttributeValueMemberS{
+		item[StickyWorkerKey] = &types.AttributeValueMemberS{
 			Value: stickyWorker,
 		}
 	}
 
-	return checkpointer.conditionalUpdate(conditionalExpression, expressionAttributeValues, marshalledCheckpoint)
+	if stickyGroup := shard.GetStickyGroup(); stickyGroup != "" {
+		item[StickyGroupKey] = &types.AttributeValueMemberS{
+			Value: stickyGroup,
+		}
+	}
+
+	if stickyExpiry := shard.GetStickyExpiry(); !stickyExpiry.IsZero() {
+		item[StickyExpiryKey] = &types.AttributeValueMemberS{
+			Value: stickyExpiry.UTC().Format(time.RFC3339Nano),
+		}
+	}
 }
 
-func (checkpointer *DynamoCheckpoint) syncLeases(shardStatus map[string]*par.ShardStatus) error {
-	log := checkpointer.kclConfig.Logger
+// stickyCondition returns the condition that the sticky columns of a lease entry still hold the values of item. The
+// values the condition refers to are passed to setValue.
+func stickyCondition(item map[string]types.AttributeValue, setValue func(placeholder string, value types.AttributeValue)) string {
+	columns := []struct{ key, placeholder string }{
+		{StickyKey, ":read_sticky"},
+		{StickyWorkerKey, ":read_sticky_worker"},
+		{StickyGroupKey, ":read_sticky_group"},
+		{StickyExpiryKey, ":read_sticky_expiry"},
+	}
+
+	var condition string
+	for i, column := range columns {
+		if i > 0 {
+			condition += " AND "
+		}
+		value, ok := item[column.key]
+		if !ok {
+			condition += "attribute_not_exists(" + column.key + ")"
+			continue
+		}
+		condition += column.key + " = " + column.placeholder
+		setValue(column.placeholder, value)
+	}
+	return condition
+}
 
-	if (checkpointer.lastLeaseSync.Add(time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis) * time.Millisecond)).After(time.Now()) {
+// readSubSequenceNumber returns the sub-sequence number of the checkpoint of a lease row or nil
+func readSubSequenceNumber(item map[string]types.AttributeValue) *int64 {
+	numAttr, ok := item[SubSequenceNumberKey].(*types.AttributeValueMemberN)
+	if !ok {
 		return nil
 	}
 
-	checkpointer.lastLeaseSync = time.Now()
-	input := &dynamodb.ScanInput{
-		ProjectionExpression: aws.String(fmt.Sprintf("%s,%s,%s", LeaseKeyKey, LeaseOwnerKey, SequenceNumberKey)),
-		Select:               "SPECIFIC_ATTRIBUTES",
-		TableName:            aws.String(checkpointer.kclConfig.TableName),
+	subSequenceNumber, err := strconv.ParseInt(numAttr.Value, 10, 64)
+	if err != nil {
+		return nil
 	}
+	return &subSequenceNumber
+}
 
-	scanOutput, err := checkpointer.svc.Scan(context.TODO(), input)
+// readRewindRequest returns the RewindRequest column of a lease row or an empty string
+func readRewindRequest(item map[string]types.AttributeValue) string {
+	if rewindRequest, ok := item[RewindRequestKey].(*types.AttributeValueMemberS); ok {
+		return rewindRequest.Value
+	}
+	return ""
+}
 
-	if err != nil {
-		log.Debugf("Error performing DynamoDB Scan. Error: %+v ", err)
-		return err
+// writeSubSequenceNumber preserves the sub-sequence number of the checkpoint of the shard in a lease row
+func writeSubSequenceNumber(shard *par.ShardStatus, item map[string]types.AttributeValue) {
+	if subSequenceNumber := shard.GetCheckpointSubSequenceNumber(); subSequenceNumber != nil {
+		item[SubSequenceNumberKey] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*subSequenceNumber, 10)}
 	}
+}
 
-	results := scanOutput.Items
-	for _, result := range results {
-		shardId, foundShardId := result[LeaseKeyKey]
-		assignedTo, foundAssignedTo := result[LeaseOwnerKey]
-		checkpoint, foundCheckpoint := result[SequenceNumberKey]
-		if !foundShardId || !foundAssignedTo || !foundCheckpoint {
-			continue
+// writeForeignColumns copies the columns of a lease row which weren't written by the checkpointer to the new lease row
+func writeForeignColumns(current, item map[string]types.AttributeValue) {
+	for key, value := range current {
+		if _, ok := item[key]; !ok && !leaseTableColumns[key] {
+			item[key] = value
 		}
+	}
+}
 
-		if shard, ok := shardStatus[shardId.(*types.AttributeValueMemberS).Value]; ok {
-			shard.SetLeaseOwner(assignedTo.(*types.AttributeValueMemberS).Value)
-			shard.SetCheckpoint(checkpoint.(*types.AttributeValueMemberS).Value)
-		}
+// isDrainingLease returns true if the owner of the lease entry is draining
+func isDrainingLease(item map[string]types.AttributeValue) bool {
+	owner, ok := item[LeaseOwnerKey].(*types.AttributeValueMemberS)
+	if !ok {
+		return false
 	}
+	draining, ok := item[DrainingKey].(*types.AttributeValueMemberS)
+	return ok && draining.Value != "" && draining.Value == owner.Value
+}
 
-	log.Debugf("Lease sync completed. Next lease sync will occur in %s", time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis)*time.Millisecond)
-	return nil
+// isStickyWorkerClaim returns true if the claim was placed by the worker the shard is pinned to
+func isStickyWorkerClaim(shard *par.ShardStatus, claimRequest string) bool {
+	return claimRequest != "" && shard.GetStickyState() == par.StickyPinned && shard.GetStickyWorker() == claimRequest
 }
 
-func (checkpointer *DynamoCheckpoint) createTable() error {
+// initLeaseTable creates the lease table unless it exists and waits until it is ACTIVE
+func (checkpointer *DynamoCheckpoint) initLeaseTable() error {
 	input := &dynamodb.CreateTableInput{
 		AttributeDefinitions: []types.AttributeDefinition{
 			{
@@ -592,9 +914,23 @@ func (checkpointer *DynamoCheckpoint) createTable() error {
 		},
 		TableName: aws.String(checkpointer.TableName),
 	}
-	_, err := checkpointer.svc.CreateTable(context.Background(), input)
+	if checkpointer.kclConfig.LeaseOwnerIndex {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: aws.String(LeaseOwnerKey),
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+		input.GlobalSecondaryIndexes = []types.GlobalSecondaryIndex{checkpointer.leaseOwnerIndex()}
+	}
 
-	return err
+	timeout := time.Duration(checkpointer.kclConfig.LeaseTableActiveTimeoutMillis) * time.Millisecond
+	if err := initTable(checkpointer.svc, input, checkpointer.kclConfig.LeaseTableOptions, timeout); err != nil {
+		return checkpointer.dynamoDBError(err)
+	}
+
+	if checkpointer.kclConfig.LeaseOwnerIndex {
+		return checkpointer.initLeaseOwnerIndex()
+	}
+	return nil
 }
 
 func (checkpointer *DynamoCheckpoint) doesTableExist() bool {
@@ -606,11 +942,33 @@ func (checkpointer *DynamoCheckpoint) doesTableExist() bool {
 	return err == nil
 }
 
-func (checkpointer *DynamoCheckpoint) saveItem(item map[string]types.AttributeValue) error {
-	return checkpointer.putItem(&dynamodb.PutItemInput{
+func (checkpointer *DynamoCheckpoint) updateItem(shardID, updateExpression, conditionExpression string, expressionAttributeValues map[string]types.AttributeValue) error {
+	_, err := checkpointer.updateItemReturning(shardID, updateExpression, conditionExpression, expressionAttributeValues, types.ReturnValueNone)
+	return err
+}
+
+// updateItemReturning updates the lease of the shard and returns the attributes selected by returnValues
+func (checkpointer *DynamoCheckpoint) updateItemReturning(shardID, updateExpression, conditionExpression string, expressionAttributeValues map[string]types.AttributeValue, returnValues types.ReturnValue) (map[string]types.AttributeValue, error) {
+	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(checkpointer.TableName),
-		Item:      item,
-	})
+		Key: map[string]types.AttributeValue{
+			LeaseKeyKey: &types.AttributeValueMemberS{
+				Value: shardID,
+			},
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ExpressionAttributeValues: expressionAttributeValues,
+		ReturnValues:              returnValues,
+	}
+	if conditionExpression != "" {
+		input.ConditionExpression = aws.String(conditionExpression)
+	}
+
+	output, err := checkpointer.svc.UpdateItem(context.Background(), input)
+	if err != nil {
+		return nil, checkpointer.dynamoDBError(err)
+	}
+	return output.Attributes, nil
 }
 
 func (checkpointer *DynamoCheckpoint) conditionalUpdate(conditionExpression string, expressionAttributeValues map[string]types.AttributeValue, item map[string]types.AttributeValue) error {
@@ -624,7 +982,7 @@ func (checkpointer *DynamoCheckpoint) conditionalUpdate(conditionExpression stri
 
 func (checkpointer *DynamoCheckpoint) putItem(input *dynamodb.PutItemInput) error {
 	_, err := checkpointer.svc.PutItem(context.Background(), input)
-	return err
+	return checkpointer.dynamoDBError(err)
 }
 
 func (checkpointer *DynamoCheckpoint) getItem(shardID string) (map[string]types.AttributeValue, error) {
@@ -640,10 +998,10 @@ func (checkpointer *DynamoCheckpoint) getItem(shardID string) (map[string]types.
 
 	// fix problem when starts the environment from scratch (dynamo table is empty)
 	if item == nil {
-		return nil, err
+		return nil, checkpointer.dynamoDBError(err)
 	}
 
-	return item.Item, err
+	return item.Item, checkpointer.dynamoDBError(err)
 }
 
 func (checkpointer *DynamoCheckpoint) removeItem(shardID string) error {
@@ -656,5 +1014,5 @@ func (checkpointer *DynamoCheckpoint) removeItem(shardID string) error {
 		},
 	})
 
-	return err
+	return checkpointer.dynamoDBError(err)
 }
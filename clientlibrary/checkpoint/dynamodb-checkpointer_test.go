@@ -37,9 +37,11 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
 
 	cfg "github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
@@ -60,6 +62,19 @@ func TestDoesTableExist(t *testing.T) {
 	}
 }
 
+func TestOpenLeaseTable(t *testing.T) {
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpoint.Open())
+
+	// a missing lease table is never created
+	svc = &mockDynamoDB{tableExist: false}
+	checkpoint = NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.ErrorIs(t, checkpoint.Open(), ErrLeaseTableNotFound)
+	assert.False(t, svc.tableExist)
+}
+
 func TestGetLeaseNotAcquired(t *testing.T) {
 	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
 	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
@@ -198,6 +213,48 @@ func TestGetLeaseShardClaimed(t *testing.T) {
 	}
 }
 
+func TestGetLeaseStickyWorkerClaim(t *testing.T) {
+	leaseTimeout := time.Now().Add(10 * time.Second).UTC()
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item: map[string]types.AttributeValue{
+			LeaseOwnerKey:   &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			LeaseTimeoutKey: &types.AttributeValueMemberS{Value: leaseTimeout.Format(time.RFC3339Nano)},
+			ClaimRequestKey: &types.AttributeValueMemberS{Value: "ijkl-mnop"},
+			StickyKey:       &types.AttributeValueMemberN{Value: "10"},
+			StickyWorkerKey: &types.AttributeValueMemberS{Value: "ijkl-mnop"},
+		},
+	}
+	// lease stealing is disabled: only the worker the shard is pinned to may claim it back
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithMaxRecords(10).
+		WithMaxLeasesForWorker(1).
+		WithShardSyncIntervalMillis(5000).
+		WithFailoverTimeMillis(300000)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+	err := checkpoint.GetLease(&par.ShardStatus{
+		ID:           "0001",
+		LeaseTimeout: leaseTimeout,
+		Mux:          &sync.RWMutex{},
+	}, "abcd-efgh")
+	if err == nil || err.Error() != ErrShardClaimed {
+		t.Errorf("Renewed a lease claimed back by its sticky worker: %s", err)
+	}
+
+	// a claim from any other worker is ignored
+	svc.item[StickyWorkerKey] = &types.AttributeValueMemberS{Value: "qrst-uvwx"}
+	err = checkpoint.GetLease(&par.ShardStatus{
+		ID:           "0001",
+		LeaseTimeout: leaseTimeout,
+		Mux:          &sync.RWMutex{},
+	}, "abcd-efgh")
+	assert.Nil(t, err)
+	assert.Equal(t, "qrst-uvwx", svc.item[StickyWorkerKey].(*types.AttributeValueMemberS).Value)
+}
+
 func TestGetLeaseClaimRequestExpiredOwner(t *testing.T) {
 	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
 		WithInitialPositionInStream(cfg.LATEST).
@@ -308,6 +365,10 @@ func TestFetchCheckpointWithStealing(t *testing.T) {
 
 	leaseTimeout, _ := time.Parse(time.RFC3339, svc.item[LeaseTimeoutKey].(*types.AttributeValueMemberS).Value)
 	assert.Equal(t, leaseTimeout, status.LeaseTimeout)
+
+	svc.item[ClaimRequestKey] = &types.AttributeValueMemberS{Value: "ijkl-mnop"}
+	_ = checkpoint.FetchCheckpoint(status)
+	assert.Equal(t, "ijkl-mnop", status.GetClaimRequest())
 }
 
 func TestGetLeaseConditional(t *testing.T) {
@@ -485,3 +546,116 @@ func TestClaimShard(t *testing.T) {
 	assert.Equal(t, shard.Checkpoint, status.Checkpoint)
 	assert.Equal(t, shard.ParentShardId, status.ParentShardId)
 }
+
+func TestCheckpointSequenceFencedByLease(t *testing.T) {
+	leaseTimeout := time.Now().Add(10 * time.Second).UTC().Format(time.RFC3339Nano)
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "ijkl-mnop"},
+			LeaseTimeoutKey:   &types.AttributeValueMemberS{Value: leaseTimeout},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "200"},
+		},
+	}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abcd-efgh")
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	// a worker which lost its lease to ijkl-mnop can't roll the shard back
+	shard := &par.ShardStatus{
+		ID:           "0001",
+		AssignedTo:   "abcd-efgh",
+		Checkpoint:   "100",
+		LeaseTimeout: time.Now().Add(-10 * time.Second),
+		Mux:          &sync.RWMutex{},
+	}
+	err := checkpoint.CheckpointSequence(shard)
+	assert.ErrorIs(t, err, ErrLeaseLost)
+	assert.True(t, errors.As(err, &kcl.ShutdownError{}))
+	assert.Equal(t, "200", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, "ijkl-mnop", svc.item[LeaseOwnerKey].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, leaseTimeout, svc.item[LeaseTimeoutKey].(*types.AttributeValueMemberS).Value)
+
+	// the lease owner checkpoints without touching the lease columns
+	shard.SetLeaseOwner("ijkl-mnop")
+	shard.SetCheckpoint("300")
+	err = checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "300", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, leaseTimeout, svc.item[LeaseTimeoutKey].(*types.AttributeValueMemberS).Value)
+}
+
+func TestDynamoDBErrorMapping(t *testing.T) {
+	checkpoint := NewDynamoCheckpoint(cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"))
+
+	throttled := &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+	err := checkpoint.dynamoDBError(throttled)
+	assert.True(t, errors.As(err, &kcl.ThrottlingError{}))
+	assert.ErrorIs(t, err, throttled)
+
+	err = checkpoint.dynamoDBError(&smithy.GenericAPIError{Code: "ThrottlingException"})
+	assert.True(t, errors.As(err, &kcl.ThrottlingError{}))
+
+	err = checkpoint.dynamoDBError(&types.ResourceNotFoundException{Message: aws.String("no table")})
+	assert.True(t, errors.As(err, &kcl.InvalidStateError{}))
+
+	err = checkpoint.dynamoDBError(errors.New("connection reset"))
+	assert.True(t, errors.As(err, &kcl.KinesisClientLibDependencyError{}))
+
+	// lost races are handled by the callers
+	conditionalCheckErr := &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	assert.Equal(t, error(conditionalCheckErr), checkpoint.dynamoDBError(conditionalCheckErr))
+	assert.Nil(t, checkpoint.dynamoDBError(nil))
+}
+
+func TestCheckpointSubSequenceNumber(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+		},
+	}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abcd-efgh")
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+
+	subSequenceNumber := int64(3)
+	shard := &par.ShardStatus{
+		ID:                          "0001",
+		AssignedTo:                  "abcd-efgh",
+		Checkpoint:                  "200",
+		CheckpointSubSequenceNumber: &subSequenceNumber,
+		Mux:                         &sync.RWMutex{},
+	}
+	err := checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "3", svc.item[SubSequenceNumberKey].(*types.AttributeValueMemberN).Value)
+
+	status := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Equal(t, "200", status.GetCheckpoint())
+	assert.Equal(t, int64(3), *status.GetCheckpointSubSequenceNumber())
+
+	// the sub-sequence number survives the lease renewal
+	err = checkpoint.GetLease(status, "abcd-efgh")
+	assert.Nil(t, err)
+	assert.Equal(t, "3", svc.putItemInput.Item[SubSequenceNumberKey].(*types.AttributeValueMemberN).Value)
+
+	// checkpointing a whole record removes it
+	shard.SetCheckpoint("300")
+	shard.SetCheckpointSubSequenceNumber(nil)
+	err = checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	_, ok := svc.item[SubSequenceNumberKey]
+	assert.False(t, ok)
+
+	err = checkpoint.FetchCheckpoint(status)
+	assert.Nil(t, err)
+	assert.Nil(t, status.GetCheckpointSubSequenceNumber())
+}
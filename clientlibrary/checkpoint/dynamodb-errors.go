@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// dynamoDBError maps an error returned by DynamoDB to the KCL error taxonomy, so record processors can tell
+// errors worth a retry from fatal ones. Conditional check failures are returned as is: they are the expected
+// outcome of a lost race and are handled by the callers.
+func (checkpointer *DynamoCheckpoint) dynamoDBError(err error) error {
+	return dynamoDBError("lease table "+checkpointer.TableName, err)
+}
+
+// dynamoDBError maps an error returned by DynamoDB for the described table
+func dynamoDBError(table string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var conditionalCheckErr *types.ConditionalCheckFailedException
+	var throughputErr *types.ProvisionedThroughputExceededException
+	var requestLimitErr *types.RequestLimitExceeded
+	var resourceNotFoundErr *types.ResourceNotFoundException
+	var apiErr smithy.APIError
+
+	switch {
+	case errors.As(err, &conditionalCheckErr):
+		return err
+	case errors.As(err, &throughputErr), errors.As(err, &requestLimitErr),
+		errors.As(err, &apiErr) && apiErr.ErrorCode() == "ThrottlingException":
+		return kcl.ThrottlingError{Message: table + " throttled the request", Err: err}
+	case errors.As(err, &resourceNotFoundErr):
+		return kcl.InvalidStateError{Message: table + " not found", Err: err}
+	case errors.As(err, &kcl.ThrottlingError{}), errors.As(err, &kcl.InvalidStateError{}),
+		errors.As(err, &kcl.KinesisClientLibDependencyError{}):
+		// already mapped
+		return err
+	default:
+		return kcl.KinesisClientLibDependencyError{Message: table, Err: err}
+	}
+}
+
+// checkpointRejectedError tells why a checkpoint of the shard failed its condition: the lease was lost or the shard
+// was rewound since its consumer started
+func (checkpointer *DynamoCheckpoint) checkpointRejectedError(shardID, owner string) error {
+	item, err := checkpointer.getItem(shardID)
+	if err == nil {
+		if assignedTo, ok := item[LeaseOwnerKey].(*types.AttributeValueMemberS); ok && assignedTo.Value == owner {
+			return rewindRequestedError(shardID)
+		}
+	}
+	return leaseLostError(shardID, owner)
+}
+
+// rewindRequestedError is returned when the shard was rewound since its consumer started
+func rewindRequestedError(shardID string) error {
+	return kcl.ShutdownError{
+		Message: "shard " + shardID + " was rewound",
+		Err:     ErrRewindRequested,
+	}
+}
+
+// leaseLostError is returned when a checkpoint write was rejected because the lease is held by another worker
+func leaseLostError(shardID, owner string) error {
+	return kcl.ShutdownError{
+		Message: "shard " + shardID + " is no longer held by " + owner,
+		Err:     ErrLeaseLost,
+	}
+}
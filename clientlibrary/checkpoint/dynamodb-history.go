@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+// Columns of the checkpoint history table, next to ShardID, Checkpoint and CheckpointSubSequenceNumber
+const (
+	ChangeTimeKey           = "ChangeTime"                     // Time of the change in nanoseconds since the epoch, the range key
+	OldSequenceNumberKey    = "OldCheckpoint"                  // Checkpoint before the change
+	OldSubSequenceNumberKey = "OldCheckpointSubSequenceNumber" // Sub-sequence number before the change
+	ChangeWorkerKey         = "WorkerID"                       // The worker that changed the checkpoint
+	ChangeReasonKey         = "Reason"                         // Why the checkpoint changed
+	historyTableNameSuffix  = "-history"
+)
+
+// DynamoCheckpointHistory stores the checkpoint history of a lease table in a second DynamoDB table, keyed by shard
+// and time of the change. Given to DynamoCheckpoint.WithCheckpointHistory, it shares the DynamoDB client of the
+// lease table and is created by DynamoCheckpoint.Init.
+type DynamoCheckpointHistory struct {
+	TableName string
+
+	readCapacity  int64
+	writeCapacity int64
+	options       config.LeaseTableOptions
+	activeTimeout time.Duration
+	svc           DynamoDBAPI
+}
+
+// NewDynamoCheckpointHistory returns a history store in the table named after the lease table with a -history suffix
+func NewDynamoCheckpointHistory(kclConfig *config.KinesisClientLibConfiguration) *DynamoCheckpointHistory {
+	return &DynamoCheckpointHistory{
+		TableName:     kclConfig.TableName + historyTableNameSuffix,
+		readCapacity:  int64(kclConfig.InitialLeaseTableReadCapacity),
+		writeCapacity: int64(kclConfig.InitialLeaseTableWriteCapacity),
+		options:       kclConfig.LeaseTableOptions,
+		activeTimeout: time.Duration(kclConfig.LeaseTableActiveTimeoutMillis) * time.Millisecond,
+	}
+}
+
+// WithDynamoDB is used to provide DynamoDB service
+func (history *DynamoCheckpointHistory) WithDynamoDB(svc DynamoDBAPI) *DynamoCheckpointHistory {
+	history.svc = svc
+	return history
+}
+
+// WithTableName is used to store the history in another table
+func (history *DynamoCheckpointHistory) WithTableName(tableName string) *DynamoCheckpointHistory {
+	history.TableName = tableName
+	return history
+}
+
+// Init creates the history table with the options of the lease table if it doesn't exist
+func (history *DynamoCheckpointHistory) Init() error {
+	input := &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String(LeaseKeyKey),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String(ChangeTimeKey),
+				AttributeType: types.ScalarAttributeTypeN,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String(LeaseKeyKey),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String(ChangeTimeKey),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(history.readCapacity),
+			WriteCapacityUnits: aws.Int64(history.writeCapacity),
+		},
+		TableName: aws.String(history.TableName),
+	}
+	return history.dynamoDBError(initTable(history.svc, input, history.options, history.activeTimeout))
+}
+
+// AppendCheckpointChange puts the change into the history table
+func (history *DynamoCheckpointHistory) AppendCheckpointChange(change CheckpointChange) error {
+	item := make(map[string]types.AttributeValue)
+	item[LeaseKeyKey] = &types.AttributeValueMemberS{Value: change.ShardID}
+	item[ChangeTimeKey] = &types.AttributeValueMemberN{Value: strconv.FormatInt(change.Time.UnixNano(), 10)}
+	putString(item, OldSequenceNumberKey, change.OldCheckpoint)
+	putInt64(item, OldSubSequenceNumberKey, change.OldSubSequenceNumber)
+	putString(item, SequenceNumberKey, change.NewCheckpoint)
+	putInt64(item, SubSequenceNumberKey, change.NewSubSequenceNumber)
+	putString(item, ChangeWorkerKey, change.WorkerID)
+	putString(item, ChangeReasonKey, change.Reason)
+
+	_, err := history.svc.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(history.TableName),
+		Item:      item,
+	})
+	return history.dynamoDBError(err)
+}
+
+// CheckpointAt queries the history of the shard for the last change up to the given time, or the first later one
+func (history *DynamoCheckpointHistory) CheckpointAt(shardID string, at time.Time) (CheckpointChange, bool, error) {
+	item, err := history.queryChange(shardID, ChangeTimeKey+" <= :at", at, false)
+	if err != nil {
+		return CheckpointChange{}, false, err
+	}
+	if item != nil {
+		return readCheckpointChange(item), true, nil
+	}
+
+	item, err = history.queryChange(shardID, ChangeTimeKey+" > :at", at, true)
+	if err != nil || item == nil {
+		return CheckpointChange{}, false, err
+	}
+	change := readCheckpointChange(item)
+	return CheckpointChange{
+		ShardID:              shardID,
+		NewCheckpoint:        change.OldCheckpoint,
+		NewSubSequenceNumber: change.OldSubSequenceNumber,
+	}, true, nil
+}
+
+// queryChange returns the first change of the shard matching the condition on the change time, in ascending order of
+// change time if forward or descending otherwise, nil if there is none
+func (history *DynamoCheckpointHistory) queryChange(shardID, timeCondition string, at time.Time, forward bool) (map[string]types.AttributeValue, error) {
+	output, err := history.svc.Query(context.Background(), &dynamodb.QueryInput{
+		TableName:              aws.String(history.TableName),
+		KeyConditionExpression: aws.String(LeaseKeyKey + " = :shard_id AND " + timeCondition),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":shard_id": &types.AttributeValueMemberS{Value: shardID},
+			":at":       &types.AttributeValueMemberN{Value: strconv.FormatInt(at.UnixNano(), 10)},
+		},
+		ScanIndexForward: aws.Bool(forward),
+		Limit:            aws.Int32(1),
+		ConsistentRead:   aws.Bool(true),
+	})
+	if err != nil {
+		return nil, history.dynamoDBError(err)
+	}
+	if len(output.Items) == 0 {
+		return nil, nil
+	}
+	return output.Items[0], nil
+}
+
+// ListCheckpointChanges scans the history table
+func (history *DynamoCheckpointHistory) ListCheckpointChanges() ([]CheckpointChange, error) {
+	var changes []CheckpointChange
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(history.TableName),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	for {
+		output, err := history.svc.Scan(context.Background(), input)
+		if err != nil {
+			return nil, history.dynamoDBError(err)
+		}
+
+		for _, item := range output.Items {
+			changes = append(changes, readCheckpointChange(item))
+		}
+
+		if len(output.LastEvaluatedKey) == 0 {
+			return changes, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+func (history *DynamoCheckpointHistory) dynamoDBError(err error) error {
+	return dynamoDBError("checkpoint history table "+history.TableName, err)
+}
+
+func readCheckpointChange(item map[string]types.AttributeValue) CheckpointChange {
+	change := CheckpointChange{
+		ShardID:              readString(item, LeaseKeyKey),
+		OldCheckpoint:        readString(item, OldSequenceNumberKey),
+		OldSubSequenceNumber: readInt64(item, OldSubSequenceNumberKey),
+		NewCheckpoint:        readString(item, SequenceNumberKey),
+		NewSubSequenceNumber: readInt64(item, SubSequenceNumberKey),
+		WorkerID:             readString(item, ChangeWorkerKey),
+		Reason:               readString(item, ChangeReasonKey),
+	}
+	if changeTime := readInt64(item, ChangeTimeKey); changeTime != nil {
+		change.Time = time.Unix(0, *changeTime).UTC()
+	}
+	return change
+}
+
+func putString(item map[string]types.AttributeValue, key, value string) {
+	if value != "" {
+		item[key] = &types.AttributeValueMemberS{Value: value}
+	}
+}
+
+func putInt64(item map[string]types.AttributeValue, key string, value *int64) {
+	if value != nil {
+		item[key] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*value, 10)}
+	}
+}
+
+func readString(item map[string]types.AttributeValue, key string) string {
+	if value, ok := item[key].(*types.AttributeValueMemberS); ok {
+		return value.Value
+	}
+	return ""
+}
+
+func readInt64(item map[string]types.AttributeValue, key string) *int64 {
+	value, ok := item[key].(*types.AttributeValueMemberN)
+	if !ok {
+		return nil
+	}
+	number, err := strconv.ParseInt(value.Value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &number
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+var (
+	// ErrTableKeySchema is returned by Init when an existing table doesn't have the key schema of the checkpointer
+	ErrTableKeySchema = errors.New("TableKeySchemaMismatch")
+
+	// ErrTableNotActive is returned by Init when a table didn't become ACTIVE in time
+	ErrTableNotActive = errors.New("TableNotActive")
+)
+
+// tableStatusPollInterval is the interval between two checks of the status of a table which isn't ACTIVE yet
+var tableStatusPollInterval = time.Second
+
+// continuousBackupsAPI is implemented by the DynamoDB clients able to turn on point-in-time recovery
+type continuousBackupsAPI interface {
+	UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
+}
+
+// initTable creates the table described by input with the options unless it exists, checks the key schema of an
+// existing table, and waits until the table is ACTIVE
+func initTable(svc DynamoDBAPI, input *dynamodb.CreateTableInput, options config.LeaseTableOptions, timeout time.Duration) error {
+	output, err := svc.DescribeTable(context.Background(), &dynamodb.DescribeTableInput{TableName: input.TableName})
+
+	created := false
+	var resourceNotFoundErr *types.ResourceNotFoundException
+	switch {
+	case errors.As(err, &resourceNotFoundErr):
+		if err := createTable(svc, input, options); err != nil {
+			return err
+		}
+		created = true
+	case err != nil:
+		return err
+	case output.Table != nil && !sameKeySchema(output.Table.KeySchema, input.KeySchema):
+		return kcl.InvalidStateError{
+			Message: fmt.Sprintf("table %s has key schema %s, expected %s", aws.ToString(input.TableName),
+				keySchemaString(output.Table.KeySchema), keySchemaString(input.KeySchema)),
+			Err: ErrTableKeySchema,
+		}
+	}
+
+	if err := waitForTableActive(svc, aws.ToString(input.TableName), timeout); err != nil {
+		return err
+	}
+
+	if created && options.PointInTimeRecovery {
+		return enablePointInTimeRecovery(svc, aws.ToString(input.TableName))
+	}
+	return nil
+}
+
+// createTable creates the table described by input with the options. A table created meanwhile by another worker
+// is fine.
+func createTable(svc DynamoDBAPI, input *dynamodb.CreateTableInput, options config.LeaseTableOptions) error {
+	if options.PayPerRequest {
+		input.BillingMode = types.BillingModePayPerRequest
+		input.ProvisionedThroughput = nil
+		for i := range input.GlobalSecondaryIndexes {
+			input.GlobalSecondaryIndexes[i].ProvisionedThroughput = nil
+		}
+	}
+
+	tagKeys := make([]string, 0, len(options.Tags))
+	for key := range options.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+	for _, key := range tagKeys {
+		input.Tags = append(input.Tags, types.Tag{Key: aws.String(key), Value: aws.String(options.Tags[key])})
+	}
+
+	if options.KMSKeyID != "" {
+		input.SSESpecification = &types.SSESpecification{
+			Enabled:        aws.Bool(true),
+			SSEType:        types.SSETypeKms,
+			KMSMasterKeyId: aws.String(options.KMSKeyID),
+		}
+	}
+
+	if options.DeletionProtection {
+		input.DeletionProtectionEnabled = aws.Bool(true)
+	}
+
+	_, err := svc.CreateTable(context.Background(), input)
+
+	var resourceInUseErr *types.ResourceInUseException
+	if errors.As(err, &resourceInUseErr) {
+		return nil
+	}
+	return err
+}
+
+// waitForTableActive polls the status of the table until it is ACTIVE
+func waitForTableActive(svc DynamoDBAPI, tableName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		output, err := svc.DescribeTable(context.Background(), &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+
+		// a new table may not be described yet
+		var resourceNotFoundErr *types.ResourceNotFoundException
+		if err != nil && !errors.As(err, &resourceNotFoundErr) {
+			return err
+		}
+		if err == nil && (output.Table == nil || output.Table.TableStatus == types.TableStatusActive) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return kcl.InvalidStateError{
+				Message: fmt.Sprintf("table %s not ACTIVE after %s", tableName, timeout),
+				Err:     ErrTableNotActive,
+			}
+		}
+		time.Sleep(tableStatusPollInterval)
+	}
+}
+
+// enablePointInTimeRecovery turns on the continuous backups of the table
+func enablePointInTimeRecovery(svc DynamoDBAPI, tableName string) error {
+	backups, ok := unwrapDynamoDB(svc).(continuousBackupsAPI)
+	if !ok {
+		return fmt.Errorf("%w: point-in-time recovery of table %s", ErrNotSupported, tableName)
+	}
+
+	_, err := backups.UpdateContinuousBackups(context.Background(), &dynamodb.UpdateContinuousBackupsInput{
+		TableName: aws.String(tableName),
+		PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{
+			PointInTimeRecoveryEnabled: aws.Bool(true),
+		},
+	})
+	return err
+}
+
+// unwrapDynamoDB returns the DynamoDB client wrapped to read and write a lease table in another layout
+func unwrapDynamoDB(svc DynamoDBAPI) DynamoDBAPI {
+	if javaSchema, ok := svc.(*javaSchemaDynamoDB); ok {
+		return javaSchema.DynamoDBAPI
+	}
+	return svc
+}
+
+func sameKeySchema(actual, expected []types.KeySchemaElement) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i := range expected {
+		if aws.ToString(actual[i].AttributeName) != aws.ToString(expected[i].AttributeName) ||
+			actual[i].KeyType != expected[i].KeyType {
+			return false
+		}
+	}
+	return true
+}
+
+func keySchemaString(keySchema []types.KeySchemaElement) string {
+	description := ""
+	for i, element := range keySchema {
+		if i > 0 {
+			description += ", "
+		}
+		description += aws.ToString(element.AttributeName) + " " + string(element.KeyType)
+	}
+	return "[" + description + "]"
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+func TestInitCreatesLeaseTableWithOptions(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: false, item: map[string]types.AttributeValue{}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseTableOptions(config.LeaseTableOptions{
+			PayPerRequest:       true,
+			Tags:                map[string]string{"team": "streaming", "env": "prod"},
+			KMSKeyID:            "alias/kcl",
+			PointInTimeRecovery: true,
+			DeletionProtection:  true,
+		})
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	assert.Nil(t, checkpointer.Init())
+	input := svc.createTableInput
+	assert.Equal(t, types.BillingModePayPerRequest, input.BillingMode)
+	assert.Nil(t, input.ProvisionedThroughput)
+	assert.Equal(t, []types.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("team"), Value: aws.String("streaming")},
+	}, input.Tags)
+	assert.Equal(t, types.SSETypeKms, input.SSESpecification.SSEType)
+	assert.Equal(t, "alias/kcl", aws.ToString(input.SSESpecification.KMSMasterKeyId))
+	assert.True(t, aws.ToBool(input.DeletionProtectionEnabled))
+	assert.True(t, aws.ToBool(svc.backupsInput.PointInTimeRecoverySpecification.PointInTimeRecoveryEnabled))
+}
+
+func TestInitDefaultLeaseTable(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: false, item: map[string]types.AttributeValue{}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	assert.Nil(t, checkpointer.Init())
+	input := svc.createTableInput
+	assert.Equal(t, types.BillingMode(""), input.BillingMode)
+	assert.Equal(t, int64(config.DefaultInitialLeaseTableReadCapacity), aws.ToInt64(input.ProvisionedThroughput.ReadCapacityUnits))
+	assert.Nil(t, input.SSESpecification)
+	assert.Nil(t, svc.backupsInput)
+}
+
+func TestInitWaitsForActiveLeaseTable(t *testing.T) {
+	tableStatusPollInterval = time.Millisecond
+	defer func() { tableStatusPollInterval = time.Second }()
+
+	svc := &mockDynamoDB{
+		tableExist:    true,
+		item:          map[string]types.AttributeValue{},
+		tableStatuses: []types.TableStatus{types.TableStatusCreating, types.TableStatusCreating, types.TableStatusCreating},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.Nil(t, checkpointer.Init())
+	assert.Empty(t, svc.tableStatuses)
+	assert.Nil(t, svc.createTableInput)
+
+	svc.tableStatuses = []types.TableStatus{types.TableStatusCreating, types.TableStatusCreating, types.TableStatusCreating}
+	kclConfig.WithLeaseTableActiveTimeoutMillis(1)
+	checkpointer = NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	assert.ErrorIs(t, checkpointer.Init(), ErrTableNotActive)
+}
+
+func TestInitChecksLeaseTableKeySchema(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		item:           map[string]types.AttributeValue{},
+		tableKeySchema: []types.KeySchemaElement{{AttributeName: aws.String(JavaLeaseKeyKey), KeyType: types.KeyTypeHash}},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	assert.ErrorIs(t, NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).Init(), ErrTableKeySchema)
+
+	// the lease table of a Java application
+	kclConfig.WithLeaseTableSchema(config.LeaseTableSchemaJava)
+	assert.Nil(t, NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).Init())
+}
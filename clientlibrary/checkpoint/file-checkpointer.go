@@ -0,0 +1,389 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+// Package checkpoint
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// FileCheckpointVersion is the version of the lease file format written by FileCheckpoint
+const FileCheckpointVersion = 1
+
+// ErrUnsupportedLeaseFileVersion is returned by FileCheckpoint when the lease file was written by a newer version
+var ErrUnsupportedLeaseFileVersion = errors.New("UnsupportedLeaseFileVersion")
+
+// leaseFile is the content of the lease file
+type leaseFile struct {
+	Version int                  `json:"version"`
+	Leases  map[string]fileLease `json:"leases"`
+}
+
+// fileLease is the lease of a shard in the lease file
+type fileLease struct {
+	Owner                 string     `json:"owner,omitempty"`
+	LeaseTimeout          *time.Time `json:"leaseTimeout,omitempty"`
+	Checkpoint            string     `json:"checkpoint,omitempty"`
+	SubSequenceNumber     *int64     `json:"subSequenceNumber,omitempty"`
+	ParentShardID         string     `json:"parentShardId,omitempty"`
+	AdjacentParentShardID string     `json:"adjacentParentShardId,omitempty"`
+	ClaimRequest          string     `json:"claimRequest,omitempty"`
+	Sticky                int        `json:"sticky"`
+	StickyWorker          string     `json:"stickyWorker,omitempty"`
+	StickyGroup           string     `json:"stickyGroup,omitempty"`
+	StickyExpiry          *time.Time `json:"stickyExpiry,omitempty"`
+	Draining              string     `json:"draining,omitempty"`
+	PendingCheckpoint     string     `json:"pendingCheckpoint,omitempty"`
+	RewindRequest         string     `json:"rewindRequest,omitempty"`
+}
+
+// FileCheckpoint is a Checkpointer keeping the lease table in a local file, for single node deployments without
+// DynamoDB. It has the lease semantics of MemoryCheckpoint, shared by all processes of the host using the same file:
+// every operation holds an exclusive lock on the lock file next to the lease file while it reads the leases and
+// writes them back, so two processes can't hold the lease of a shard at the same time.
+//
+// The lease file is replaced atomically: the leases are written and fsync'd to a temporary file which is renamed over
+// the lease file, so that a crash leaves the last written checkpoints. The lock relies on flock and is only
+// supported on Unix systems.
+type FileCheckpoint struct {
+	log           logger.Logger
+	Path          string
+	LeaseDuration int
+	kclConfig     *config.KinesisClientLibConfiguration
+
+	// mux serializes the operations of the process, the lock file those of the processes
+	mux sync.Mutex
+}
+
+// NewFileCheckpoint returns a checkpointer keeping the lease table in the file at path
+func NewFileCheckpoint(kclConfig *config.KinesisClientLibConfiguration, path string) *FileCheckpoint {
+	return &FileCheckpoint{
+		log:           kclConfig.Logger,
+		Path:          path,
+		LeaseDuration: kclConfig.FailoverTimeMillis,
+		kclConfig:     kclConfig,
+	}
+}
+
+// Init creates the directory of the lease file, removes the temporary file of a write interrupted by a crash and
+// checks that the lease file can be read
+func (checkpointer *FileCheckpoint) Init() error {
+	if err := os.MkdirAll(filepath.Dir(checkpointer.Path), 0o755); err != nil {
+		return err
+	}
+
+	return checkpointer.update(func(leases *MemoryCheckpoint) error {
+		// the lease file is only replaced by a complete temporary file, a leftover one is discarded
+		if err := os.Remove(checkpointer.tempPath()); err == nil {
+			checkpointer.log.Warnf("Discarded the incomplete write of lease file %s", checkpointer.Path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// GetLease attempts to gain a lock on the given shard
+func (checkpointer *FileCheckpoint) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.GetLease(shard, newAssignTo) })
+}
+
+// CheckpointSequence writes a checkpoint at the designated sequence ID, fenced by the lease
+func (checkpointer *FileCheckpoint) CheckpointSequence(shard *par.ShardStatus) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.CheckpointSequence(shard) })
+}
+
+// FetchCheckpoint retrieves the checkpoint for the given shard
+func (checkpointer *FileCheckpoint) FetchCheckpoint(shard *par.ShardStatus) error {
+	return checkpointer.view(func(leases *MemoryCheckpoint) error { return leases.FetchCheckpoint(shard) })
+}
+
+// RemoveLeaseInfo removes the lease of a shard which no longer exists
+func (checkpointer *FileCheckpoint) RemoveLeaseInfo(shardID string) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.RemoveLeaseInfo(shardID) })
+}
+
+// RemoveLeaseOwner removes the lease owner of a shard held by this worker
+func (checkpointer *FileCheckpoint) RemoveLeaseOwner(shardID string) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.RemoveLeaseOwner(shardID) })
+}
+
+// GetLeaseOwner returns the current lease owner of the shard
+func (checkpointer *FileCheckpoint) GetLeaseOwner(shardID string) (string, error) {
+	var owner string
+	err := checkpointer.view(func(leases *MemoryCheckpoint) error {
+		var err error
+		owner, err = leases.GetLeaseOwner(shardID)
+		return err
+	})
+	return owner, err
+}
+
+// ListActiveWorkers returns a map of workers and their shards
+func (checkpointer *FileCheckpoint) ListActiveWorkers(shardStatus map[string]*par.ShardStatus) (map[string][]*par.ShardStatus, error) {
+	var workers map[string][]*par.ShardStatus
+	err := checkpointer.view(func(leases *MemoryCheckpoint) error {
+		var err error
+		workers, err = leases.ListActiveWorkers(shardStatus)
+		return err
+	})
+	return workers, err
+}
+
+// ClaimShard places a claim request on a shard to signal a steal attempt
+func (checkpointer *FileCheckpoint) ClaimShard(shard *par.ShardStatus, claimID string) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.ClaimShard(shard, claimID) })
+}
+
+// MarkLeaseDraining flags the lease held by this worker as waiting for another worker to claim it
+func (checkpointer *FileCheckpoint) MarkLeaseDraining(shard *par.ShardStatus) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.MarkLeaseDraining(shard) })
+}
+
+// PrepareCheckpoint stores the pending checkpoint of a lease held by the shard's owner
+func (checkpointer *FileCheckpoint) PrepareCheckpoint(shard *par.ShardStatus) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.PrepareCheckpoint(shard) })
+}
+
+// PinShard pins the shard to the given worker, or to its current owner if workerID is empty
+func (checkpointer *FileCheckpoint) PinShard(shardID, workerID string) error {
+	return checkpointer.PinShardUntil(shardID, workerID, time.Time{})
+}
+
+// PinShardUntil pins the shard like PinShard until the given expiry, a zero expiry never expires
+func (checkpointer *FileCheckpoint) PinShardUntil(shardID, workerID string, expiry time.Time) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.PinShardUntil(shardID, workerID, expiry) })
+}
+
+// PinShardToGroup pins the shard to any worker whose labels match the selector
+func (checkpointer *FileCheckpoint) PinShardToGroup(shardID, selector string) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.PinShardToGroup(shardID, selector) })
+}
+
+// UnpinShard sends the shard back to normal assignment
+func (checkpointer *FileCheckpoint) UnpinShard(shardID string) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.UnpinShard(shardID) })
+}
+
+// RequestShardRelease asks the worker holding the shard to gracefully release it
+func (checkpointer *FileCheckpoint) RequestShardRelease(shardID string) error {
+	return checkpointer.update(func(leases *MemoryCheckpoint) error { return leases.RequestShardRelease(shardID) })
+}
+
+// ListPinnedShards returns the shards currently pinned, including pins whose expiry has passed
+func (checkpointer *FileCheckpoint) ListPinnedShards() ([]*par.ShardStatus, error) {
+	return checkpointer.list(func(leases *MemoryCheckpoint) ([]*par.ShardStatus, error) { return leases.ListPinnedShards() })
+}
+
+// ListLeases returns the leases of all shards sorted by shard ID
+func (checkpointer *FileCheckpoint) ListLeases() ([]*par.ShardStatus, error) {
+	return checkpointer.list(func(leases *MemoryCheckpoint) ([]*par.ShardStatus, error) { return leases.ListLeases() })
+}
+
+// ListLeasesByOwner returns the leases held by the worker sorted by shard ID
+func (checkpointer *FileCheckpoint) ListLeasesByOwner(workerID string) ([]*par.ShardStatus, error) {
+	return checkpointer.list(func(leases *MemoryCheckpoint) ([]*par.ShardStatus, error) { return leases.ListLeasesByOwner(workerID) })
+}
+
+// list returns the leases listed from the lease file
+func (checkpointer *FileCheckpoint) list(list func(*MemoryCheckpoint) ([]*par.ShardStatus, error)) ([]*par.ShardStatus, error) {
+	var leases []*par.ShardStatus
+	err := checkpointer.view(func(memory *MemoryCheckpoint) error {
+		var err error
+		leases, err = list(memory)
+		return err
+	})
+	return leases, err
+}
+
+// view runs the read-only operation on the leases of the lease file, under a shared lock
+func (checkpointer *FileCheckpoint) view(operation func(*MemoryCheckpoint) error) error {
+	return checkpointer.withLock(false, func() error {
+		leases, err := checkpointer.readLeases()
+		if err != nil {
+			return err
+		}
+		return operation(leases)
+	})
+}
+
+// update runs the operation on the leases of the lease file and writes them back if it succeeded, under an
+// exclusive lock
+func (checkpointer *FileCheckpoint) update(operation func(*MemoryCheckpoint) error) error {
+	return checkpointer.withLock(true, func() error {
+		leases, err := checkpointer.readLeases()
+		if err != nil {
+			return err
+		}
+		if err := operation(leases); err != nil {
+			return err
+		}
+		return checkpointer.writeLeases(leases)
+	})
+}
+
+// withLock runs f while holding the lock file
+func (checkpointer *FileCheckpoint) withLock(exclusive bool, f func() error) error {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	lockFile, err := os.OpenFile(checkpointer.Path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+
+	if err := lockFileDescriptor(lockFile, exclusive); err != nil {
+		return fmt.Errorf("lock of lease file %s: %w", checkpointer.Path, err)
+	}
+	defer unlockFileDescriptor(lockFile)
+
+	return f()
+}
+
+// readLeases reads the lease file, a missing file is an empty lease table
+func (checkpointer *FileCheckpoint) readLeases() (*MemoryCheckpoint, error) {
+	leases := map[string]*memoryLease{}
+	data, err := os.ReadFile(checkpointer.Path)
+	if os.IsNotExist(err) {
+		return newMemoryCheckpoint(checkpointer.kclConfig, checkpointer.LeaseDuration, leases), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := &leaseFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("lease file %s: %w", checkpointer.Path, err)
+	}
+	if file.Version < 1 || file.Version > FileCheckpointVersion {
+		return nil, fmt.Errorf("lease file %s: %w: %d", checkpointer.Path, ErrUnsupportedLeaseFileVersion, file.Version)
+	}
+
+	for shardID, lease := range file.Leases {
+		leases[shardID] = lease.memoryLease()
+	}
+	return newMemoryCheckpoint(checkpointer.kclConfig, checkpointer.LeaseDuration, leases), nil
+}
+
+// writeLeases replaces the lease file with the leases: they are written and fsync'd to a temporary file first,
+// which is then renamed over the lease file
+func (checkpointer *FileCheckpoint) writeLeases(leases *MemoryCheckpoint) error {
+	file := leaseFile{Version: FileCheckpointVersion, Leases: make(map[string]fileLease, len(leases.leases))}
+	for shardID, lease := range leases.leases {
+		file.Leases[shardID] = newFileLease(lease)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempPath := checkpointer.tempPath()
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, checkpointer.Path); err != nil {
+		return err
+	}
+
+	// the rename itself is durable once the directory is synced
+	dir, err := os.Open(filepath.Dir(checkpointer.Path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (checkpointer *FileCheckpoint) tempPath() string {
+	return checkpointer.Path + ".tmp"
+}
+
+func newFileLease(lease *memoryLease) fileLease {
+	return fileLease{
+		Owner:                 lease.owner,
+		LeaseTimeout:          optionalTime(lease.leaseTimeout),
+		Checkpoint:            lease.checkpoint,
+		SubSequenceNumber:     lease.subSequenceNumber,
+		ParentShardID:         lease.parentShardID,
+		AdjacentParentShardID: lease.adjacentParentShardID,
+		ClaimRequest:          lease.claimRequest,
+		Sticky:                lease.sticky,
+		StickyWorker:          lease.stickyWorker,
+		StickyGroup:           lease.stickyGroup,
+		StickyExpiry:          optionalTime(lease.stickyExpiry),
+		Draining:              lease.draining,
+		PendingCheckpoint:     lease.pendingCheckpoint,
+		RewindRequest:         lease.rewindRequest,
+	}
+}
+
+func (lease fileLease) memoryLease() *memoryLease {
+	memory := &memoryLease{
+		owner:                 lease.Owner,
+		checkpoint:            lease.Checkpoint,
+		subSequenceNumber:     lease.SubSequenceNumber,
+		parentShardID:         lease.ParentShardID,
+		adjacentParentShardID: lease.AdjacentParentShardID,
+		claimRequest:          lease.ClaimRequest,
+		sticky:                lease.Sticky,
+		stickyWorker:          lease.StickyWorker,
+		stickyGroup:           lease.StickyGroup,
+		draining:              lease.Draining,
+		pendingCheckpoint:     lease.PendingCheckpoint,
+		rewindRequest:         lease.RewindRequest,
+	}
+	if lease.LeaseTimeout != nil {
+		memory.leaseTimeout = lease.LeaseTimeout.UTC()
+	}
+	if lease.StickyExpiry != nil {
+		memory.stickyExpiry = lease.StickyExpiry.UTC()
+	}
+	return memory
+}
+
+// optionalTime returns nil for the zero time
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
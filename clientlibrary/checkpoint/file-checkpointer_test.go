@@ -0,0 +1,151 @@
+//go:build unix
+
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package checkpoint
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+func newTestFileCheckpoint(t *testing.T, path, workerID string) *FileCheckpoint {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", workerID)
+	checkpointer := NewFileCheckpoint(kclConfig, path)
+	assert.Nil(t, checkpointer.Init())
+	return checkpointer
+}
+
+func TestFileCheckpointInterfaces(t *testing.T) {
+	checkpointer := newTestFileCheckpoint(t, filepath.Join(t.TempDir(), "leases.json"), "abc")
+
+	var _ Checkpointer = checkpointer
+	var _ StickyAdmin = checkpointer
+	var _ LeaseDrainer = checkpointer
+	var _ PendingCheckpointer = checkpointer
+	var _ LeaseLister = checkpointer
+	var _ LeaseOwnerLister = checkpointer
+}
+
+func TestFileCheckpointPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	checkpointer := newTestFileCheckpoint(t, path, "abc")
+
+	shard := newMemoryShard("0001")
+	shard.ParentShardId, shard.AdjacentParentShardId = "0000", "0002"
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	shard.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.Nil(t, checkpointer.PinShard("0001", "abc"))
+
+	// a new process reads the leases written by the previous one
+	restarted := newTestFileCheckpoint(t, path, "abc")
+	fetched := newMemoryShard("0001")
+	assert.Nil(t, restarted.FetchCheckpoint(fetched))
+	assert.Equal(t, "100", fetched.GetCheckpoint())
+	assert.Equal(t, "abc", fetched.GetLeaseOwner())
+
+	pinned, err := restarted.ListPinnedShards()
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(pinned)) {
+		assert.Equal(t, "abc", pinned[0].StickyWorker)
+		assert.Equal(t, "0000", pinned[0].ParentShardId)
+		assert.Equal(t, "0002", pinned[0].AdjacentParentShardId)
+	}
+
+	assert.Nil(t, restarted.RemoveLeaseInfo("0001"))
+	assert.Equal(t, ErrSequenceIDNotFound, checkpointer.FetchCheckpoint(newMemoryShard("0001")))
+}
+
+func TestFileCheckpointSharedLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	first := newTestFileCheckpoint(t, path, "abc")
+	second := newTestFileCheckpoint(t, path, "def")
+
+	// only one of the processes acquires the lease of each shard
+	var wg sync.WaitGroup
+	acquired := make([]int, 2)
+	for i, checkpointer := range []*FileCheckpoint{first, second} {
+		wg.Add(1)
+		go func(i int, checkpointer *FileCheckpoint, workerID string) {
+			defer wg.Done()
+			for _, shardID := range []string{"0001", "0002", "0003", "0004"} {
+				if checkpointer.GetLease(newMemoryShard(shardID), workerID) == nil {
+					acquired[i]++
+				}
+			}
+		}(i, checkpointer, checkpointer.kclConfig.WorkerID)
+	}
+	wg.Wait()
+	assert.Equal(t, 4, acquired[0]+acquired[1])
+
+	leases, err := first.ListLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(leases))
+
+	// the owner that lost a lease can't checkpoint it
+	owner, err := first.GetLeaseOwner("0001")
+	assert.Nil(t, err)
+	loser := second
+	if owner == "def" {
+		loser = first
+	}
+	shard := newMemoryShard("0001")
+	shard.SetLeaseOwner(loser.kclConfig.WorkerID)
+	shard.SetCheckpoint("100")
+	assert.True(t, errors.Is(loser.CheckpointSequence(shard), ErrLeaseLost))
+}
+
+func TestFileCheckpointRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	checkpointer := newTestFileCheckpoint(t, path, "abc")
+
+	shard := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	shard.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	// a crash in the middle of a write leaves a partial temporary file next to the last lease file
+	assert.Nil(t, os.WriteFile(path+".tmp", []byte(`{"version":1,"leases":{"0001":{"check`), 0o644))
+
+	restarted := newTestFileCheckpoint(t, path, "abc")
+	_, err := os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	fetched := newMemoryShard("0001")
+	assert.Nil(t, restarted.FetchCheckpoint(fetched))
+	assert.Equal(t, "100", fetched.GetCheckpoint())
+}
+
+func TestFileCheckpointUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"version":2,"leases":{}}`), 0o644))
+
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	err := NewFileCheckpoint(kclConfig, path).Init()
+	assert.True(t, errors.Is(err, ErrUnsupportedLeaseFileVersion))
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Reasons of the checkpoint changes recorded in the checkpoint history
+const (
+	CheckpointReasonCheckpoint = "Checkpoint" // The record processor checkpointed
+	CheckpointReasonShardEnd   = "ShardEnd"   // The record processor checkpointed the end of a closed shard
+	CheckpointReasonRestore    = "Restore"    // RestoreCheckpoints set the checkpoint back
+)
+
+// CheckpointChange is an entry of the checkpoint history
+type CheckpointChange struct {
+	ShardID              string
+	OldCheckpoint        string
+	OldSubSequenceNumber *int64
+	NewCheckpoint        string
+	NewSubSequenceNumber *int64
+	WorkerID             string
+	Time                 time.Time
+	Reason               string
+}
+
+// CheckpointHistorySink receives the checkpoint changes of a lease table. A failed append is logged and
+// doesn't fail the checkpoint.
+type CheckpointHistorySink interface {
+	// AppendCheckpointChange records a checkpoint change
+	AppendCheckpointChange(change CheckpointChange) error
+}
+
+// CheckpointHistory is a CheckpointHistorySink that can be read back, as required by RestoreCheckpoints
+type CheckpointHistory interface {
+	CheckpointHistorySink
+
+	// CheckpointAt returns the checkpoint of the shard at the given time, as the new position of a change. It is the
+	// position set by the last change before that time or, for a shard only changed later, the position the first
+	// later change started from. ok is false if no change of the shard was recorded.
+	CheckpointAt(shardID string, at time.Time) (position CheckpointChange, ok bool, err error)
+}
+
+// recordCheckpointChange appends a change of the lease previously holding the given attributes to the history
+func (checkpointer *DynamoCheckpoint) recordCheckpointChange(shardID string, previous map[string]types.AttributeValue, checkpoint string, subSequenceNumber *int64, workerID, reason string) {
+	change := CheckpointChange{
+		ShardID:              shardID,
+		OldSubSequenceNumber: readSubSequenceNumber(previous),
+		NewCheckpoint:        checkpoint,
+		NewSubSequenceNumber: subSequenceNumber,
+		WorkerID:             workerID,
+		Time:                 time.Now().UTC(),
+		Reason:               reason,
+	}
+	if sequenceID, ok := previous[SequenceNumberKey].(*types.AttributeValueMemberS); ok {
+		change.OldCheckpoint = sequenceID.Value
+	}
+
+	if err := checkpointer.history.AppendCheckpointChange(change); err != nil {
+		checkpointer.log.Warnf("Failed to record checkpoint change of shard %s in the checkpoint history: %+v", shardID, err)
+	}
+}
+
+// RestoreCheckpoints sets the checkpoint of every shard of the lease table back to its value at the given time in the
+// checkpoint history, and returns the restored checkpoints by shard. Shards without history are left alone. A shard that had no checkpoint yet loses its checkpoint and
+// starts again from the initial position in stream. The leases are released so any worker can pick the shards up.
+// Shut all workers down first: a running worker writes its own position back on its next checkpoint or lease
+// renewal.
+func (checkpointer *DynamoCheckpoint) RestoreCheckpoints(at time.Time) (map[string]string, error) {
+	history, ok := checkpointer.history.(CheckpointHistory)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	snapshot, err := checkpointer.ScanLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	restored := make(map[string]string)
+	for _, lease := range snapshot.Leases() {
+		shardID := lease.ID
+		position, ok, err := history.CheckpointAt(shardID, at)
+		if err != nil {
+			return restored, err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := checkpointer.restoreCheckpoint(shardID, position); err != nil {
+			if errors.Is(err, ErrShardNotFound) {
+				// the shard has been removed from the lease table since
+				continue
+			}
+			return restored, err
+		}
+		restored[shardID] = position.NewCheckpoint
+	}
+
+	return restored, nil
+}
+
+// restoreCheckpoint writes the checkpoint of the change to the lease of the shard
+func (checkpointer *DynamoCheckpoint) restoreCheckpoint(shardID string, position CheckpointChange) error {
+	var set []string
+	remove := []string{LeaseOwnerKey, ClaimRequestKey, PendingCheckpointKey}
+	expressionAttributeValues := map[string]types.AttributeValue{}
+
+	if position.NewCheckpoint == "" {
+		remove = append(remove, SequenceNumberKey)
+	} else {
+		set = append(set, SequenceNumberKey+" = :checkpoint")
+		expressionAttributeValues[":checkpoint"] = &types.AttributeValueMemberS{Value: position.NewCheckpoint}
+	}
+	if position.NewSubSequenceNumber == nil {
+		remove = append(remove, SubSequenceNumberKey)
+	} else {
+		set = append(set, SubSequenceNumberKey+" = :sub_sequence_number")
+		expressionAttributeValues[":sub_sequence_number"] = &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(*position.NewSubSequenceNumber, 10),
+		}
+	}
+
+	var updateExpression string
+	if len(set) > 0 {
+		updateExpression = "SET " + strings.Join(set, ", ") + " "
+	}
+	updateExpression += "REMOVE " + strings.Join(remove, ", ")
+	if len(expressionAttributeValues) == 0 {
+		expressionAttributeValues = nil
+	}
+
+	previous, err := checkpointer.updateItemReturning(shardID, updateExpression, "attribute_exists("+LeaseKeyKey+")",
+		expressionAttributeValues, types.ReturnValueAllOld)
+	if err != nil {
+		var conditionalCheckErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionalCheckErr) {
+			return ErrShardNotFound
+		}
+		return err
+	}
+
+	checkpointer.log.Infof("Restored checkpoint of shard %s to %q", shardID, position.NewCheckpoint)
+	checkpointer.recordCheckpointChange(shardID, previous, position.NewCheckpoint, position.NewSubSequenceNumber,
+		checkpointer.kclConfig.WorkerID, CheckpointReasonRestore)
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// memoryHistory keeps the checkpoint history in memory
+type memoryHistory struct {
+	changes []CheckpointChange
+}
+
+func (m *memoryHistory) AppendCheckpointChange(change CheckpointChange) error {
+	m.changes = append(m.changes, change)
+	return nil
+}
+
+// CheckpointAt expects the changes of a shard to be appended in order of time
+func (m *memoryHistory) CheckpointAt(shardID string, at time.Time) (CheckpointChange, bool, error) {
+	var position CheckpointChange
+	ok := false
+	for _, change := range m.changes {
+		if change.ShardID != shardID {
+			continue
+		}
+		if !change.Time.After(at) {
+			position, ok = change, true
+			continue
+		}
+		if !ok {
+			position = CheckpointChange{ShardID: shardID, NewCheckpoint: change.OldCheckpoint, NewSubSequenceNumber: change.OldSubSequenceNumber}
+			ok = true
+		}
+		break
+	}
+	return position, ok, nil
+}
+
+func TestCheckpointSequenceRecordsHistory(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abc"},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+		},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	history := &memoryHistory{}
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).WithCheckpointHistory(history)
+	shard := &par.ShardStatus{ID: "0001", AssignedTo: "abc", Mux: &sync.RWMutex{}}
+
+	shard.SetCheckpoint("200")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	shard.SetCheckpoint(ShardEnd)
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	// a rejected checkpoint isn't recorded
+	shard.SetLeaseOwner("other")
+	assert.ErrorIs(t, checkpointer.CheckpointSequence(shard), ErrLeaseLost)
+
+	if assert.Len(t, history.changes, 2) {
+		assert.Equal(t, "100", history.changes[0].OldCheckpoint)
+		assert.Equal(t, "200", history.changes[0].NewCheckpoint)
+		assert.Equal(t, "abc", history.changes[0].WorkerID)
+		assert.Equal(t, CheckpointReasonCheckpoint, history.changes[0].Reason)
+		assert.Equal(t, "200", history.changes[1].OldCheckpoint)
+		assert.Equal(t, ShardEnd, history.changes[1].NewCheckpoint)
+		assert.Equal(t, CheckpointReasonShardEnd, history.changes[1].Reason)
+	}
+}
+
+func TestRestoreCheckpoints(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	subSequenceNumber := int64(3)
+	history := &memoryHistory{changes: []CheckpointChange{
+		{ShardID: "0001", OldCheckpoint: "", NewCheckpoint: "100", NewSubSequenceNumber: &subSequenceNumber, Time: start},
+		{ShardID: "0001", OldCheckpoint: "100", OldSubSequenceNumber: &subSequenceNumber, NewCheckpoint: "200", Time: start.Add(30 * time.Minute)},
+	}}
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:          &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:        &types.AttributeValueMemberS{Value: "abc"},
+			SequenceNumberKey:    &types.AttributeValueMemberS{Value: "200"},
+			PendingCheckpointKey: &types.AttributeValueMemberS{Value: "250"},
+		},
+		// the shards of the lease table, 0002 has no history
+		scanItems: []map[string]types.AttributeValue{
+			{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0001"}},
+			{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0002"}},
+		},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "admin")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).WithCheckpointHistory(history)
+
+	restored, err := checkpointer.RestoreCheckpoints(start.Add(10 * time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"0001": "100"}, restored)
+
+	assert.Equal(t, "100", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, "3", svc.item[SubSequenceNumberKey].(*types.AttributeValueMemberN).Value)
+	assert.NotContains(t, svc.item, LeaseOwnerKey)
+	assert.NotContains(t, svc.item, PendingCheckpointKey)
+
+	if assert.Len(t, history.changes, 3) {
+		restore := history.changes[2]
+		assert.Equal(t, "200", restore.OldCheckpoint)
+		assert.Equal(t, "100", restore.NewCheckpoint)
+		assert.Equal(t, "admin", restore.WorkerID)
+		assert.Equal(t, CheckpointReasonRestore, restore.Reason)
+	}
+}
+
+func TestRestoreCheckpointsNotSupported(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+
+	_, err := NewDynamoCheckpoint(kclConfig).RestoreCheckpoints(time.Now())
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+// historyDynamoDB stores the items of the history table, scans them one page per item and queries them by shard and
+// change time
+type historyDynamoDB struct {
+	DynamoDBAPI
+	items       []map[string]types.AttributeValue
+	queryInputs []*dynamodb.QueryInput
+}
+
+func (m *historyDynamoDB) PutItem(_ context.Context, params *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.items = append(m.items, params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *historyDynamoDB) Scan(_ context.Context, params *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	page := 0
+	if params.ExclusiveStartKey != nil {
+		page, _ = strconv.Atoi(params.ExclusiveStartKey[ChangeTimeKey].(*types.AttributeValueMemberN).Value)
+	}
+
+	output := &dynamodb.ScanOutput{Items: m.items[page : page+1]}
+	if page+1 < len(m.items) {
+		output.LastEvaluatedKey = map[string]types.AttributeValue{
+			ChangeTimeKey: &types.AttributeValueMemberN{Value: strconv.Itoa(page + 1)},
+		}
+	}
+	return output, nil
+}
+
+func (m *historyDynamoDB) Query(_ context.Context, params *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	m.queryInputs = append(m.queryInputs, params)
+	shardID := params.ExpressionAttributeValues[":shard_id"].(*types.AttributeValueMemberS).Value
+	at, _ := strconv.ParseInt(params.ExpressionAttributeValues[":at"].(*types.AttributeValueMemberN).Value, 10, 64)
+	before := strings.Contains(aws.ToString(params.KeyConditionExpression), ChangeTimeKey+" <= :at")
+
+	var items []map[string]types.AttributeValue
+	for _, item := range m.items {
+		changeTime, _ := strconv.ParseInt(item[ChangeTimeKey].(*types.AttributeValueMemberN).Value, 10, 64)
+		if readString(item, LeaseKeyKey) == shardID && (changeTime <= at) == before {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		first := *readInt64(items[i], ChangeTimeKey) < *readInt64(items[j], ChangeTimeKey)
+		return first == aws.ToBool(params.ScanIndexForward)
+	})
+	if limit := int(aws.ToInt32(params.Limit)); limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return &dynamodb.QueryOutput{Items: items, Count: int32(len(items))}, nil
+}
+
+func TestDynamoCheckpointHistoryCheckpointAt(t *testing.T) {
+	svc := &historyDynamoDB{}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	history := NewDynamoCheckpointHistory(kclConfig).WithDynamoDB(svc)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, change := range []CheckpointChange{
+		{ShardID: "0001", OldCheckpoint: "200", NewCheckpoint: "300", Time: start.Add(3 * time.Hour)},
+		{ShardID: "0001", OldCheckpoint: "", NewCheckpoint: "100", Time: start},
+		{ShardID: "0001", OldCheckpoint: "100", NewCheckpoint: "200", Time: start.Add(time.Hour)},
+		{ShardID: "0002", OldCheckpoint: "", NewCheckpoint: "500", Time: start.Add(4 * time.Hour)},
+		{ShardID: "0003", OldCheckpoint: "800", NewCheckpoint: "900", Time: start.Add(6 * time.Hour)},
+		{ShardID: "0003", OldCheckpoint: "700", NewCheckpoint: "800", Time: start.Add(5 * time.Hour)},
+	} {
+		assert.Nil(t, history.AppendCheckpointChange(change))
+	}
+	at := start.Add(2 * time.Hour)
+
+	position, ok, err := history.CheckpointAt("0001", at)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "200", position.NewCheckpoint)
+	// only the newest change up to that time is read
+	if assert.Len(t, svc.queryInputs, 1) {
+		assert.Equal(t, "ShardID = :shard_id AND ChangeTime <= :at", aws.ToString(svc.queryInputs[0].KeyConditionExpression))
+		assert.False(t, aws.ToBool(svc.queryInputs[0].ScanIndexForward))
+		assert.Equal(t, int32(1), aws.ToInt32(svc.queryInputs[0].Limit))
+	}
+
+	// no checkpoint yet at that time
+	position, ok, err = history.CheckpointAt("0002", at)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "", position.NewCheckpoint)
+
+	// the checkpoint the first later change started from
+	position, ok, err = history.CheckpointAt("0003", at)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "700", position.NewCheckpoint)
+
+	_, ok, err = history.CheckpointAt("0004", at)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestDynamoCheckpointHistory(t *testing.T) {
+	svc := &historyDynamoDB{}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").WithTableName("leases")
+	history := NewDynamoCheckpointHistory(kclConfig).WithDynamoDB(svc)
+	assert.Equal(t, "leases-history", history.TableName)
+
+	subSequenceNumber := int64(7)
+	changes := []CheckpointChange{
+		{ShardID: "0001", NewCheckpoint: "100", NewSubSequenceNumber: &subSequenceNumber, WorkerID: "abc",
+			Time: time.Unix(0, 1700000000000000001).UTC(), Reason: CheckpointReasonCheckpoint},
+		{ShardID: "0001", OldCheckpoint: "100", OldSubSequenceNumber: &subSequenceNumber, NewCheckpoint: ShardEnd,
+			WorkerID: "abc", Time: time.Unix(0, 1700000000000000002).UTC(), Reason: CheckpointReasonShardEnd},
+	}
+	for _, change := range changes {
+		assert.Nil(t, history.AppendCheckpointChange(change))
+	}
+
+	listed, err := history.ListCheckpointChanges()
+	assert.Nil(t, err)
+	assert.Equal(t, changes, listed)
+	assert.Equal(t, "1700000000000000001", svc.items[0][ChangeTimeKey].(*types.AttributeValueMemberN).Value)
+}
@@ -0,0 +1,410 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attributes of the lease table of the Java KCL 2.x
+const (
+	JavaLeaseKeyKey          = "leaseKey"
+	JavaLeaseOwnerKey        = "leaseOwner"
+	JavaLeaseCounterKey      = "leaseCounter"
+	JavaCheckpointKey        = "checkpoint"
+	JavaSubSequenceNumberKey = "checkpointSubSequenceNumber"
+	JavaOwnerSwitchesKey     = "ownerSwitchesSinceCheckpoint"
+	JavaParentShardIdsKey    = "parentShardIds"
+)
+
+// javaAttributeNames maps the attributes of this library to the ones of the Java KCL. The other attributes
+// (LeaseTimeout, ClaimRequest, Sticky...) keep their names: the Java KCL ignores them.
+var javaAttributeNames = map[string]string{
+	LeaseKeyKey:          JavaLeaseKeyKey,
+	LeaseOwnerKey:        JavaLeaseOwnerKey,
+	LeaseCounterKey:      JavaLeaseCounterKey,
+	SequenceNumberKey:    JavaCheckpointKey,
+	SubSequenceNumberKey: JavaSubSequenceNumberKey,
+	OwnerSwitchesKey:     JavaOwnerSwitchesKey,
+}
+
+var nativeAttributeNames = func() map[string]string {
+	names := make(map[string]string, len(javaAttributeNames))
+	for native, java := range javaAttributeNames {
+		names[java] = native
+	}
+	return names
+}()
+
+// javaAttributeNamePattern matches the attribute names of this library in an expression, but not in the names of
+// the expression values (":checkpoint") or in longer attribute names (PendingCheckpoint)
+var javaAttributeNamePattern = func() *regexp.Regexp {
+	names := make([]string, 0, len(javaAttributeNames))
+	for native := range javaAttributeNames {
+		names = append(names, native)
+	}
+	sort.Strings(names)
+	return regexp.MustCompile(`(^|[^\w:#])(` + strings.Join(names, "|") + `)\b`)
+}()
+
+// javaCheckpointSetPattern matches the assignment of the checkpoint in the SET clause of an update expression
+var javaCheckpointSetPattern = regexp.MustCompile(`(^|[\s,])` + SequenceNumberKey + ` = `)
+
+// javaPositionCheckpoints are the checkpoints the Java KCL initializes its leases with, which aren't sequence numbers
+var javaPositionCheckpoints = map[string]bool{"TRIM_HORIZON": true, "LATEST": true, "AT_TIMESTAMP": true}
+
+// javaSchemaDynamoDB reads and writes the lease table in the layout of the Java KCL 2.x, mapping the attribute names
+// of the items, keys and expressions of the requests of the checkpointer.
+//
+// The parent shards of a lease are kept in ParentShardId and AdjacentParentShardId and copied to the parentShardIds
+// set of new leases. A checkpoint without sub-sequence number is written with the checkpointSubSequenceNumber 0 the
+// Java KCL expects. Leases the Java KCL initialized at TRIM_HORIZON, LATEST or AT_TIMESTAMP are read without
+// checkpoint, i.e. start at the initial position of the configuration, and keep their Java checkpoint until the
+// first checkpoint.
+type javaSchemaDynamoDB struct {
+	DynamoDBAPI
+}
+
+// newJavaSchemaDynamoDB wraps svc unless it already reads and writes the Java layout
+func newJavaSchemaDynamoDB(svc DynamoDBAPI) DynamoDBAPI {
+	if _, ok := svc.(*javaSchemaDynamoDB); ok {
+		return svc
+	}
+	return &javaSchemaDynamoDB{DynamoDBAPI: svc}
+}
+
+func (j *javaSchemaDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	input := *params
+	input.ProjectionExpression = javaExpression(params.ProjectionExpression)
+	if input.ProjectionExpression != nil {
+		// the parents of the leases are read from their parentShardIds set
+		input.ProjectionExpression = aws.String(*input.ProjectionExpression + ", " + JavaParentShardIdsKey)
+	}
+	input.FilterExpression = javaExpression(params.FilterExpression)
+	input.ExclusiveStartKey = toJavaItem(params.ExclusiveStartKey)
+
+	output, err := j.DynamoDBAPI.Scan(ctx, &input, optFns...)
+	if output != nil {
+		for i, item := range output.Items {
+			output.Items[i] = fromJavaItem(item)
+		}
+		output.LastEvaluatedKey = fromJavaItem(output.LastEvaluatedKey)
+	}
+	return output, err
+}
+
+func (j *javaSchemaDynamoDB) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	input := *params
+	input.AttributeDefinitions = javaAttributeDefinitions(params.AttributeDefinitions)
+	input.KeySchema = javaKeySchema(params.KeySchema)
+	if params.GlobalSecondaryIndexes != nil {
+		input.GlobalSecondaryIndexes = make([]types.GlobalSecondaryIndex, len(params.GlobalSecondaryIndexes))
+		for i, index := range params.GlobalSecondaryIndexes {
+			index.KeySchema = javaKeySchema(index.KeySchema)
+			input.GlobalSecondaryIndexes[i] = index
+		}
+	}
+
+	return j.DynamoDBAPI.CreateTable(ctx, &input, optFns...)
+}
+
+func (j *javaSchemaDynamoDB) UpdateTable(ctx context.Context, params *dynamodb.UpdateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error) {
+	input := *params
+	input.AttributeDefinitions = javaAttributeDefinitions(params.AttributeDefinitions)
+	if params.GlobalSecondaryIndexUpdates != nil {
+		input.GlobalSecondaryIndexUpdates = make([]types.GlobalSecondaryIndexUpdate, len(params.GlobalSecondaryIndexUpdates))
+		for i, update := range params.GlobalSecondaryIndexUpdates {
+			if update.Create != nil {
+				create := *update.Create
+				create.KeySchema = javaKeySchema(create.KeySchema)
+				update.Create = &create
+			}
+			input.GlobalSecondaryIndexUpdates[i] = update
+		}
+	}
+
+	return j.DynamoDBAPI.UpdateTable(ctx, &input, optFns...)
+}
+
+func (j *javaSchemaDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	input := *params
+	input.KeyConditionExpression = javaExpression(params.KeyConditionExpression)
+	input.ProjectionExpression = javaExpression(params.ProjectionExpression)
+	input.FilterExpression = javaExpression(params.FilterExpression)
+	input.ExclusiveStartKey = toJavaItem(params.ExclusiveStartKey)
+
+	output, err := j.DynamoDBAPI.Query(ctx, &input, optFns...)
+	if output != nil {
+		for i, item := range output.Items {
+			output.Items[i] = fromJavaItem(item)
+		}
+		output.LastEvaluatedKey = fromJavaItem(output.LastEvaluatedKey)
+	}
+	return output, err
+}
+
+func (j *javaSchemaDynamoDB) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	output, err := j.DynamoDBAPI.DescribeTable(ctx, params, optFns...)
+	if output != nil && output.Table != nil {
+		table := *output.Table
+		table.KeySchema = make([]types.KeySchemaElement, len(output.Table.KeySchema))
+		for i, element := range output.Table.KeySchema {
+			element.AttributeName = aws.String(nativeAttributeName(aws.ToString(element.AttributeName)))
+			table.KeySchema[i] = element
+		}
+		output.Table = &table
+	}
+	return output, err
+}
+
+func (j *javaSchemaDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	input := *params
+	input.Item = toJavaItem(params.Item)
+	input.ConditionExpression = javaExpression(params.ConditionExpression)
+
+	output, err := j.DynamoDBAPI.PutItem(ctx, &input, optFns...)
+	if output != nil {
+		output.Attributes = fromJavaItem(output.Attributes)
+	}
+	return output, err
+}
+
+func (j *javaSchemaDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	input := *params
+	input.Key = toJavaItem(params.Key)
+	input.ProjectionExpression = javaExpression(params.ProjectionExpression)
+
+	output, err := j.DynamoDBAPI.GetItem(ctx, &input, optFns...)
+	if output != nil {
+		output.Item = fromJavaItem(output.Item)
+	}
+	return output, err
+}
+
+func (j *javaSchemaDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	input := *params
+	input.Key = toJavaItem(params.Key)
+	updateExpression, expressionAttributeValues := javaCheckpointUpdate(params.UpdateExpression,
+		params.ExpressionAttributeValues)
+	input.UpdateExpression = javaExpression(updateExpression)
+	input.ExpressionAttributeValues = expressionAttributeValues
+	input.ConditionExpression = javaExpression(params.ConditionExpression)
+
+	output, err := j.DynamoDBAPI.UpdateItem(ctx, &input, optFns...)
+	if output != nil {
+		output.Attributes = fromJavaItem(output.Attributes)
+	}
+	return output, err
+}
+
+func (j *javaSchemaDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	input := *params
+	input.Key = toJavaItem(params.Key)
+	input.ConditionExpression = javaExpression(params.ConditionExpression)
+
+	output, err := j.DynamoDBAPI.DeleteItem(ctx, &input, optFns...)
+	if output != nil {
+		output.Attributes = fromJavaItem(output.Attributes)
+	}
+	return output, err
+}
+
+// javaAttributeDefinitions returns the attribute definitions of a table or index in the Java layout
+func javaAttributeDefinitions(definitions []types.AttributeDefinition) []types.AttributeDefinition {
+	if definitions == nil {
+		return nil
+	}
+
+	javaDefinitions := make([]types.AttributeDefinition, len(definitions))
+	for i, definition := range definitions {
+		definition.AttributeName = aws.String(javaAttributeName(aws.ToString(definition.AttributeName)))
+		javaDefinitions[i] = definition
+	}
+	return javaDefinitions
+}
+
+// javaKeySchema returns the key schema of a table or index in the Java layout
+func javaKeySchema(keySchema []types.KeySchemaElement) []types.KeySchemaElement {
+	if keySchema == nil {
+		return nil
+	}
+
+	javaKeySchema := make([]types.KeySchemaElement, len(keySchema))
+	for i, element := range keySchema {
+		element.AttributeName = aws.String(javaAttributeName(aws.ToString(element.AttributeName)))
+		javaKeySchema[i] = element
+	}
+	return javaKeySchema
+}
+
+// javaAttributeName returns the name of the attribute in the Java layout
+func javaAttributeName(name string) string {
+	if javaName, ok := javaAttributeNames[name]; ok {
+		return javaName
+	}
+	return name
+}
+
+// nativeAttributeName returns the name of the attribute of the Java layout in the layout of this library
+func nativeAttributeName(name string) string {
+	if nativeName, ok := nativeAttributeNames[name]; ok {
+		return nativeName
+	}
+	return name
+}
+
+// javaExpression replaces the attribute names of this library in the expression with the ones of the Java layout
+func javaExpression(expression *string) *string {
+	if expression == nil {
+		return nil
+	}
+
+	return aws.String(javaAttributeNamePattern.ReplaceAllStringFunc(*expression, func(match string) string {
+		if javaName, ok := javaAttributeNames[match]; ok {
+			return javaName
+		}
+		// the match starts with the character before the name
+		return match[:1] + javaAttributeNames[match[1:]]
+	}))
+}
+
+// javaCheckpointUpdate replaces the removal of the sub-sequence number of a checkpoint in the update expression
+// with the sub-sequence number 0 of the Java KCL
+func javaCheckpointUpdate(expression *string, values map[string]types.AttributeValue) (*string, map[string]types.AttributeValue) {
+	if expression == nil {
+		return expression, values
+	}
+
+	set, remove, found := strings.Cut(*expression, "REMOVE ")
+	if !found || !javaCheckpointSetPattern.MatchString(set) {
+		return expression, values
+	}
+
+	var removed bool
+	var names []string
+	for _, name := range strings.Split(remove, ",") {
+		if name = strings.TrimSpace(name); name == SubSequenceNumberKey {
+			removed = true
+		} else if name != "" {
+			names = append(names, name)
+		}
+	}
+	if !removed {
+		return expression, values
+	}
+
+	updateExpression := strings.TrimSpace(set) + ", " + SubSequenceNumberKey + " = :java_sub_sequence_number"
+	if len(names) > 0 {
+		updateExpression += " REMOVE " + strings.Join(names, ", ")
+	}
+
+	javaValues := make(map[string]types.AttributeValue, len(values)+1)
+	for name, value := range values {
+		javaValues[name] = value
+	}
+	javaValues[":java_sub_sequence_number"] = &types.AttributeValueMemberN{Value: "0"}
+	return aws.String(updateExpression), javaValues
+}
+
+// toJavaItem returns the item or key in the Java layout
+func toJavaItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+
+	javaItem := make(map[string]types.AttributeValue, len(item)+1)
+	for name, value := range item {
+		// Java columns the checkpointer preserved are superseded by the columns it wrote
+		if nativeName, ok := nativeAttributeNames[name]; ok {
+			if _, ok := item[nativeName]; ok {
+				continue
+			}
+		}
+		javaItem[javaAttributeName(name)] = value
+	}
+
+	if _, ok := javaItem[JavaParentShardIdsKey]; !ok {
+		var parents []string
+		for _, name := range []string{ParentShardIdKey, AdjacentParentShardIdKey} {
+			if parent, ok := item[name].(*types.AttributeValueMemberS); ok && parent.Value != "" {
+				parents = append(parents, parent.Value)
+			}
+		}
+		if len(parents) > 0 {
+			javaItem[JavaParentShardIdsKey] = &types.AttributeValueMemberSS{Value: parents}
+		}
+	}
+
+	// the Java KCL reads a checkpoint without sub-sequence number as the whole record
+	if _, ok := item[SequenceNumberKey]; ok {
+		if _, ok := item[SubSequenceNumberKey]; !ok {
+			javaItem[JavaSubSequenceNumberKey] = &types.AttributeValueMemberN{Value: "0"}
+		}
+	}
+	return javaItem
+}
+
+// fromJavaItem returns the item or key in the layout of this library
+func fromJavaItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+
+	// a lease initialized at a position keeps its Java checkpoint columns, which the checkpointer doesn't read
+	checkpoint, ok := item[JavaCheckpointKey].(*types.AttributeValueMemberS)
+	positionCheckpoint := ok && javaPositionCheckpoints[checkpoint.Value]
+
+	nativeItem := make(map[string]types.AttributeValue, len(item)+1)
+	for name, value := range item {
+		if positionCheckpoint && (name == JavaCheckpointKey || name == JavaSubSequenceNumberKey) {
+			nativeItem[name] = value
+			continue
+		}
+		if nativeName, ok := nativeAttributeNames[name]; ok {
+			name = nativeName
+		}
+		nativeItem[name] = value
+	}
+
+	// a shard has two parents at most: the shards it was merged from
+	if parents, ok := item[JavaParentShardIdsKey].(*types.AttributeValueMemberSS); ok && len(parents.Value) > 0 {
+		sorted := append([]string(nil), parents.Value...)
+		sort.Strings(sorted)
+		for _, parent := range sorted {
+			if parent == readString(nativeItem, ParentShardIdKey) || parent == readString(nativeItem, AdjacentParentShardIdKey) {
+				continue
+			}
+			if _, ok := nativeItem[ParentShardIdKey]; !ok {
+				nativeItem[ParentShardIdKey] = &types.AttributeValueMemberS{Value: parent}
+			} else if _, ok := nativeItem[AdjacentParentShardIdKey]; !ok {
+				nativeItem[AdjacentParentShardIdKey] = &types.AttributeValueMemberS{Value: parent}
+			}
+		}
+	}
+	return nativeItem
+}
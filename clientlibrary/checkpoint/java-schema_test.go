@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// leaseRowDynamoDB stores the raw lease row of a single shard, e.g. as written by the Java KCL
+type leaseRowDynamoDB struct {
+	DynamoDBAPI
+	item map[string]types.AttributeValue
+}
+
+func (m *leaseRowDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	item := make(map[string]types.AttributeValue, len(m.item))
+	for key, value := range m.item {
+		item[key] = value
+	}
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+func (m *leaseRowDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if !evalCondition(m.item, aws.ToString(params.ConditionExpression), params.ExpressionAttributeValues) {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	m.item = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *leaseRowDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if params.ConditionExpression != nil &&
+		!evalCondition(m.item, aws.ToString(params.ConditionExpression), params.ExpressionAttributeValues) {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	applyUpdate(m.item, aws.ToString(params.UpdateExpression), params.ExpressionAttributeValues)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func newJavaSchemaCheckpointer(svc DynamoDBAPI) *DynamoCheckpoint {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseTableSchema(config.LeaseTableSchemaJava)
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	checkpointer.LeaseDuration = 50
+	return checkpointer
+}
+
+func TestJavaExpression(t *testing.T) {
+	tests := map[string]string{
+		"AssignedTo = :assigned_to AND attribute_not_exists(RewindRequest)":                                         "leaseOwner = :assigned_to AND attribute_not_exists(RewindRequest)",
+		"ShardID = :id AND AssignedTo = :assigned_to AND LeaseCounter = :lease_counter":                             "leaseKey = :id AND leaseOwner = :assigned_to AND leaseCounter = :lease_counter",
+		"SET Checkpoint = :checkpoint, CheckpointSubSequenceNumber = :sub_sequence_number REMOVE PendingCheckpoint": "SET checkpoint = :checkpoint, checkpointSubSequenceNumber = :sub_sequence_number REMOVE PendingCheckpoint",
+		"ShardID,AssignedTo,Checkpoint": "leaseKey,leaseOwner,checkpoint",
+		"remove AssignedTo":             "remove leaseOwner",
+	}
+
+	for expression, want := range tests {
+		assert.Equal(t, want, aws.ToString(javaExpression(aws.String(expression))))
+	}
+	assert.Nil(t, javaExpression(nil))
+}
+
+func TestJavaSchemaTakeOver(t *testing.T) {
+	svc := &leaseRowDynamoDB{item: map[string]types.AttributeValue{
+		JavaLeaseKeyKey:          &types.AttributeValueMemberS{Value: "shardId-0001"},
+		JavaLeaseOwnerKey:        &types.AttributeValueMemberS{Value: "java-worker"},
+		JavaLeaseCounterKey:      &types.AttributeValueMemberN{Value: "7"},
+		JavaCheckpointKey:        &types.AttributeValueMemberS{Value: "100"},
+		JavaSubSequenceNumberKey: &types.AttributeValueMemberN{Value: "0"},
+		JavaOwnerSwitchesKey:     &types.AttributeValueMemberN{Value: "0"},
+		JavaParentShardIdsKey:    &types.AttributeValueMemberSS{Value: []string{"shardId-0000"}},
+		"childShardIds":          &types.AttributeValueMemberSS{Value: []string{"shardId-0002"}},
+	}}
+	checkpointer := newJavaSchemaCheckpointer(svc)
+	shard := &par.ShardStatus{ID: "shardId-0001", ParentShardId: "shardId-0000", Mux: &sync.RWMutex{}}
+
+	// the lease of the Java worker expires once its counter was seen unchanged for the lease duration
+	assert.IsType(t, ErrLeaseNotAcquired{}, checkpointer.GetLease(shard, "abc"))
+	svc.item[JavaLeaseCounterKey] = &types.AttributeValueMemberN{Value: "8"}
+	time.Sleep(60 * time.Millisecond)
+	assert.IsType(t, ErrLeaseNotAcquired{}, checkpointer.GetLease(shard, "abc"))
+	time.Sleep(60 * time.Millisecond)
+
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.Equal(t, "100", shard.GetCheckpoint())
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, "abc", attributeString(svc.item[JavaLeaseOwnerKey]))
+	assert.Equal(t, "9", attributeString(svc.item[JavaLeaseCounterKey]))
+	assert.Equal(t, "1", attributeString(svc.item[JavaOwnerSwitchesKey]))
+	assert.Equal(t, "100", attributeString(svc.item[JavaCheckpointKey]))
+	assert.Equal(t, []string{"shardId-0000"}, svc.item[JavaParentShardIdsKey].(*types.AttributeValueMemberSS).Value)
+	assert.Contains(t, svc.item, "childShardIds")
+	assert.NotContains(t, svc.item, LeaseKeyKey)
+	assert.NotContains(t, svc.item, LeaseOwnerKey)
+
+	// the owner renews its lease right away and resets the owner switches when it checkpoints
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, "10", attributeString(svc.item[JavaLeaseCounterKey]))
+	shard.SetCheckpoint("200")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.Equal(t, "200", attributeString(svc.item[JavaCheckpointKey]))
+	assert.Equal(t, "0", attributeString(svc.item[JavaOwnerSwitchesKey]))
+	assert.Equal(t, "0", attributeString(svc.item[JavaSubSequenceNumberKey]))
+}
+
+func TestJavaSchemaPositionCheckpoint(t *testing.T) {
+	svc := &leaseRowDynamoDB{item: map[string]types.AttributeValue{
+		JavaLeaseKeyKey:          &types.AttributeValueMemberS{Value: "shardId-0001"},
+		JavaLeaseCounterKey:      &types.AttributeValueMemberN{Value: "0"},
+		JavaCheckpointKey:        &types.AttributeValueMemberS{Value: "TRIM_HORIZON"},
+		JavaSubSequenceNumberKey: &types.AttributeValueMemberN{Value: "0"},
+	}}
+	checkpointer := newJavaSchemaCheckpointer(svc)
+	shard := &par.ShardStatus{ID: "shardId-0001", Mux: &sync.RWMutex{}}
+
+	// a lease the Java KCL initialized at a position starts at the initial position of the configuration
+	assert.Equal(t, ErrSequenceIDNotFound, checkpointer.FetchCheckpoint(shard))
+
+	// an unowned lease is taken right away and keeps its Java checkpoint until the first checkpoint
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, "TRIM_HORIZON", attributeString(svc.item[JavaCheckpointKey]))
+	assert.Equal(t, "1", attributeString(svc.item[JavaLeaseCounterKey]))
+	assert.Equal(t, "0", attributeString(svc.item[JavaOwnerSwitchesKey]))
+
+	shard.SetCheckpoint("300")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.Equal(t, "300", attributeString(svc.item[JavaCheckpointKey]))
+	assert.Equal(t, "0", attributeString(svc.item[JavaSubSequenceNumberKey]))
+}
+
+func TestJavaCheckpointUpdate(t *testing.T) {
+	tests := map[string]string{
+		"SET Checkpoint = :checkpoint REMOVE ClaimRequest, PendingCheckpoint, RewindRequest, CheckpointSubSequenceNumber": "SET Checkpoint = :checkpoint, CheckpointSubSequenceNumber = :java_sub_sequence_number REMOVE ClaimRequest, PendingCheckpoint, RewindRequest",
+		"SET Checkpoint = :checkpoint REMOVE CheckpointSubSequenceNumber":                                                 "SET Checkpoint = :checkpoint, CheckpointSubSequenceNumber = :java_sub_sequence_number",
+		"SET Checkpoint = :checkpoint, CheckpointSubSequenceNumber = :sub_sequence_number REMOVE PendingCheckpoint":       "SET Checkpoint = :checkpoint, CheckpointSubSequenceNumber = :sub_sequence_number REMOVE PendingCheckpoint",
+		"SET PendingCheckpoint = :pending REMOVE CheckpointSubSequenceNumber":                                             "SET PendingCheckpoint = :pending REMOVE CheckpointSubSequenceNumber",
+		"REMOVE AssignedTo, Checkpoint, CheckpointSubSequenceNumber":                                                      "REMOVE AssignedTo, Checkpoint, CheckpointSubSequenceNumber",
+	}
+
+	values := map[string]types.AttributeValue{":checkpoint": &types.AttributeValueMemberS{Value: "200"}}
+	for expression, want := range tests {
+		updateExpression, expressionAttributeValues := javaCheckpointUpdate(aws.String(expression), values)
+		assert.Equal(t, want, aws.ToString(updateExpression))
+		if want != expression {
+			assert.Equal(t, "0", attributeString(expressionAttributeValues[":java_sub_sequence_number"]))
+		}
+	}
+	assert.NotContains(t, values, ":java_sub_sequence_number")
+}
+
+func TestJavaSchemaMergedShard(t *testing.T) {
+	svc := &leaseRowDynamoDB{item: map[string]types.AttributeValue{
+		JavaLeaseKeyKey:       &types.AttributeValueMemberS{Value: "shardId-0003"},
+		JavaLeaseCounterKey:   &types.AttributeValueMemberN{Value: "0"},
+		JavaCheckpointKey:     &types.AttributeValueMemberS{Value: "TRIM_HORIZON"},
+		JavaParentShardIdsKey: &types.AttributeValueMemberSS{Value: []string{"shardId-0002", "shardId-0001"}},
+	}}
+
+	// both parents of a lease the Java KCL wrote are read
+	shard := readLease(fromJavaItem(svc.item))
+	assert.Equal(t, "shardId-0001", shard.ParentShardId)
+	assert.Equal(t, "shardId-0002", shard.AdjacentParentShardId)
+
+	// the parent a lease written by an older version of this library is missing is read from the Java set
+	shard = readLease(fromJavaItem(map[string]types.AttributeValue{
+		JavaLeaseKeyKey:       &types.AttributeValueMemberS{Value: "shardId-0003"},
+		ParentShardIdKey:      &types.AttributeValueMemberS{Value: "shardId-0002"},
+		JavaParentShardIdsKey: &types.AttributeValueMemberSS{Value: []string{"shardId-0002", "shardId-0001"}},
+	}))
+	assert.Equal(t, "shardId-0002", shard.ParentShardId)
+	assert.Equal(t, "shardId-0001", shard.AdjacentParentShardId)
+
+	// both parents of a merged shard are written to the lease of a new shard
+	svc.item = map[string]types.AttributeValue{}
+	checkpointer := newJavaSchemaCheckpointer(svc)
+	shard = &par.ShardStatus{ID: "shardId-0003", ParentShardId: "shardId-0001", AdjacentParentShardId: "shardId-0002",
+		Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.ElementsMatch(t, []string{"shardId-0001", "shardId-0002"},
+		svc.item[JavaParentShardIdsKey].(*types.AttributeValueMemberSS).Value)
+	assert.Equal(t, "shardId-0002", attributeString(svc.item[AdjacentParentShardIdKey]))
+}
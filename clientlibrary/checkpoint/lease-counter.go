@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// leaseObservation is the lease counter of a shard last read by this worker and when it was first read. since keeps
+// the monotonic clock reading of time.Now and is only compared through time.Since.
+type leaseObservation struct {
+	owner   string
+	counter int64
+	since   time.Time
+}
+
+// leaseCounters judges the expiry of counter-based leases. Every acquisition and renewal increments the counter of
+// a lease: the lease of another worker expired once this worker saw its counter unchanged for the lease duration
+// on its own monotonic clock, whatever the clocks of the other hosts say.
+type leaseCounters struct {
+	mux          sync.Mutex
+	observations map[string]leaseObservation
+}
+
+func newLeaseCounters() *leaseCounters {
+	return &leaseCounters{observations: map[string]leaseObservation{}}
+}
+
+// isExpired records the counter of the lease and returns true if it didn't change for the lease duration
+func (l *leaseCounters) isExpired(shardID, owner string, counter int64, leaseDuration time.Duration) bool {
+	return l.unchangedFor(shardID, owner, counter) >= leaseDuration
+}
+
+// unchangedFor records the counter of the lease and returns how long this worker has seen it unchanged
+func (l *leaseCounters) unchangedFor(shardID, owner string, counter int64) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	observation, ok := l.observations[shardID]
+	if !ok || observation.owner != owner || observation.counter != counter {
+		observation = leaseObservation{owner: owner, counter: counter, since: time.Now()}
+		l.observations[shardID] = observation
+	}
+	return time.Since(observation.since)
+}
+
+// observe records the counter of a lease written by this worker
+func (l *leaseCounters) observe(shardID, owner string, counter int64) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.observations[shardID] = leaseObservation{owner: owner, counter: counter, since: time.Now()}
+}
+
+// readLeaseCounter returns the lease counter of a lease row and whether it has one
+func readLeaseCounter(item map[string]types.AttributeValue) (int64, bool) {
+	if counter := readInt64(item, LeaseCounterKey); counter != nil {
+		return *counter, true
+	}
+	return 0, false
+}
+
+// leaseCounterCondition returns the condition and values of the acquisition or renewal of a counter-based lease by
+// newAssignTo, or ErrLeaseNotAcquired if the lease is held by another worker which renewed it within the lease
+// duration
+func (checkpointer *DynamoCheckpoint) leaseCounterCondition(shardID string, item map[string]types.AttributeValue, newAssignTo string, isClaimRequestExpired bool) (string, map[string]types.AttributeValue, error) {
+	var expressionAttributeValues map[string]types.AttributeValue
+	counterCondition := "attribute_not_exists(" + LeaseCounterKey + ")"
+	counter, counterOk := readLeaseCounter(item)
+	if counterOk {
+		counterCondition = LeaseCounterKey + " = :lease_counter"
+		expressionAttributeValues = map[string]types.AttributeValue{
+			":lease_counter": &types.AttributeValueMemberN{Value: strconv.FormatInt(counter, 10)},
+		}
+	}
+
+	owner, ok := item[LeaseOwnerKey].(*types.AttributeValueMemberS)
+	if !ok {
+		return "attribute_not_exists(" + LeaseOwnerKey + ") AND " + counterCondition, expressionAttributeValues, nil
+	}
+
+	leaseDuration := time.Duration(checkpointer.LeaseDuration) * time.Millisecond
+	expired := checkpointer.leaseCounters.isExpired(shardID, owner.Value, counter, leaseDuration)
+	if !expired && owner.Value != newAssignTo && !(checkpointer.kclConfig.EnableLeaseStealing && isClaimRequestExpired) {
+		return "", nil, ErrLeaseNotAcquired{"lease counter changed within the lease duration"}
+	}
+
+	checkpointer.log.Debugf("Attempting to get a lock for shard: %s, leaseCounter: %d, assignedTo: %s, newAssignedTo: %s", shardID, counter, owner.Value, newAssignTo)
+	if expressionAttributeValues == nil {
+		expressionAttributeValues = make(map[string]types.AttributeValue)
+	}
+	expressionAttributeValues[":id"] = &types.AttributeValueMemberS{Value: shardID}
+	expressionAttributeValues[":assigned_to"] = &types.AttributeValueMemberS{Value: owner.Value}
+	return LeaseKeyKey + " = :id AND " + LeaseOwnerKey + " = :assigned_to AND " + counterCondition, expressionAttributeValues, nil
+}
+
+// writeLeaseCounterColumns increments the lease counter of a lease row acquired or renewed by newAssignTo, and counts
+// the owner switch if the lease changes hands. It returns the new counter.
+func writeLeaseCounterColumns(current map[string]types.AttributeValue, newAssignTo string, item map[string]types.AttributeValue) int64 {
+	counter, _ := readLeaseCounter(current)
+	counter++
+	item[LeaseCounterKey] = &types.AttributeValueMemberN{Value: strconv.FormatInt(counter, 10)}
+
+	var ownerSwitches int64
+	if switches := readInt64(current, OwnerSwitchesKey); switches != nil {
+		ownerSwitches = *switches
+	}
+	if owner, ok := current[LeaseOwnerKey].(*types.AttributeValueMemberS); ok && owner.Value != newAssignTo {
+		ownerSwitches++
+	}
+	item[OwnerSwitchesKey] = &types.AttributeValueMemberN{Value: strconv.FormatInt(ownerSwitches, 10)}
+	return counter
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func newCounterBasedCheckpointer(svc DynamoDBAPI) *DynamoCheckpoint {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithCounterBasedLeases(true).
+		WithLeaseStealing(true)
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	checkpointer.LeaseDuration = 50
+	return checkpointer
+}
+
+func TestCounterBasedLeaseIgnoresLeaseTimeout(t *testing.T) {
+	// the owner's clock is far behind: its lease timeout looks expired for ages
+	svc := &leaseRowDynamoDB{item: map[string]types.AttributeValue{
+		LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+		LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "def"},
+		LeaseTimeoutKey:   &types.AttributeValueMemberS{Value: "2000-01-01T00:00:00Z"},
+		LeaseCounterKey:   &types.AttributeValueMemberN{Value: "3"},
+		SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+	}}
+	checkpointer := newCounterBasedCheckpointer(svc)
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+
+	assert.IsType(t, ErrLeaseNotAcquired{}, checkpointer.GetLease(shard, "abc"))
+
+	// the owner keeps renewing its lease
+	time.Sleep(30 * time.Millisecond)
+	svc.item[LeaseCounterKey] = &types.AttributeValueMemberN{Value: "4"}
+	time.Sleep(30 * time.Millisecond)
+	assert.IsType(t, ErrLeaseNotAcquired{}, checkpointer.GetLease(shard, "abc"))
+
+	// and stops
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, "abc", attributeString(svc.item[LeaseOwnerKey]))
+	assert.Equal(t, "5", attributeString(svc.item[LeaseCounterKey]))
+	assert.Equal(t, "1", attributeString(svc.item[OwnerSwitchesKey]))
+}
+
+func TestCounterBasedLeaseClaim(t *testing.T) {
+	svc := &leaseRowDynamoDB{item: map[string]types.AttributeValue{
+		LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+		LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "def"},
+		LeaseTimeoutKey:   &types.AttributeValueMemberS{Value: "2000-01-01T00:00:00Z"},
+		LeaseCounterKey:   &types.AttributeValueMemberN{Value: "3"},
+		SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+	}}
+	checkpointer := newCounterBasedCheckpointer(svc)
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+
+	// the lease timeout of a shard is judged on the local clock, from the time its counter was first seen
+	before := time.Now()
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.WithinDuration(t, before.Add(50*time.Millisecond), shard.GetLeaseTimeout(), 10*time.Millisecond)
+	assert.False(t, shard.IsClaimRequestExpired(checkpointer.kclConfig))
+
+	// the claim is fenced by the lease counter and doesn't touch the lease
+	assert.Nil(t, checkpointer.ClaimShard(shard, "abc"))
+	assert.Equal(t, "abc", attributeString(svc.item[ClaimRequestKey]))
+	assert.Equal(t, "3", attributeString(svc.item[LeaseCounterKey]))
+	assert.Equal(t, "2000-01-01T00:00:00Z", attributeString(svc.item[LeaseTimeoutKey]))
+	assert.IsType(t, &types.ConditionalCheckFailedException{}, checkpointer.ClaimShard(shard, "ghi"))
+}
+
+func TestLeaseCountersMonotonicClock(t *testing.T) {
+	counters := newLeaseCounters()
+	counters.observe("0001", "def", 3)
+
+	// the observation keeps the monotonic clock reading, which wall clock adjustments don't move
+	assert.Contains(t, counters.observations["0001"].since.String(), "m=")
+
+	assert.False(t, counters.isExpired("0001", "def", 3, 20*time.Millisecond))
+	time.Sleep(25 * time.Millisecond)
+	assert.True(t, counters.isExpired("0001", "def", 3, 20*time.Millisecond))
+
+	// a renewal restarts the lease duration
+	assert.False(t, counters.isExpired("0001", "def", 4, 20*time.Millisecond))
+	assert.Less(t, counters.unchangedFor("0001", "def", 4), 20*time.Millisecond)
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// LeaseOwnerIndexName is the name of the global secondary index of the lease table on the lease owner
+const LeaseOwnerIndexName = "AssignedToIndex"
+
+// LeaseOwnerLister is implemented by checkpointers that can list the leases of one worker without reading the whole
+// lease table
+type LeaseOwnerLister interface {
+	// ListLeasesByOwner returns the leases held by the worker
+	ListLeasesByOwner(workerID string) ([]*par.ShardStatus, error)
+}
+
+// leaseOwnerIndexState remembers whether the lease owner index can be queried, checked at most once per lease sync
+type leaseOwnerIndexState struct {
+	mux       sync.Mutex
+	active    bool
+	checkedAt time.Time
+}
+
+// ListLeasesByOwner returns the leases held by the worker. They are queried from the lease owner index once it is
+// ACTIVE, which is eventually consistent; until then, or without the index, the lease table is scanned.
+func (checkpointer *DynamoCheckpoint) ListLeasesByOwner(workerID string) ([]*par.ShardStatus, error) {
+	if checkpointer.leaseOwnerIndexActive() {
+		items, err := checkpointer.queryLeaseOwnerIndex(workerID)
+		if err != nil {
+			return nil, err
+		}
+		return readLeases(items), nil
+	}
+
+	snapshot, err := checkpointer.ScanLeases()
+	if err != nil {
+		return nil, err
+	}
+	var leases []*par.ShardStatus
+	for _, lease := range snapshot.Leases() {
+		if lease.GetLeaseOwner() == workerID {
+			leases = append(leases, lease)
+		}
+	}
+	return leases, nil
+}
+
+// leaseOwnerIndex returns the global secondary index on the lease owner. It projects the whole lease so that the
+// leases are listed by a single query.
+func (checkpointer *DynamoCheckpoint) leaseOwnerIndex() types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName: aws.String(LeaseOwnerIndexName),
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(LeaseOwnerKey), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(LeaseKeyKey), KeyType: types.KeyTypeRange},
+		},
+		Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(checkpointer.leaseTableReadCapacity),
+			WriteCapacityUnits: aws.Int64(checkpointer.leaseTableWriteCapacity),
+		},
+	}
+}
+
+// initLeaseOwnerIndex adds the lease owner index to an existing lease table which doesn't have it yet. The index is
+// backfilled by DynamoDB in the background, the leases are scanned until it is ACTIVE.
+func (checkpointer *DynamoCheckpoint) initLeaseOwnerIndex() error {
+	output, err := checkpointer.svc.DescribeTable(context.Background(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(checkpointer.TableName),
+	})
+	if err != nil {
+		return checkpointer.dynamoDBError(err)
+	}
+	if output.Table == nil || findIndex(output.Table.GlobalSecondaryIndexes, LeaseOwnerIndexName) != nil {
+		return nil
+	}
+
+	index := checkpointer.leaseOwnerIndex()
+	if output.Table.BillingModeSummary != nil && output.Table.BillingModeSummary.BillingMode == types.BillingModePayPerRequest {
+		index.ProvisionedThroughput = nil
+	}
+
+	checkpointer.log.Infof("Adding index %s to lease table %s", LeaseOwnerIndexName, checkpointer.TableName)
+	_, err = checkpointer.svc.UpdateTable(context.Background(), &dynamodb.UpdateTableInput{
+		TableName: aws.String(checkpointer.TableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(LeaseKeyKey), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(LeaseOwnerKey), AttributeType: types.ScalarAttributeTypeS},
+		},
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+			Create: &types.CreateGlobalSecondaryIndexAction{
+				IndexName:             index.IndexName,
+				KeySchema:             index.KeySchema,
+				Projection:            index.Projection,
+				ProvisionedThroughput: index.ProvisionedThroughput,
+			},
+		}},
+	})
+
+	// another worker is adding the index
+	var resourceInUseErr *types.ResourceInUseException
+	if errors.As(err, &resourceInUseErr) {
+		return nil
+	}
+	return checkpointer.dynamoDBError(err)
+}
+
+// leaseOwnerIndexActive returns true if the lease owner index exists and is backfilled
+func (checkpointer *DynamoCheckpoint) leaseOwnerIndexActive() bool {
+	state := &checkpointer.leaseOwnerIndexState
+	state.mux.Lock()
+	defer state.mux.Unlock()
+
+	syncInterval := time.Duration(checkpointer.kclConfig.LeaseSyncingTimeIntervalMillis) * time.Millisecond
+	if state.active || time.Since(state.checkedAt) < syncInterval {
+		return state.active
+	}
+	state.checkedAt = time.Now()
+
+	output, err := checkpointer.svc.DescribeTable(context.Background(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(checkpointer.TableName),
+	})
+	if err != nil {
+		checkpointer.log.Debugf("Error describing lease table %s: %+v", checkpointer.TableName, err)
+		return false
+	}
+	if output.Table == nil {
+		return false
+	}
+
+	index := findIndex(output.Table.GlobalSecondaryIndexes, LeaseOwnerIndexName)
+	state.active = index != nil && index.IndexStatus == types.IndexStatusActive && !aws.ToBool(index.Backfilling)
+	return state.active
+}
+
+// queryLeaseOwnerIndex reads all pages of the leases of the worker from the lease owner index. Throttled requests
+// are retried with an exponential backoff.
+func (checkpointer *DynamoCheckpoint) queryLeaseOwnerIndex(workerID string) ([]map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(checkpointer.TableName),
+		IndexName:              aws.String(LeaseOwnerIndexName),
+		KeyConditionExpression: aws.String("AssignedTo = :owner"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: workerID},
+		},
+	}
+
+	var items []map[string]types.AttributeValue
+	for retry := 0; ; {
+		queryOutput, err := checkpointer.svc.Query(context.TODO(), input)
+		if err != nil {
+			err = checkpointer.dynamoDBError(err)
+			if !errors.As(err, &kcl.ThrottlingError{}) || retry >= checkpointer.kclConfig.MaxRetryCount {
+				return nil, err
+			}
+
+			// exponential backoff
+			// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/Programming.Errors.html#Programming.Errors.RetryAndBackoff
+			retry++
+			checkpointer.log.Debugf("Lease owner index query throttled, retry %d. Error: %+v", retry, err)
+			time.Sleep(time.Duration(math.Exp2(float64(retry))*100) * time.Millisecond)
+			continue
+		}
+
+		items = append(items, queryOutput.Items...)
+		if len(queryOutput.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = queryOutput.LastEvaluatedKey
+	}
+}
+
+func findIndex(indexes []types.GlobalSecondaryIndexDescription, indexName string) *types.GlobalSecondaryIndexDescription {
+	for i := range indexes {
+		if aws.ToString(indexes[i].IndexName) == indexName {
+			return &indexes[i]
+		}
+	}
+	return nil
+}
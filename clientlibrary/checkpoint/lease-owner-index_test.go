@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+)
+
+func ownerLeaseRows() []map[string]types.AttributeValue {
+	return []map[string]types.AttributeValue{
+		{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0001"}, LeaseOwnerKey: &types.AttributeValueMemberS{Value: "abc"}},
+		{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0002"}, LeaseOwnerKey: &types.AttributeValueMemberS{Value: "def"}},
+		{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0003"}, LeaseOwnerKey: &types.AttributeValueMemberS{Value: "abc"}},
+		{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0004"}},
+	}
+}
+
+func TestInitCreatesLeaseOwnerIndex(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: false, item: map[string]types.AttributeValue{}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseOwnerIndex(true).
+		WithLeaseTableOptions(config.LeaseTableOptions{PayPerRequest: true})
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	assert.Nil(t, checkpointer.Init())
+	input := svc.createTableInput
+	assert.Len(t, input.AttributeDefinitions, 2)
+	assert.Len(t, input.GlobalSecondaryIndexes, 1)
+	index := input.GlobalSecondaryIndexes[0]
+	assert.Equal(t, LeaseOwnerIndexName, aws.ToString(index.IndexName))
+	assert.Equal(t, LeaseOwnerKey, aws.ToString(index.KeySchema[0].AttributeName))
+	assert.Equal(t, types.ProjectionTypeAll, index.Projection.ProjectionType)
+	assert.Nil(t, index.ProvisionedThroughput)
+
+	// the index of a new table is not added again
+	assert.Nil(t, svc.updateTableInput)
+}
+
+func TestInitAddsLeaseOwnerIndex(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}, scanItems: ownerLeaseRows()}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseOwnerIndex(true).
+		WithLeaseSyncingIntervalMillis(0)
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	assert.Nil(t, checkpointer.Init())
+	create := svc.updateTableInput.GlobalSecondaryIndexUpdates[0].Create
+	assert.Equal(t, LeaseOwnerIndexName, aws.ToString(create.IndexName))
+	assert.Equal(t, int64(config.DefaultInitialLeaseTableReadCapacity), aws.ToInt64(create.ProvisionedThroughput.ReadCapacityUnits))
+
+	// the leases are scanned while the index is backfilled
+	leases, err := checkpointer.ListLeasesByOwner("abc")
+	assert.Nil(t, err)
+	assert.Len(t, leases, 2)
+	assert.Empty(t, svc.queryInputs)
+
+	svc.tableIndexes[0].IndexStatus = types.IndexStatusActive
+	svc.tableIndexes[0].Backfilling = aws.Bool(false)
+	leases, err = checkpointer.ListLeasesByOwner("abc")
+	assert.Nil(t, err)
+	assert.Equal(t, "0001", leases[0].ID)
+	assert.Equal(t, "0003", leases[1].ID)
+	assert.Len(t, svc.queryInputs, 1)
+	assert.Equal(t, LeaseOwnerIndexName, aws.ToString(svc.queryInputs[0].IndexName))
+
+	// an existing index is kept
+	svc.updateTableInput = nil
+	assert.Nil(t, checkpointer.Init())
+	assert.Nil(t, svc.updateTableInput)
+}
+
+func TestListLeasesByOwnerWithoutIndex(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}, scanItems: ownerLeaseRows()}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	assert.Nil(t, checkpointer.Init())
+	assert.Nil(t, svc.updateTableInput)
+
+	leases, err := checkpointer.ListLeasesByOwner("def")
+	assert.Nil(t, err)
+	assert.Len(t, leases, 1)
+	assert.Equal(t, "0002", leases[0].ID)
+	assert.Empty(t, svc.queryInputs)
+}
+
+func TestJavaSchemaLeaseOwnerIndex(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, item: map[string]types.AttributeValue{}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseOwnerIndex(true).
+		WithLeaseTableSchema(config.LeaseTableSchemaJava)
+	svc.tableKeySchema = []types.KeySchemaElement{{AttributeName: aws.String(JavaLeaseKeyKey), KeyType: types.KeyTypeHash}}
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	assert.Nil(t, checkpointer.Init())
+	assert.Equal(t, JavaLeaseOwnerKey, aws.ToString(svc.updateTableInput.AttributeDefinitions[1].AttributeName))
+	create := svc.updateTableInput.GlobalSecondaryIndexUpdates[0].Create
+	assert.Equal(t, JavaLeaseOwnerKey, aws.ToString(create.KeySchema[0].AttributeName))
+	assert.Equal(t, JavaLeaseKeyKey, aws.ToString(create.KeySchema[1].AttributeName))
+
+	svc.tableIndexes[0].IndexStatus = types.IndexStatusActive
+	svc.tableIndexes[0].Backfilling = aws.Bool(false)
+	_, err := checkpointer.ListLeasesByOwner("abc")
+	assert.Nil(t, err)
+	assert.Equal(t, "leaseOwner = :owner", aws.ToString(svc.queryInputs[0].KeyConditionExpression))
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// leaseScanColumns are the columns of the lease rows read by readLease and readCheckpoint, the only ones a scan
+// of the lease table reads
+var leaseScanColumns = []string{
+	LeaseKeyKey, LeaseOwnerKey, LeaseTimeoutKey, SequenceNumberKey, SubSequenceNumberKey, ParentShardIdKey,
+	AdjacentParentShardIdKey, ClaimRequestKey, StickyKey, StickyWorkerKey, StickyGroupKey, StickyExpiryKey,
+	DrainingKey, PendingCheckpointKey, RewindRequestKey, LeaseCounterKey,
+}
+
+// LeaseScanner is implemented by checkpointers that can read the whole lease table at once
+type LeaseScanner interface {
+	// ScanLeases reads all leases of the lease table
+	ScanLeases() (*LeaseSnapshot, error)
+}
+
+// LeaseSnapshotReader is implemented by checkpointers that can serve the reads of FetchCheckpoint from a lease
+// snapshot, so that a worker reads the lease table once per scan instead of once per shard.
+type LeaseSnapshotReader interface {
+	LeaseScanner
+
+	// FetchCheckpointFromSnapshot is FetchCheckpoint reading the lease row of the shard from the snapshot
+	FetchCheckpointFromSnapshot(snapshot *LeaseSnapshot, shard *par.ShardStatus) error
+}
+
+// LeaseSnapshot is the lease table as read by one full scan. ListActiveWorkers and lease stealing work from a
+// snapshot, never from a partially read table. The leases of a snapshot are copies: they are not updated afterwards.
+type LeaseSnapshot struct {
+	// ScannedAt is when the scan started
+	ScannedAt time.Time
+
+	leases map[string]*par.ShardStatus
+	// items are the lease rows the leases were read from, nil if the snapshot wasn't scanned
+	items map[string]map[string]types.AttributeValue
+}
+
+// NewLeaseSnapshot returns a snapshot of the leases
+func NewLeaseSnapshot(scannedAt time.Time, leases []*par.ShardStatus) *LeaseSnapshot {
+	snapshot := &LeaseSnapshot{ScannedAt: scannedAt, leases: make(map[string]*par.ShardStatus, len(leases))}
+	for _, lease := range leases {
+		snapshot.leases[lease.ID] = lease
+	}
+	return snapshot
+}
+
+// Lease returns the lease of the shard, if it is in the lease table
+func (snapshot *LeaseSnapshot) Lease(shardID string) (*par.ShardStatus, bool) {
+	lease, ok := snapshot.leases[shardID]
+	return lease, ok
+}
+
+// Leases returns all leases sorted by shard ID
+func (snapshot *LeaseSnapshot) Leases() []*par.ShardStatus {
+	leases := make([]*par.ShardStatus, 0, len(snapshot.leases))
+	for _, lease := range snapshot.leases {
+		leases = append(leases, lease)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].ID < leases[j].ID })
+	return leases
+}
+
+// Len returns the number of leases
+func (snapshot *LeaseSnapshot) Len() int {
+	return len(snapshot.leases)
+}
+
+// ScanLeases reads the lease table with a paginated scan, in parallel segments if LeaseTableScanSegments is
+// greater than 1. Throttled requests are retried with an exponential backoff.
+func (checkpointer *DynamoCheckpoint) ScanLeases() (*LeaseSnapshot, error) {
+	scannedAt := time.Now()
+
+	segments := checkpointer.kclConfig.LeaseTableScanSegments
+	if segments < 2 {
+		items, err := checkpointer.scanSegment(0, 0)
+		if err != nil {
+			return nil, err
+		}
+		return checkpointer.newLeaseSnapshot(scannedAt, items), nil
+	}
+
+	var wg sync.WaitGroup
+	segmentItems := make([][]map[string]types.AttributeValue, segments)
+	segmentErrs := make([]error, segments)
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			segmentItems[segment], segmentErrs[segment] = checkpointer.scanSegment(segment, segments)
+		}(segment)
+	}
+	wg.Wait()
+
+	if err := errors.Join(segmentErrs...); err != nil {
+		return nil, err
+	}
+
+	var items []map[string]types.AttributeValue
+	for _, segment := range segmentItems {
+		items = append(items, segment...)
+	}
+	return checkpointer.newLeaseSnapshot(scannedAt, items), nil
+}
+
+// FetchCheckpointFromSnapshot sets the checkpoint and the lease of the shard from its lease row in the snapshot.
+// Like FetchCheckpoint, it returns ErrSequenceIDNotFound if the shard has no checkpoint. A snapshot which wasn't
+// scanned by ScanLeases has no lease rows: the lease row is then read from the lease table.
+func (checkpointer *DynamoCheckpoint) FetchCheckpointFromSnapshot(snapshot *LeaseSnapshot, shard *par.ShardStatus) error {
+	if snapshot.items == nil {
+		return checkpointer.FetchCheckpoint(shard)
+	}
+
+	// a shard missing from the snapshot has no lease row yet
+	item, ok := snapshot.items[shard.ID]
+	if !ok {
+		item = map[string]types.AttributeValue{}
+	}
+	return checkpointer.readCheckpoint(shard, item)
+}
+
+// newLeaseSnapshot returns the snapshot of the scanned lease rows and keeps it for syncLeases
+func (checkpointer *DynamoCheckpoint) newLeaseSnapshot(scannedAt time.Time, items []map[string]types.AttributeValue) *LeaseSnapshot {
+	snapshot := NewLeaseSnapshot(scannedAt, readLeases(items))
+	snapshot.items = make(map[string]map[string]types.AttributeValue, len(items))
+	for _, item := range items {
+		if shardID := readString(item, LeaseKeyKey); shardID != "" {
+			snapshot.items[shardID] = item
+		}
+	}
+
+	checkpointer.leaseSnapshotMux.Lock()
+	defer checkpointer.leaseSnapshotMux.Unlock()
+	if checkpointer.leaseSnapshot == nil || checkpointer.leaseSnapshot.ScannedAt.Before(scannedAt) {
+		checkpointer.leaseSnapshot = snapshot
+	}
+	return snapshot
+}
+
+// lastLeaseSnapshot returns the last snapshot scanned by ScanLeases, nil if the lease table wasn't scanned yet
+func (checkpointer *DynamoCheckpoint) lastLeaseSnapshot() *LeaseSnapshot {
+	checkpointer.leaseSnapshotMux.Lock()
+	defer checkpointer.leaseSnapshotMux.Unlock()
+	return checkpointer.leaseSnapshot
+}
+
+// scanSegment reads all pages of a segment of the lease table, or of the whole table if totalSegments is 0. The
+// scan is eventually consistent unless LeaseTableConsistentScans is set.
+func (checkpointer *DynamoCheckpoint) scanSegment(segment, totalSegments int) ([]map[string]types.AttributeValue, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(checkpointer.TableName),
+		ProjectionExpression: aws.String(strings.Join(leaseScanColumns, ", ")),
+		ConsistentRead:       aws.Bool(checkpointer.kclConfig.LeaseTableConsistentScans),
+	}
+	if totalSegments > 0 {
+		input.Segment = aws.Int32(int32(segment))
+		input.TotalSegments = aws.Int32(int32(totalSegments))
+	}
+
+	var items []map[string]types.AttributeValue
+	for retry := 0; ; {
+		scanOutput, err := checkpointer.svc.Scan(context.TODO(), input)
+		if err != nil {
+			err = checkpointer.dynamoDBError(err)
+			if !errors.As(err, &kcl.ThrottlingError{}) || retry >= checkpointer.kclConfig.MaxRetryCount {
+				return nil, err
+			}
+
+			// exponential backoff
+			// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/Programming.Errors.html#Programming.Errors.RetryAndBackoff
+			retry++
+			checkpointer.log.Debugf("Lease table scan throttled, retry %d. Error: %+v", retry, err)
+			time.Sleep(time.Duration(math.Exp2(float64(retry))*100) * time.Millisecond)
+			continue
+		}
+
+		items = append(items, scanOutput.Items...)
+		if len(scanOutput.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = scanOutput.LastEvaluatedKey
+	}
+}
+
+// readLeases returns the leases of the lease rows
+func readLeases(items []map[string]types.AttributeValue) []*par.ShardStatus {
+	leases := make([]*par.ShardStatus, 0, len(items))
+	for _, item := range items {
+		if lease := readLease(item); lease != nil {
+			leases = append(leases, lease)
+		}
+	}
+	return leases
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// pagedScanDynamoDB returns one lease row per Scan page, splitting the rows between segments by index
+type pagedScanDynamoDB struct {
+	mockDynamoDB
+	mux       sync.Mutex
+	throttles int
+	requests  []*dynamodb.ScanInput
+}
+
+func (m *pagedScanDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.requests = append(m.requests, params)
+
+	if m.throttles > 0 {
+		m.throttles--
+		return nil, &types.ProvisionedThroughputExceededException{Message: aws.String("throttled")}
+	}
+
+	var items []map[string]types.AttributeValue
+	for i, item := range m.scanItems {
+		if params.TotalSegments == nil || int32(i)%aws.ToInt32(params.TotalSegments) == aws.ToInt32(params.Segment) {
+			items = append(items, item)
+		}
+	}
+
+	start := 0
+	if params.ExclusiveStartKey != nil {
+		for i, item := range items {
+			if attributeString(item[LeaseKeyKey]) == attributeString(params.ExclusiveStartKey[LeaseKeyKey]) {
+				start = i + 1
+			}
+		}
+	}
+	if start >= len(items) {
+		return &dynamodb.ScanOutput{}, nil
+	}
+
+	page := items[start : start+1]
+	output := &dynamodb.ScanOutput{Items: page, Count: 1}
+	if start+1 < len(items) {
+		output.LastEvaluatedKey = map[string]types.AttributeValue{LeaseKeyKey: page[0][LeaseKeyKey]}
+	}
+	return output, nil
+}
+
+func leaseRows(count int) []map[string]types.AttributeValue {
+	rows := make([]map[string]types.AttributeValue, 0, count)
+	for i := 0; i < count; i++ {
+		rows = append(rows, map[string]types.AttributeValue{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: fmt.Sprintf("%04d", i)},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: fmt.Sprintf("worker-%d", i%2)},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+		})
+	}
+	return rows
+}
+
+func TestScanLeasesPaginated(t *testing.T) {
+	svc := &pagedScanDynamoDB{mockDynamoDB: mockDynamoDB{tableExist: true, scanItems: leaseRows(3)}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	snapshot, err := checkpointer.ScanLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, snapshot.Len())
+	assert.Len(t, svc.requests, 3)
+	for _, request := range svc.requests {
+		assert.False(t, aws.ToBool(request.ConsistentRead))
+		assert.Equal(t, strings.Join(leaseScanColumns, ", "), aws.ToString(request.ProjectionExpression))
+		assert.Nil(t, request.TotalSegments)
+	}
+
+	lease, ok := snapshot.Lease("0002")
+	assert.True(t, ok)
+	assert.Equal(t, "worker-0", lease.GetLeaseOwner())
+	assert.Equal(t, "100", lease.GetCheckpoint())
+	_, ok = snapshot.Lease("0003")
+	assert.False(t, ok)
+}
+
+func TestScanLeasesConsistentRead(t *testing.T) {
+	svc := &pagedScanDynamoDB{mockDynamoDB: mockDynamoDB{tableExist: true, scanItems: leaseRows(1)}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseTableConsistentScans(true)
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	_, err := checkpointer.ScanLeases()
+	assert.Nil(t, err)
+	assert.Len(t, svc.requests, 1)
+	assert.True(t, aws.ToBool(svc.requests[0].ConsistentRead))
+
+	// the lease table in the Java layout is scanned for the Java names and the parents of the leases
+	svc = &pagedScanDynamoDB{mockDynamoDB: mockDynamoDB{tableExist: true}}
+	_, err = newJavaSchemaCheckpointer(svc).ScanLeases()
+	assert.Nil(t, err)
+	projection := aws.ToString(svc.requests[0].ProjectionExpression)
+	assert.True(t, strings.HasPrefix(projection, JavaLeaseKeyKey+", "+JavaLeaseOwnerKey+", "))
+	assert.True(t, strings.HasSuffix(projection, ", "+JavaParentShardIdsKey))
+}
+
+func TestScanLeasesParallelSegments(t *testing.T) {
+	svc := &pagedScanDynamoDB{mockDynamoDB: mockDynamoDB{tableExist: true, scanItems: leaseRows(7)}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseTableScanSegments(3)
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	snapshot, err := checkpointer.ScanLeases()
+	assert.Nil(t, err)
+	var ids []string
+	for _, lease := range snapshot.Leases() {
+		ids = append(ids, lease.ID)
+	}
+	assert.Equal(t, []string{"0000", "0001", "0002", "0003", "0004", "0005", "0006"}, ids)
+
+	segments := map[int32]bool{}
+	for _, request := range svc.requests {
+		assert.Equal(t, int32(3), aws.ToInt32(request.TotalSegments))
+		segments[aws.ToInt32(request.Segment)] = true
+	}
+	assert.Len(t, segments, 3)
+}
+
+func TestScanLeasesThrottled(t *testing.T) {
+	svc := &pagedScanDynamoDB{mockDynamoDB: mockDynamoDB{tableExist: true, scanItems: leaseRows(2)}, throttles: 1}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithMaxRetryCount(1)
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	snapshot, err := checkpointer.ScanLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, snapshot.Len())
+
+	// the retries are exhausted
+	svc.throttles = 2
+	_, err = checkpointer.ScanLeases()
+	assert.ErrorAs(t, err, &kcl.ThrottlingError{})
+}
+
+func TestListActiveWorkersFromSnapshot(t *testing.T) {
+	svc := &pagedScanDynamoDB{mockDynamoDB: mockDynamoDB{tableExist: true, scanItems: leaseRows(4)}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	shardStatus := map[string]*par.ShardStatus{}
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("%04d", i)
+		shardStatus[id] = &par.ShardStatus{ID: id, Mux: &sync.RWMutex{}}
+	}
+
+	workers, err := checkpointer.ListActiveWorkers(shardStatus)
+	assert.Nil(t, err)
+	assert.Len(t, workers["worker-0"], 2)
+	assert.Len(t, workers["worker-1"], 2)
+	assert.Equal(t, "worker-1", shardStatus["0003"].GetLeaseOwner())
+
+	// the snapshot is reused until the next lease sync
+	requests := len(svc.requests)
+	_, err = checkpointer.ListActiveWorkers(shardStatus)
+	assert.Nil(t, err)
+	assert.Len(t, svc.requests, requests)
+}
+
+func TestFetchCheckpointFromSnapshot(t *testing.T) {
+	rows := leaseRows(2)
+	rows[1][ClaimRequestKey] = &types.AttributeValueMemberS{Value: "worker-0"}
+	svc := &pagedScanDynamoDB{mockDynamoDB: mockDynamoDB{tableExist: true, scanItems: rows}}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	snapshot, err := checkpointer.ScanLeases()
+	assert.Nil(t, err)
+	requests := len(svc.requests)
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpointFromSnapshot(snapshot, shard))
+	assert.Equal(t, "100", shard.GetCheckpoint())
+	assert.Equal(t, "worker-1", shard.GetLeaseOwner())
+	assert.Equal(t, "worker-0", shard.GetClaimRequest())
+
+	// a shard without lease row has no checkpoint
+	assert.ErrorIs(t, checkpointer.FetchCheckpointFromSnapshot(snapshot, &par.ShardStatus{ID: "0002", Mux: &sync.RWMutex{}}),
+		ErrSequenceIDNotFound)
+	assert.Len(t, svc.requests, requests)
+
+	// the scan is reused by the next lease sync
+	_, err = checkpointer.ListActiveWorkers(map[string]*par.ShardStatus{"0001": shard})
+	assert.Nil(t, err)
+	assert.Len(t, svc.requests, requests)
+}
@@ -0,0 +1,482 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+// Package checkpoint
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// memoryLease is a row of the lease table of a MemoryCheckpoint. An empty string is a missing column.
+type memoryLease struct {
+	owner                 string
+	leaseTimeout          time.Time
+	checkpoint            string
+	subSequenceNumber     *int64
+	parentShardID         string
+	adjacentParentShardID string
+	claimRequest          string
+	sticky                int
+	stickyWorker          string
+	stickyGroup           string
+	stickyExpiry          time.Time
+	draining              string
+	pendingCheckpoint     string
+	rewindRequest         string
+}
+
+// MemoryCheckpoint is a Checkpointer keeping the lease table in memory, for unit tests and single process
+// applications which don't need their checkpoints to survive a restart. It follows the semantics of DynamoCheckpoint:
+// leases expire at their lease timeout, they are acquired and claimed with the same conditions, and the sticky
+// columns are managed through StickyAdmin. Counter-based leases are not supported: all workers share one clock.
+// It is safe for concurrent use, by several workers of the same process too.
+type MemoryCheckpoint struct {
+	log           logger.Logger
+	LeaseDuration int
+	kclConfig     *config.KinesisClientLibConfiguration
+
+	mux    sync.Mutex
+	leases map[string]*memoryLease
+}
+
+// NewMemoryCheckpoint returns an empty in-memory lease table
+func NewMemoryCheckpoint(kclConfig *config.KinesisClientLibConfiguration) *MemoryCheckpoint {
+	return newMemoryCheckpoint(kclConfig, kclConfig.FailoverTimeMillis, map[string]*memoryLease{})
+}
+
+// newMemoryCheckpoint returns an in-memory lease table holding the leases
+func newMemoryCheckpoint(kclConfig *config.KinesisClientLibConfiguration, leaseDuration int, leases map[string]*memoryLease) *MemoryCheckpoint {
+	return &MemoryCheckpoint{
+		log:           kclConfig.Logger,
+		LeaseDuration: leaseDuration,
+		kclConfig:     kclConfig,
+		leases:        leases,
+	}
+}
+
+// Init does nothing, the lease table is ready
+func (checkpointer *MemoryCheckpoint) Init() error {
+	return nil
+}
+
+// GetLease attempts to gain a lock on the given shard
+func (checkpointer *MemoryCheckpoint) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	current, ok := checkpointer.leases[shard.ID]
+	if !ok {
+		current = &memoryLease{sticky: int(par.StickyUnset)}
+	}
+
+	current.readStickyColumns(shard)
+	isClaimRequestExpired := shard.IsClaimRequestExpired(checkpointer.kclConfig)
+	shard.SetDraining(current.draining)
+	shard.SetPendingCheckpoint(current.pendingCheckpoint)
+
+	// A shard rewound since its consumer started is given up by its owner and restarted from the rewind target
+	if current.owner == newAssignTo && shard.GetLeaseOwner() == newAssignTo && current.rewindRequest != "" &&
+		current.rewindRequest != shard.GetRewindRequest() {
+		checkpointer.log.Infof("Shard %s was rewound: %s. Not going to renew the lease", shard.ID, current.rewindRequest)
+		return rewindRequestedError(shard.ID)
+	}
+
+	// The worker a shard is pinned to can always claim it back and a draining owner hands its shards
+	// over to any claimant, even without lease stealing
+	claimRequest := current.claimRequest
+	honorClaim := checkpointer.kclConfig.EnableLeaseStealing || isStickyWorkerClaim(shard, claimRequest) ||
+		(current.owner != "" && current.draining == current.owner)
+	if honorClaim && claimRequest != "" && newAssignTo != claimRequest && !isClaimRequestExpired {
+		checkpointer.log.Debugf("another worker: %s has a claim on this shard. Not going to renew the lease", claimRequest)
+		return errors.New(ErrShardClaimed)
+	}
+
+	if current.owner != "" {
+		if current.leaseTimeout.IsZero() {
+			return ErrLeaseNotAcquired{"lease of shard " + shard.ID + " has no lease timeout"}
+		}
+		if time.Now().UTC().Before(current.leaseTimeout) && current.owner != newAssignTo &&
+			(!checkpointer.kclConfig.EnableLeaseStealing || !isClaimRequestExpired) {
+			return ErrLeaseNotAcquired{"current lease timeout not yet expired"}
+		}
+	}
+
+	// like the full-row write of DynamoCheckpoint, the acquisition drops the claim and the columns the shard doesn't have
+	newLeaseTimeout := time.Now().Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
+	lease := &memoryLease{
+		owner:                 newAssignTo,
+		leaseTimeout:          newLeaseTimeout,
+		checkpoint:            shard.GetCheckpoint(),
+		parentShardID:         shard.ParentShardId,
+		adjacentParentShardID: shard.AdjacentParentShardId,
+		sticky:                current.sticky,
+		stickyWorker:          current.stickyWorker,
+		stickyGroup:           current.stickyGroup,
+		stickyExpiry:          current.stickyExpiry,
+		pendingCheckpoint:     current.pendingCheckpoint,
+		rewindRequest:         current.rewindRequest,
+	}
+	if lease.checkpoint != "" {
+		lease.subSequenceNumber = copySubSequenceNumber(shard.GetCheckpointSubSequenceNumber())
+	}
+
+	// Keep draining while the owner renews its lease, a new owner starts afresh
+	if current.draining == newAssignTo {
+		lease.draining = current.draining
+	}
+	checkpointer.leases[shard.ID] = lease
+
+	shard.Mux.Lock()
+	shard.AssignedTo = newAssignTo
+	shard.LeaseTimeout = newLeaseTimeout
+	shard.Mux.Unlock()
+
+	return nil
+}
+
+// CheckpointSequence writes a checkpoint at the designated sequence ID. Like DynamoCheckpoint, the write is fenced by
+// the lease and returns ErrLeaseLost if the shard's owner no longer holds it.
+func (checkpointer *MemoryCheckpoint) CheckpointSequence(shard *par.ShardStatus) error {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	owner := shard.GetLeaseOwner()
+	lease, ok := checkpointer.leases[shard.ID]
+	if !ok || lease.owner != owner {
+		return leaseLostError(shard.ID, owner)
+	}
+
+	// A checkpoint also commits the rewind the consumer started from, but never a rewind requested since
+	if lease.rewindRequest != "" && lease.rewindRequest != shard.GetRewindRequest() {
+		return rewindRequestedError(shard.ID)
+	}
+
+	lease.checkpoint = shard.GetCheckpoint()
+	lease.subSequenceNumber = copySubSequenceNumber(shard.GetCheckpointSubSequenceNumber())
+	if len(shard.ParentShardId) > 0 {
+		lease.parentShardID = shard.ParentShardId
+	}
+	if len(shard.AdjacentParentShardId) > 0 {
+		lease.adjacentParentShardID = shard.AdjacentParentShardId
+	}
+
+	// A checkpoint supersedes any pending claim on the shard and commits or discards a prepared checkpoint
+	lease.claimRequest = ""
+	lease.pendingCheckpoint = ""
+	lease.rewindRequest = ""
+	return nil
+}
+
+// FetchCheckpoint retrieves the checkpoint for the given shard
+func (checkpointer *MemoryCheckpoint) FetchCheckpoint(shard *par.ShardStatus) error {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	lease, ok := checkpointer.leases[shard.ID]
+	if !ok {
+		shard.SetRewindRequest("")
+		return ErrSequenceIDNotFound
+	}
+
+	// a rewound shard may have no checkpoint until its consumer checkpoints again
+	shard.SetRewindRequest(lease.rewindRequest)
+	if lease.checkpoint == "" {
+		return ErrSequenceIDNotFound
+	}
+
+	shard.SetCheckpoint(lease.checkpoint)
+	shard.SetCheckpointSubSequenceNumber(copySubSequenceNumber(lease.subSequenceNumber))
+	if lease.owner != "" {
+		shard.SetLeaseOwner(lease.owner)
+	}
+	if !lease.leaseTimeout.IsZero() {
+		shard.SetLeaseTimeout(lease.leaseTimeout)
+	}
+	lease.readStickyColumns(shard)
+	shard.SetClaimRequest(lease.claimRequest)
+	shard.SetDraining(lease.draining)
+	shard.SetPendingCheckpoint(lease.pendingCheckpoint)
+	return nil
+}
+
+// RemoveLeaseInfo removes the lease of a shard which no longer exists
+func (checkpointer *MemoryCheckpoint) RemoveLeaseInfo(shardID string) error {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	delete(checkpointer.leases, shardID)
+	checkpointer.log.Infof("Lease info for shard: %s has been removed.", shardID)
+	return nil
+}
+
+// RemoveLeaseOwner removes the lease owner of a shard held by this worker, making the shard available for reassignment
+func (checkpointer *MemoryCheckpoint) RemoveLeaseOwner(shardID string) error {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	workerID := checkpointer.kclConfig.WorkerID
+	lease, ok := checkpointer.leases[shardID]
+	if !ok || lease.owner != workerID {
+		return ErrLeaseNotAcquired{"lease is not held by " + workerID}
+	}
+	lease.owner = ""
+	return nil
+}
+
+// GetLeaseOwner returns the current lease owner of the shard
+func (checkpointer *MemoryCheckpoint) GetLeaseOwner(shardID string) (string, error) {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	lease, ok := checkpointer.leases[shardID]
+	if !ok || lease.owner == "" {
+		return "", NoLeaseOwnerErr
+	}
+	return lease.owner, nil
+}
+
+// ListActiveWorkers returns a map of workers and their shards. The owner and checkpoint of the shards are refreshed
+// from their lease.
+func (checkpointer *MemoryCheckpoint) ListActiveWorkers(shardStatus map[string]*par.ShardStatus) (map[string][]*par.ShardStatus, error) {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	workers := map[string][]*par.ShardStatus{}
+	for _, shard := range shardStatus {
+		if lease, ok := checkpointer.leases[shard.ID]; ok && lease.owner != "" && lease.checkpoint != "" {
+			shard.SetLeaseOwner(lease.owner)
+			shard.SetCheckpoint(lease.checkpoint)
+			shard = lease.shardStatus(shard.ID)
+		}
+
+		if shard.GetCheckpoint() == ShardEnd {
+			continue
+		}
+
+		leaseOwner := shard.GetLeaseOwner()
+		if leaseOwner == "" {
+			checkpointer.log.Debugf("Shard Not Assigned Error. ShardID: %s, WorkerID: %s", shard.ID, checkpointer.kclConfig.WorkerID)
+			return nil, ErrShardNotAssigned
+		}
+		workers[leaseOwner] = append(workers[leaseOwner], shard)
+	}
+	return workers, nil
+}
+
+// ClaimShard places a claim request on a shard to signal a steal attempt. A shard has at most one claim request.
+func (checkpointer *MemoryCheckpoint) ClaimShard(shard *par.ShardStatus, claimID string) error {
+	if err := checkpointer.FetchCheckpoint(shard); err != nil && err != ErrSequenceIDNotFound {
+		return err
+	}
+
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	lease, ok := checkpointer.leases[shard.ID]
+	if !ok {
+		lease = &memoryLease{sticky: int(par.StickyUnset), parentShardID: shard.ParentShardId,
+			adjacentParentShardID: shard.AdjacentParentShardId}
+		checkpointer.leases[shard.ID] = lease
+	}
+	if lease.claimRequest != "" {
+		return ErrLeaseNotAcquired{"shard " + shard.ID + " is already claimed by " + lease.claimRequest}
+	}
+
+	lease.claimRequest = claimID
+	shard.SetClaimRequest(claimID)
+	return nil
+}
+
+// MarkLeaseDraining flags the lease held by this worker as waiting for another worker to claim it
+func (checkpointer *MemoryCheckpoint) MarkLeaseDraining(shard *par.ShardStatus) error {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	workerID := checkpointer.kclConfig.WorkerID
+	lease, ok := checkpointer.leases[shard.ID]
+	if !ok || lease.owner != workerID {
+		return ErrLeaseNotAcquired{"lease is not held by " + workerID}
+	}
+
+	lease.draining = workerID
+	shard.SetDraining(workerID)
+	checkpointer.log.Infof("Lease on shard %s is waiting to be claimed by another worker", shard.ID)
+	return nil
+}
+
+// PrepareCheckpoint stores the pending checkpoint of a lease held by the shard's owner
+func (checkpointer *MemoryCheckpoint) PrepareCheckpoint(shard *par.ShardStatus) error {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	owner := shard.GetLeaseOwner()
+	lease, ok := checkpointer.leases[shard.ID]
+	if !ok || lease.owner != owner {
+		return leaseLostError(shard.ID, owner)
+	}
+	lease.pendingCheckpoint = shard.GetPendingCheckpoint()
+	return nil
+}
+
+// PinShard pins the shard to the given worker, or to its current owner if workerID is empty
+func (checkpointer *MemoryCheckpoint) PinShard(shardID, workerID string) error {
+	return checkpointer.PinShardUntil(shardID, workerID, time.Time{})
+}
+
+// PinShardUntil pins the shard like PinShard until the given expiry, a zero expiry never expires
+func (checkpointer *MemoryCheckpoint) PinShardUntil(shardID, workerID string, expiry time.Time) error {
+	return checkpointer.updateSticky(shardID, func(lease *memoryLease) {
+		lease.sticky = int(par.StickyPinned)
+		lease.stickyWorker = strings.TrimSpace(workerID)
+		lease.stickyGroup = ""
+		lease.stickyExpiry = time.Time{}
+		if !expiry.IsZero() {
+			lease.stickyExpiry = expiry.UTC()
+		}
+	})
+}
+
+// PinShardToGroup pins the shard to any worker whose labels match the selector
+func (checkpointer *MemoryCheckpoint) PinShardToGroup(shardID, selector string) error {
+	labelSelector, err := config.ParseLabelSelector(selector)
+	if err != nil {
+		return err
+	}
+	if len(labelSelector) == 0 {
+		return fmt.Errorf("%w: empty selector", config.ErrInvalidLabelSelector)
+	}
+
+	return checkpointer.updateSticky(shardID, func(lease *memoryLease) {
+		lease.sticky = int(par.StickyPinned)
+		lease.stickyGroup = labelSelector.String()
+		lease.stickyWorker = ""
+		lease.stickyExpiry = time.Time{}
+	})
+}
+
+// UnpinShard sends the shard back to normal assignment
+func (checkpointer *MemoryCheckpoint) UnpinShard(shardID string) error {
+	return checkpointer.updateSticky(shardID, func(lease *memoryLease) {
+		lease.sticky = int(par.StickyUnset)
+		lease.stickyWorker = ""
+		lease.stickyGroup = ""
+		lease.stickyExpiry = time.Time{}
+	})
+}
+
+// RequestShardRelease asks the worker holding the shard to gracefully release it
+func (checkpointer *MemoryCheckpoint) RequestShardRelease(shardID string) error {
+	return checkpointer.updateSticky(shardID, func(lease *memoryLease) {
+		lease.sticky = int(par.StickyRelease)
+	})
+}
+
+// ListPinnedShards returns the shards currently pinned, including pins whose expiry has passed
+func (checkpointer *MemoryCheckpoint) ListPinnedShards() ([]*par.ShardStatus, error) {
+	return checkpointer.listLeases(func(lease *memoryLease) bool { return lease.sticky == int(par.StickyPinned) }), nil
+}
+
+// ListLeases returns the leases of all shards sorted by shard ID
+func (checkpointer *MemoryCheckpoint) ListLeases() ([]*par.ShardStatus, error) {
+	return checkpointer.listLeases(func(*memoryLease) bool { return true }), nil
+}
+
+// ListLeasesByOwner returns the leases held by the worker sorted by shard ID
+func (checkpointer *MemoryCheckpoint) ListLeasesByOwner(workerID string) ([]*par.ShardStatus, error) {
+	return checkpointer.listLeases(func(lease *memoryLease) bool { return lease.owner == workerID }), nil
+}
+
+// listLeases returns copies of the leases matching the filter sorted by shard ID
+func (checkpointer *MemoryCheckpoint) listLeases(filter func(*memoryLease) bool) []*par.ShardStatus {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	var leases []*par.ShardStatus
+	for shardID, lease := range checkpointer.leases {
+		if filter(lease) {
+			leases = append(leases, lease.shardStatus(shardID))
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].ID < leases[j].ID })
+	return leases
+}
+
+// updateSticky applies the update to the sticky columns of an existing lease
+func (checkpointer *MemoryCheckpoint) updateSticky(shardID string, update func(*memoryLease)) error {
+	if strings.TrimSpace(shardID) == "" {
+		return ErrInvalidShardID
+	}
+
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+
+	lease, ok := checkpointer.leases[shardID]
+	if !ok {
+		return ErrShardNotFound
+	}
+	update(lease)
+	return nil
+}
+
+// readStickyColumns copies the sticky columns of the lease into the shard status
+func (lease *memoryLease) readStickyColumns(shard *par.ShardStatus) {
+	shard.SetSticky(lease.sticky)
+	shard.SetStickyWorker(lease.stickyWorker)
+	shard.SetStickyGroup(lease.stickyGroup)
+	shard.SetStickyExpiry(lease.stickyExpiry)
+}
+
+// shardStatus returns a copy of the lease
+func (lease *memoryLease) shardStatus(shardID string) *par.ShardStatus {
+	return &par.ShardStatus{
+		ID:                          shardID,
+		ParentShardId:               lease.parentShardID,
+		AdjacentParentShardId:       lease.adjacentParentShardID,
+		Checkpoint:                  lease.checkpoint,
+		AssignedTo:                  lease.owner,
+		LeaseTimeout:                lease.leaseTimeout,
+		ClaimRequest:                lease.claimRequest,
+		Sticky:                      lease.sticky,
+		StickyWorker:                lease.stickyWorker,
+		StickyGroup:                 lease.stickyGroup,
+		StickyExpiry:                lease.stickyExpiry,
+		Draining:                    lease.draining,
+		PendingCheckpoint:           lease.pendingCheckpoint,
+		CheckpointSubSequenceNumber: copySubSequenceNumber(lease.subSequenceNumber),
+		RewindRequest:               lease.rewindRequest,
+		Mux:                         &sync.RWMutex{},
+	}
+}
+
+func copySubSequenceNumber(subSequenceNumber *int64) *int64 {
+	if subSequenceNumber == nil {
+		return nil
+	}
+	value := *subSequenceNumber
+	return &value
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func newMemoryShard(shardID string) *par.ShardStatus {
+	return &par.ShardStatus{ID: shardID, Sticky: int(par.StickyUnset), Mux: &sync.RWMutex{}}
+}
+
+func TestMemoryCheckpointInterfaces(t *testing.T) {
+	checkpointer := NewMemoryCheckpoint(config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"))
+
+	var _ Checkpointer = checkpointer
+	var _ StickyAdmin = checkpointer
+	var _ LeaseDrainer = checkpointer
+	var _ PendingCheckpointer = checkpointer
+	var _ LeaseLister = checkpointer
+	var _ LeaseOwnerLister = checkpointer
+	assert.Nil(t, checkpointer.Init())
+}
+
+func TestMemoryCheckpointLease(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewMemoryCheckpoint(kclConfig)
+
+	shard := newMemoryShard("0001")
+	assert.Equal(t, ErrSequenceIDNotFound, checkpointer.FetchCheckpoint(shard))
+
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, "abc", shard.GetLeaseOwner())
+	owner, err := checkpointer.GetLeaseOwner("0001")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", owner)
+
+	// the lease of another worker isn't acquired before it expires
+	other := newMemoryShard("0001")
+	err = checkpointer.GetLease(other, "def")
+	assert.True(t, errors.As(err, &ErrLeaseNotAcquired{}))
+
+	shard.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.Nil(t, checkpointer.FetchCheckpoint(other))
+	assert.Equal(t, "100", other.GetCheckpoint())
+	assert.Equal(t, "abc", other.GetLeaseOwner())
+
+	// an expired lease is taken over and the previous owner can't checkpoint anymore
+	checkpointer.LeaseDuration = 0
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Nil(t, checkpointer.GetLease(other, "def"))
+	shard.SetCheckpoint("200")
+	assert.ErrorIs(t, checkpointer.CheckpointSequence(shard), ErrLeaseLost)
+
+	// the worker releases its lease
+	_, err = checkpointer.GetLeaseOwner("0002")
+	assert.Equal(t, NoLeaseOwnerErr, err)
+	assert.True(t, errors.As(checkpointer.RemoveLeaseOwner("0001"), &ErrLeaseNotAcquired{}))
+	kclConfig.WorkerID = "def"
+	assert.Nil(t, checkpointer.RemoveLeaseOwner("0001"))
+	_, err = checkpointer.GetLeaseOwner("0001")
+	assert.Equal(t, NoLeaseOwnerErr, err)
+
+	assert.Nil(t, checkpointer.RemoveLeaseInfo("0001"))
+	assert.Equal(t, ErrSequenceIDNotFound, checkpointer.FetchCheckpoint(newMemoryShard("0001")))
+}
+
+func TestMemoryCheckpointClaim(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseStealing(true)
+	checkpointer := NewMemoryCheckpoint(kclConfig)
+
+	shard := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	shard.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	claimed := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.ClaimShard(claimed, "def"))
+	assert.Equal(t, "def", claimed.GetClaimRequest())
+	assert.True(t, errors.As(checkpointer.ClaimShard(newMemoryShard("0001"), "ghi"), &ErrLeaseNotAcquired{}))
+
+	// the owner doesn't renew a claimed lease
+	assert.EqualError(t, checkpointer.GetLease(shard, "abc"), ErrShardClaimed)
+
+	// the claimant acquires the lease once it expired, which drops the claim
+	assert.True(t, errors.As(checkpointer.GetLease(claimed, "def"), &ErrLeaseNotAcquired{}))
+	checkpointer.leases["0001"].leaseTimeout = time.Now().Add(-time.Second).UTC()
+	assert.Nil(t, checkpointer.GetLease(claimed, "def"))
+	assert.Nil(t, checkpointer.FetchCheckpoint(claimed))
+	assert.Equal(t, "", claimed.GetClaimRequest())
+	assert.Equal(t, "100", claimed.GetCheckpoint())
+}
+
+func TestMemoryCheckpointSticky(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewMemoryCheckpoint(kclConfig)
+
+	assert.Equal(t, ErrShardNotFound, checkpointer.PinShard("0001", "abc"))
+	assert.Equal(t, ErrInvalidShardID, checkpointer.PinShard(" ", "abc"))
+
+	shard := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Nil(t, checkpointer.PinShard("0001", "def"))
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, par.StickyPinned, shard.GetStickyState())
+	assert.Equal(t, "def", shard.GetStickyWorker())
+
+	pinned, err := checkpointer.ListPinnedShards()
+	assert.Nil(t, err)
+	assert.Len(t, pinned, 1)
+	assert.Equal(t, "abc", pinned[0].GetLeaseOwner())
+
+	// the pinned worker claims the shard back, even without lease stealing
+	claimed := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.ClaimShard(claimed, "def"))
+	assert.EqualError(t, checkpointer.GetLease(shard, "abc"), ErrShardClaimed)
+
+	// a pin replaces the worker group and expiry of the previous one
+	assert.Nil(t, checkpointer.PinShardToGroup("0001", "zone=us-west-2a"))
+	assert.Nil(t, checkpointer.PinShardUntil("0001", "def", time.Now().Add(time.Hour)))
+	pinned, err = checkpointer.ListPinnedShards()
+	assert.Nil(t, err)
+	assert.Empty(t, pinned[0].GetStickyGroup())
+	assert.Nil(t, checkpointer.PinShardToGroup("0001", "zone=us-west-2a"))
+	pinned, err = checkpointer.ListPinnedShards()
+	assert.Nil(t, err)
+	assert.True(t, pinned[0].GetStickyExpiry().IsZero())
+	assert.Empty(t, pinned[0].GetStickyWorker())
+
+	assert.Nil(t, checkpointer.UnpinShard("0001"))
+	pinned, err = checkpointer.ListPinnedShards()
+	assert.Nil(t, err)
+	assert.Empty(t, pinned)
+}
+
+func TestMemoryCheckpointListActiveWorkers(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewMemoryCheckpoint(kclConfig)
+
+	shardStatus := map[string]*par.ShardStatus{}
+	for i, owner := range []string{"abc", "def", "abc"} {
+		shard := newMemoryShard(fmt.Sprintf("%04d", i))
+		shardStatus[shard.ID] = newMemoryShard(shard.ID)
+		assert.Nil(t, checkpointer.GetLease(shard, owner))
+		shard.SetCheckpoint("100")
+		assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	}
+
+	workers, err := checkpointer.ListActiveWorkers(shardStatus)
+	assert.Nil(t, err)
+	assert.Len(t, workers["abc"], 2)
+	assert.Len(t, workers["def"], 1)
+	assert.Equal(t, "def", shardStatus["0001"].GetLeaseOwner())
+
+	leases, err := checkpointer.ListLeasesByOwner("abc")
+	assert.Nil(t, err)
+	assert.Equal(t, "0000", leases[0].ID)
+	assert.Equal(t, "0002", leases[1].ID)
+
+	shardStatus["0003"] = newMemoryShard("0003")
+	_, err = checkpointer.ListActiveWorkers(shardStatus)
+	assert.Equal(t, ErrShardNotAssigned, err)
+}
+
+func TestMemoryCheckpointConcurrentAcquisition(t *testing.T) {
+	checkpointer := NewMemoryCheckpoint(config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"))
+
+	var wg sync.WaitGroup
+	acquired := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			if checkpointer.GetLease(newMemoryShard("0001"), workerID) == nil {
+				acquired <- workerID
+			}
+		}(fmt.Sprintf("worker-%d", i))
+	}
+	wg.Wait()
+	close(acquired)
+
+	var owners []string
+	for owner := range acquired {
+		owners = append(owners, owner)
+	}
+	assert.Len(t, owners, 1)
+}
+
+func TestMemoryCheckpointSnapshot(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	source := NewMemoryCheckpoint(kclConfig)
+	shard := newMemoryShard("0001")
+	assert.Nil(t, source.GetLease(shard, "abc"))
+	shard.SetCheckpoint("100")
+	assert.Nil(t, source.CheckpointSequence(shard))
+
+	snapshot, err := ExportSnapshot(source)
+	assert.Nil(t, err)
+
+	target := NewMemoryCheckpoint(kclConfig)
+	assert.Nil(t, ImportSnapshot(target, snapshot, ImportOptions{WorkerID: "abc", StripOwnership: true}))
+	leases, err := target.ListLeases()
+	assert.Nil(t, err)
+	assert.Len(t, leases, 1)
+	assert.Equal(t, "100", leases[0].GetCheckpoint())
+	assert.Equal(t, "", leases[0].GetLeaseOwner())
+}
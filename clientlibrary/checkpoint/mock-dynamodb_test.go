@@ -30,6 +30,8 @@ package checkpoint
 
 import (
 	"context"
+	"strings"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -41,10 +43,47 @@ type mockDynamoDB struct {
 	item                      map[string]types.AttributeValue
 	conditionalExpression     string
 	expressionAttributeValues map[string]types.AttributeValue
+
+	// evalConditions makes UpdateItem evaluate its ConditionExpression against item
+	evalConditions  bool
+	updateItemInput *dynamodb.UpdateItemInput
+	putItemInput    *dynamodb.PutItemInput
+	scanItems       []map[string]types.AttributeValue
+
+	// tableStatuses are returned by the next calls of DescribeTable, ACTIVE afterwards
+	tableStatuses    []types.TableStatus
+	tableKeySchema   []types.KeySchemaElement
+	createTableInput *dynamodb.CreateTableInput
+	backupsInput     *dynamodb.UpdateContinuousBackupsInput
+
+	tableIndexes     []types.GlobalSecondaryIndexDescription
+	updateTableInput *dynamodb.UpdateTableInput
+	queryInputs      []*dynamodb.QueryInput
 }
 
 func (m *mockDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
-	return &dynamodb.ScanOutput{}, nil
+	var items []map[string]types.AttributeValue
+	for _, item := range m.scanItems {
+		if params.FilterExpression == nil ||
+			evalCondition(item, aws.ToString(params.FilterExpression), params.ExpressionAttributeValues) {
+			items = append(items, item)
+		}
+	}
+
+	return &dynamodb.ScanOutput{Items: items, Count: int32(len(items))}, nil
+}
+
+func (m *mockDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	m.queryInputs = append(m.queryInputs, params)
+
+	var items []map[string]types.AttributeValue
+	for _, item := range m.scanItems {
+		if evalCondition(item, aws.ToString(params.KeyConditionExpression), params.ExpressionAttributeValues) {
+			items = append(items, item)
+		}
+	}
+
+	return &dynamodb.QueryOutput{Items: items, Count: int32(len(items))}, nil
 }
 
 func (m *mockDynamoDB) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
@@ -52,14 +91,59 @@ func (m *mockDynamoDB) DescribeTable(ctx context.Context, params *dynamodb.Descr
 		return &dynamodb.DescribeTableOutput{}, &types.ResourceNotFoundException{Message: aws.String("doesNotExist")}
 	}
 
-	return &dynamodb.DescribeTableOutput{}, nil
+	status := types.TableStatusActive
+	if len(m.tableStatuses) > 0 {
+		status, m.tableStatuses = m.tableStatuses[0], m.tableStatuses[1:]
+	}
+	keySchema := m.tableKeySchema
+	if keySchema == nil {
+		keySchema = []types.KeySchemaElement{{AttributeName: aws.String(LeaseKeyKey), KeyType: types.KeyTypeHash}}
+	}
+
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{
+		TableName:              params.TableName,
+		TableStatus:            status,
+		KeySchema:              keySchema,
+		GlobalSecondaryIndexes: m.tableIndexes,
+	}}, nil
 }
 
 func (m *mockDynamoDB) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	m.createTableInput = params
+	m.tableExist = true
+	m.tableKeySchema = params.KeySchema
+	for _, index := range params.GlobalSecondaryIndexes {
+		m.tableIndexes = append(m.tableIndexes, types.GlobalSecondaryIndexDescription{
+			IndexName:   index.IndexName,
+			KeySchema:   index.KeySchema,
+			IndexStatus: types.IndexStatusActive,
+		})
+	}
 	return &dynamodb.CreateTableOutput{}, nil
 }
 
+func (m *mockDynamoDB) UpdateTable(ctx context.Context, params *dynamodb.UpdateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error) {
+	m.updateTableInput = params
+	for _, update := range params.GlobalSecondaryIndexUpdates {
+		if update.Create != nil {
+			m.tableIndexes = append(m.tableIndexes, types.GlobalSecondaryIndexDescription{
+				IndexName:   update.Create.IndexName,
+				KeySchema:   update.Create.KeySchema,
+				IndexStatus: types.IndexStatusCreating,
+				Backfilling: aws.Bool(true),
+			})
+		}
+	}
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+func (m *mockDynamoDB) UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error) {
+	m.backupsInput = params
+	return &dynamodb.UpdateContinuousBackupsOutput{}, nil
+}
+
 func (m *mockDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.putItemInput = params
 	item := params.Item
 
 	if shardID, ok := item[LeaseKeyKey]; ok {
@@ -102,15 +186,104 @@ func (m *mockDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInpu
 }
 
 func (m *mockDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
-	exp := params.UpdateExpression
+	m.updateItemInput = params
 
-	if aws.ToString(exp) == "remove "+LeaseOwnerKey {
-		delete(m.item, LeaseOwnerKey)
+	if m.evalConditions && params.ConditionExpression != nil &&
+		!evalCondition(m.item, aws.ToString(params.ConditionExpression), params.ExpressionAttributeValues) {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
 	}
 
-	return nil, nil
+	var previous map[string]types.AttributeValue
+	if params.ReturnValues == types.ReturnValueAllOld {
+		previous = make(map[string]types.AttributeValue, len(m.item))
+		for key, value := range m.item {
+			previous[key] = value
+		}
+	}
+
+	applyUpdate(m.item, aws.ToString(params.UpdateExpression), params.ExpressionAttributeValues)
+
+	return &dynamodb.UpdateItemOutput{Attributes: previous}, nil
 }
 
 func (m *mockDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
 	return &dynamodb.DeleteItemOutput{}, nil
 }
+
+// evalCondition evaluates the small subset of the DynamoDB condition syntax used by the checkpointer:
+// clauses joined by AND, each being attribute_exists(a), attribute_not_exists(a), a = :v, a <> :v or a
+// parenthesized list of such clauses joined by OR.
+func evalCondition(item map[string]types.AttributeValue, expression string, values map[string]types.AttributeValue) bool {
+	for _, clause := range strings.Split(expression, " AND ") {
+		clause = strings.TrimSpace(clause)
+		switch {
+		case strings.HasPrefix(clause, "(") && strings.Contains(clause, " OR "):
+			alternatives := strings.Split(strings.TrimSuffix(strings.TrimPrefix(clause, "("), ")"), " OR ")
+			matched := false
+			for _, alternative := range alternatives {
+				matched = matched || evalCondition(item, alternative, values)
+			}
+			if !matched {
+				return false
+			}
+		case strings.HasPrefix(clause, "attribute_exists("):
+			if _, ok := item[strings.TrimSuffix(strings.TrimPrefix(clause, "attribute_exists("), ")")]; !ok {
+				return false
+			}
+		case strings.HasPrefix(clause, "attribute_not_exists("):
+			if _, ok := item[strings.TrimSuffix(strings.TrimPrefix(clause, "attribute_not_exists("), ")")]; ok {
+				return false
+			}
+		case strings.Contains(clause, " <> "):
+			parts := strings.SplitN(clause, " <> ", 2)
+			if attributeString(item[parts[0]]) == attributeString(values[parts[1]]) {
+				return false
+			}
+		case strings.Contains(clause, " = "):
+			parts := strings.SplitN(clause, " = ", 2)
+			if _, ok := item[parts[0]]; !ok || attributeString(item[parts[0]]) != attributeString(values[parts[1]]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyUpdate applies an update expression of the form "SET a = :v, b = :w REMOVE c, d" to item.
+func applyUpdate(item map[string]types.AttributeValue, expression string, values map[string]types.AttributeValue) {
+	var action string
+	for _, token := range strings.Fields(strings.ReplaceAll(expression, ",", " , ")) {
+		switch strings.ToUpper(token) {
+		case "SET", "REMOVE":
+			action = strings.ToUpper(token)
+			continue
+		}
+		if action == "REMOVE" && token != "," {
+			delete(item, token)
+		}
+	}
+
+	for _, section := range strings.Split(expression, "REMOVE") {
+		section = strings.TrimSpace(section)
+		if !strings.HasPrefix(strings.ToUpper(section), "SET ") {
+			continue
+		}
+		for _, assignment := range strings.Split(section[4:], ",") {
+			parts := strings.SplitN(assignment, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			item[strings.TrimSpace(parts[0])] = values[strings.TrimSpace(parts[1])]
+		}
+	}
+}
+
+func attributeString(value types.AttributeValue) string {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return v.Value
+	}
+	return ""
+}
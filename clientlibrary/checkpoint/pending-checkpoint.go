@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// PendingCheckpointer is implemented by checkpointers that persist two-phase checkpoints.
+// The pending checkpoint is stored next to the checkpoint of the lease, surfaced to the next owner
+// of the shard and committed or discarded by the next CheckpointSequence.
+type PendingCheckpointer interface {
+	// PrepareCheckpoint stores the pending checkpoint of a lease held by the shard's owner
+	PrepareCheckpoint(shard *par.ShardStatus) error
+}
+
+// PrepareCheckpoint sets the PendingCheckpoint column of a lease held by the shard's owner
+func (checkpointer *DynamoCheckpoint) PrepareCheckpoint(shard *par.ShardStatus) error {
+	owner := shard.GetLeaseOwner()
+	err := checkpointer.updateItem(shard.ID, "SET "+PendingCheckpointKey+" = :pending_checkpoint",
+		LeaseOwnerKey+" = :assigned_to",
+		map[string]types.AttributeValue{
+			":pending_checkpoint": &types.AttributeValueMemberS{Value: shard.GetPendingCheckpoint()},
+			":assigned_to":        &types.AttributeValueMemberS{Value: owner},
+		})
+	if err != nil {
+		var conditionalCheckErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionalCheckErr) {
+			return leaseLostError(shard.ID, owner)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// readPendingCheckpoint returns the PendingCheckpoint column of a lease row or an empty string
+func readPendingCheckpoint(item map[string]types.AttributeValue) string {
+	if pending, ok := item[PendingCheckpointKey].(*types.AttributeValueMemberS); ok {
+		return pending.Value
+	}
+	return ""
+}
+
+// writePendingCheckpoint preserves the pending checkpoint of the shard in a lease row
+func writePendingCheckpoint(shard *par.ShardStatus, item map[string]types.AttributeValue) {
+	if pending := shard.GetPendingCheckpoint(); pending != "" {
+		item[PendingCheckpointKey] = &types.AttributeValueMemberS{Value: pending}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestPrepareCheckpoint(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abc"},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+	shard := &par.ShardStatus{ID: "0001", AssignedTo: "abc", Checkpoint: "100", PendingCheckpoint: "200", Mux: &sync.RWMutex{}}
+
+	err := checkpoint.PrepareCheckpoint(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "200", svc.item[PendingCheckpointKey].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, "100", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+
+	// the pending checkpoint is surfaced to the next owner
+	next := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	err = checkpoint.FetchCheckpoint(next)
+	assert.Nil(t, err)
+	assert.Equal(t, "200", next.GetPendingCheckpoint())
+
+	// committing promotes the pending checkpoint in the same update
+	shard.SetCheckpoint("200")
+	err = checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+	assert.Equal(t, "200", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+	_, ok := svc.item[PendingCheckpointKey]
+	assert.False(t, ok)
+
+	// only the lease owner can prepare a checkpoint
+	svc.item[LeaseOwnerKey] = &types.AttributeValueMemberS{Value: "ijkl-mnop"}
+	err = checkpoint.PrepareCheckpoint(shard)
+	assert.ErrorIs(t, err, ErrLeaseLost)
+	assert.True(t, errors.As(err, &kcl.ShutdownError{}))
+}
+
+func TestGetLeasePreservesPendingCheckpoint(t *testing.T) {
+	leaseTimeout := time.Now().Add(-10 * time.Second).UTC()
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:          &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:        &types.AttributeValueMemberS{Value: "abc"},
+			LeaseTimeoutKey:      &types.AttributeValueMemberS{Value: leaseTimeout.Format(time.RFC3339Nano)},
+			SequenceNumberKey:    &types.AttributeValueMemberS{Value: "100"},
+			PendingCheckpointKey: &types.AttributeValueMemberS{Value: "200"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+
+	// the new owner of the lease keeps the pending checkpoint of the previous owner
+	err := checkpoint.GetLease(shard, "ijkl-mnop")
+	assert.Nil(t, err)
+	assert.Equal(t, "200", shard.GetPendingCheckpoint())
+	assert.Equal(t, "200", svc.putItemInput.Item[PendingCheckpointKey].(*types.AttributeValueMemberS).Value)
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Positions a shard can be rewound to
+const (
+	RewindTrimHorizon      = "TRIM_HORIZON"       // The oldest record of the shard
+	RewindAtTimestamp      = "AT_TIMESTAMP"       // The first record at or after a timestamp
+	RewindAtSequenceNumber = "AT_SEQUENCE_NUMBER" // The record with a sequence number, for a single shard
+)
+
+var (
+	// ErrInvalidRewindTarget is returned when a rewind target can't be parsed or doesn't apply
+	ErrInvalidRewindTarget = errors.New("InvalidRewindTarget")
+
+	// ErrShardClosed is returned by RewindShard for a shard that has been processed up to SHARD_END
+	ErrShardClosed = errors.New("ShardClosed")
+)
+
+// RewindTarget is the position a shard is processed again from
+type RewindTarget struct {
+	Position       string    // RewindTrimHorizon, RewindAtTimestamp or RewindAtSequenceNumber
+	Timestamp      time.Time // Used with RewindAtTimestamp
+	SequenceNumber string    // Used with RewindAtSequenceNumber
+}
+
+// LeaseRewinder is implemented by checkpointers that let an operator rewind shards while workers are running.
+// The rewind request is stored in the lease. The owner of the lease gives it up on its next renewal and the next
+// consumer of the shard starts from the rewind target. Its first checkpoint commits the rewind.
+type LeaseRewinder interface {
+	// RewindShard rewinds the shard to the target
+	RewindShard(shardID string, target RewindTarget) error
+
+	// RewindAllShards rewinds every shard of the lease table that isn't closed, and returns the rewound shards
+	RewindAllShards(target RewindTarget) ([]string, error)
+}
+
+// ParseRewindTarget parses TRIM_HORIZON, AT_TIMESTAMP=<RFC3339 timestamp> or AT_SEQUENCE_NUMBER=<sequence number>
+func ParseRewindTarget(target string) (RewindTarget, error) {
+	position, value, _ := strings.Cut(strings.TrimSpace(target), "=")
+
+	var rewindTarget RewindTarget
+	switch strings.ToUpper(position) {
+	case RewindTrimHorizon:
+		rewindTarget = RewindTarget{Position: RewindTrimHorizon}
+	case RewindAtTimestamp:
+		timestamp, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return RewindTarget{}, fmt.Errorf("%w: %s: %v", ErrInvalidRewindTarget, target, err)
+		}
+		rewindTarget = RewindTarget{Position: RewindAtTimestamp, Timestamp: timestamp}
+	case RewindAtSequenceNumber:
+		rewindTarget = RewindTarget{Position: RewindAtSequenceNumber, SequenceNumber: value}
+	default:
+		return RewindTarget{}, fmt.Errorf("%w: %s", ErrInvalidRewindTarget, target)
+	}
+
+	return rewindTarget, rewindTarget.validate()
+}
+
+// ParseRewindRequest returns the target of a rewind request stored in the lease table
+func ParseRewindRequest(rewindRequest string) (RewindTarget, error) {
+	// the request time after the last @ makes every request unique
+	if i := strings.LastIndex(rewindRequest, "@"); i >= 0 {
+		rewindRequest = rewindRequest[:i]
+	}
+	return ParseRewindTarget(rewindRequest)
+}
+
+// String returns the target in the format read by ParseRewindTarget
+func (target RewindTarget) String() string {
+	switch target.Position {
+	case RewindAtTimestamp:
+		return target.Position + "=" + target.Timestamp.UTC().Format(time.RFC3339Nano)
+	case RewindAtSequenceNumber:
+		return target.Position + "=" + target.SequenceNumber
+	default:
+		return target.Position
+	}
+}
+
+func (target RewindTarget) validate() error {
+	switch target.Position {
+	case RewindTrimHorizon:
+		return nil
+	case RewindAtTimestamp:
+		if target.Timestamp.IsZero() {
+			return fmt.Errorf("%w: %s without timestamp", ErrInvalidRewindTarget, target.Position)
+		}
+		return nil
+	case RewindAtSequenceNumber:
+		if _, ok := new(big.Int).SetString(target.SequenceNumber, 10); !ok {
+			return fmt.Errorf("%w: invalid sequence number %q", ErrInvalidRewindTarget, target.SequenceNumber)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown position %q", ErrInvalidRewindTarget, target.Position)
+	}
+}
+
+// RewindShard sets the RewindRequest column of the shard. The update is conditional on the shard being present in
+// the lease table and not closed.
+func (checkpointer *DynamoCheckpoint) RewindShard(shardID string, target RewindTarget) error {
+	if strings.TrimSpace(shardID) == "" {
+		return ErrInvalidShardID
+	}
+	if err := target.validate(); err != nil {
+		return err
+	}
+
+	rewindRequest := target.String() + "@" + time.Now().UTC().Format(time.RFC3339Nano)
+	err := checkpointer.updateItem(shardID, "SET "+RewindRequestKey+" = :rewind_request",
+		"attribute_exists("+LeaseKeyKey+") AND (attribute_not_exists("+SequenceNumberKey+") OR "+SequenceNumberKey+" <> :shard_end)",
+		map[string]types.AttributeValue{
+			":rewind_request": &types.AttributeValueMemberS{Value: rewindRequest},
+			":shard_end":      &types.AttributeValueMemberS{Value: ShardEnd},
+		})
+
+	var conditionalCheckErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckErr) {
+		item, err := checkpointer.getItem(shardID)
+		if err != nil {
+			return err
+		}
+		if len(item) == 0 {
+			return ErrShardNotFound
+		}
+		return ErrShardClosed
+	}
+
+	if err == nil {
+		checkpointer.log.Infof("Rewind of shard %s requested: %s", shardID, rewindRequest)
+	}
+	return err
+}
+
+// RewindAllShards rewinds every shard of the lease table that isn't closed. Sequence numbers belong to a single
+// shard, so the target can't be AT_SEQUENCE_NUMBER.
+func (checkpointer *DynamoCheckpoint) RewindAllShards(target RewindTarget) ([]string, error) {
+	if target.Position == RewindAtSequenceNumber {
+		return nil, fmt.Errorf("%w: %s applies to a single shard", ErrInvalidRewindTarget, target.Position)
+	}
+	if err := target.validate(); err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(checkpointer.TableName),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	var rewound []string
+	for {
+		scanOutput, err := checkpointer.svc.Scan(context.TODO(), input)
+		if err != nil {
+			return rewound, checkpointer.dynamoDBError(err)
+		}
+
+		for _, item := range scanOutput.Items {
+			shardID, ok := item[LeaseKeyKey].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			if checkpoint, ok := item[SequenceNumberKey].(*types.AttributeValueMemberS); ok && checkpoint.Value == ShardEnd {
+				continue
+			}
+
+			if err := checkpointer.RewindShard(shardID.Value, target); err != nil {
+				if errors.Is(err, ErrShardNotFound) || errors.Is(err, ErrShardClosed) {
+					// removed or closed since the scan
+					continue
+				}
+				return rewound, err
+			}
+			rewound = append(rewound, shardID.Value)
+		}
+
+		if len(scanOutput.LastEvaluatedKey) == 0 {
+			return rewound, nil
+		}
+		input.ExclusiveStartKey = scanOutput.LastEvaluatedKey
+	}
+}
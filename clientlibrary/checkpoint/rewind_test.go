@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestParseRewindTarget(t *testing.T) {
+	timestamp := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		target string
+		want   RewindTarget
+		valid  bool
+	}{
+		{"TRIM_HORIZON", RewindTarget{Position: RewindTrimHorizon}, true},
+		{"trim_horizon", RewindTarget{Position: RewindTrimHorizon}, true},
+		{"AT_TIMESTAMP=2024-05-01T12:00:00Z", RewindTarget{Position: RewindAtTimestamp, Timestamp: timestamp}, true},
+		{"AT_SEQUENCE_NUMBER=49590338271490256608559692538361571095921575989136588898", RewindTarget{
+			Position: RewindAtSequenceNumber, SequenceNumber: "49590338271490256608559692538361571095921575989136588898"}, true},
+		{"AT_TIMESTAMP=yesterday", RewindTarget{}, false},
+		{"AT_SEQUENCE_NUMBER=abc", RewindTarget{}, false},
+		{"LATEST", RewindTarget{}, false},
+	}
+
+	for _, tt := range tests {
+		target, err := ParseRewindTarget(tt.target)
+		if !tt.valid {
+			assert.ErrorIs(t, err, ErrInvalidRewindTarget, tt.target)
+			continue
+		}
+		assert.Nil(t, err, tt.target)
+		assert.Equal(t, tt.want, target, tt.target)
+
+		request, err := ParseRewindRequest(target.String() + "@2024-06-01T00:00:00Z")
+		assert.Nil(t, err, tt.target)
+		assert.Equal(t, tt.want, request, tt.target)
+	}
+}
+
+func TestRewindShard(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+		},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	assert.Nil(t, checkpointer.RewindShard("0001", RewindTarget{Position: RewindTrimHorizon}))
+	target, err := ParseRewindRequest(svc.item[RewindRequestKey].(*types.AttributeValueMemberS).Value)
+	assert.Nil(t, err)
+	assert.Equal(t, RewindTrimHorizon, target.Position)
+
+	assert.ErrorIs(t, checkpointer.RewindShard("0001", RewindTarget{Position: RewindAtTimestamp}), ErrInvalidRewindTarget)
+	assert.ErrorIs(t, checkpointer.RewindShard("", RewindTarget{Position: RewindTrimHorizon}), ErrInvalidShardID)
+
+	svc.item[SequenceNumberKey] = &types.AttributeValueMemberS{Value: ShardEnd}
+	assert.ErrorIs(t, checkpointer.RewindShard("0001", RewindTarget{Position: RewindTrimHorizon}), ErrShardClosed)
+
+	svc.item = map[string]types.AttributeValue{}
+	assert.ErrorIs(t, checkpointer.RewindShard("0001", RewindTarget{Position: RewindTrimHorizon}), ErrShardNotFound)
+}
+
+func TestRewindAllShards(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item:       map[string]types.AttributeValue{},
+		scanItems: []map[string]types.AttributeValue{
+			{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0001"}, SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"}},
+			{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0002"}, SequenceNumberKey: &types.AttributeValueMemberS{Value: ShardEnd}},
+			{LeaseKeyKey: &types.AttributeValueMemberS{Value: "0003"}},
+		},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	shards, err := checkpointer.RewindAllShards(RewindTarget{Position: RewindAtTimestamp, Timestamp: time.Now()})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0001", "0003"}, shards)
+
+	_, err = checkpointer.RewindAllShards(RewindTarget{Position: RewindAtSequenceNumber, SequenceNumber: "100"})
+	assert.ErrorIs(t, err, ErrInvalidRewindTarget)
+}
+
+func TestGetLeaseRewindRequested(t *testing.T) {
+	leaseTimeout := time.Now().Add(time.Minute).UTC()
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:     &types.AttributeValueMemberS{Value: "abc"},
+			LeaseTimeoutKey:   &types.AttributeValueMemberS{Value: leaseTimeout.Format(time.RFC3339Nano)},
+			SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+			RewindRequestKey:  &types.AttributeValueMemberS{Value: "TRIM_HORIZON@2024-06-01T00:00:00Z"},
+		},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	shard := &par.ShardStatus{ID: "0001", AssignedTo: "abc", Checkpoint: "100", Mux: &sync.RWMutex{}}
+
+	// the owner gives up a shard rewound since its consumer started
+	assert.ErrorIs(t, checkpointer.GetLease(shard, "abc"), ErrRewindRequested)
+
+	// and renews the lease of a shard which started from the rewind, keeping the request until the next checkpoint
+	assert.Nil(t, checkpointer.FetchCheckpoint(shard))
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, svc.item[RewindRequestKey], svc.putItemInput.Item[RewindRequestKey])
+}
+
+func TestCheckpointSequenceRewindFence(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:      &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:    &types.AttributeValueMemberS{Value: "abc"},
+			RewindRequestKey: &types.AttributeValueMemberS{Value: "TRIM_HORIZON@2024-06-01T00:00:00Z"},
+		},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	shard := &par.ShardStatus{ID: "0001", AssignedTo: "abc", Checkpoint: "300", Mux: &sync.RWMutex{}}
+
+	// a consumer which didn't start from the rewind can't checkpoint over it
+	assert.ErrorIs(t, checkpointer.CheckpointSequence(shard), ErrRewindRequested)
+	assert.NotContains(t, svc.item, SequenceNumberKey)
+
+	// the first checkpoint of the rewound consumer commits the rewind
+	shard.SetRewindRequest("TRIM_HORIZON@2024-06-01T00:00:00Z")
+	shard.SetCheckpoint("5")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.NotContains(t, svc.item, RewindRequestKey)
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	// a lease held by another worker is lost
+	svc.item[LeaseOwnerKey] = &types.AttributeValueMemberS{Value: "other"}
+	assert.ErrorIs(t, checkpointer.CheckpointSequence(shard), ErrLeaseLost)
+}
+
+func TestFetchCheckpointRewoundWithoutCheckpoint(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:      &types.AttributeValueMemberS{Value: "0001"},
+			RewindRequestKey: &types.AttributeValueMemberS{Value: "TRIM_HORIZON@2024-06-01T00:00:00Z"},
+		},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+
+	assert.ErrorIs(t, NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).FetchCheckpoint(shard), ErrSequenceIDNotFound)
+	assert.Equal(t, "TRIM_HORIZON@2024-06-01T00:00:00Z", shard.GetRewindRequest())
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// SnapshotVersion is the version of the snapshots written by ExportSnapshot
+const SnapshotVersion = 1
+
+// ErrUnsupportedSnapshotVersion is returned by ReadSnapshot for snapshots written by a newer version of the library
+var ErrUnsupportedSnapshotVersion = errors.New("UnsupportedSnapshotVersion")
+
+// LeaseLister is implemented by checkpointers that can list every lease of the lease table
+type LeaseLister interface {
+	// ListLeases returns the leases of all shards
+	ListLeases() ([]*par.ShardStatus, error)
+}
+
+// Snapshot is a versioned copy of the checkpoints of a lease table, to move them to another table or application
+type Snapshot struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Leases    []SnapshotLease `json:"leases"`
+}
+
+// SnapshotLease is the lease of a shard in a Snapshot
+type SnapshotLease struct {
+	ShardID               string     `json:"shardId"`
+	Checkpoint            string     `json:"checkpoint,omitempty"`
+	SubSequenceNumber     *int64     `json:"subSequenceNumber,omitempty"`
+	ParentShardID         string     `json:"parentShardId,omitempty"`
+	AdjacentParentShardID string     `json:"adjacentParentShardId,omitempty"`
+	Owner                 string     `json:"owner,omitempty"`
+	Sticky                int        `json:"sticky,omitempty"` // Only pins (Sticky=10) are part of a snapshot
+	StickyWorker          string     `json:"stickyWorker,omitempty"`
+	StickyGroup           string     `json:"stickyGroup,omitempty"`
+	StickyExpiry          *time.Time `json:"stickyExpiry,omitempty"`
+}
+
+// ImportOptions tells ImportSnapshot how to write the leases
+type ImportOptions struct {
+	// WorkerID takes the leases while they are imported. It must be the worker ID the checkpointer was
+	// configured with, which RemoveLeaseOwner releases leases for.
+	WorkerID string
+
+	// StripOwnership imports the leases without owner, so any worker of the target fleet can take them.
+	// Otherwise the leases are taken for their owners in the snapshot.
+	StripOwnership bool
+}
+
+// ExportSnapshot copies the checkpoints of all leases into a snapshot
+func ExportSnapshot(lister LeaseLister) (*Snapshot, error) {
+	shards, err := lister.ListLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{Version: SnapshotVersion, CreatedAt: time.Now().UTC(), Leases: []SnapshotLease{}}
+	for _, shard := range shards {
+		lease := SnapshotLease{
+			ShardID:               shard.ID,
+			Checkpoint:            shard.GetCheckpoint(),
+			SubSequenceNumber:     shard.GetCheckpointSubSequenceNumber(),
+			ParentShardID:         shard.ParentShardId,
+			AdjacentParentShardID: shard.AdjacentParentShardId,
+			Owner:                 shard.GetLeaseOwner(),
+		}
+		if shard.GetSticky() == int(par.StickyPinned) {
+			lease.Sticky = shard.GetSticky()
+			lease.StickyWorker = shard.GetStickyWorker()
+			lease.StickyGroup = shard.GetStickyGroup()
+			if expiry := shard.GetStickyExpiry(); !expiry.IsZero() {
+				lease.StickyExpiry = &expiry
+			}
+		}
+		snapshot.Leases = append(snapshot.Leases, lease)
+	}
+
+	sort.Slice(snapshot.Leases, func(i, j int) bool {
+		return snapshot.Leases[i].ShardID < snapshot.Leases[j].ShardID
+	})
+	return snapshot, nil
+}
+
+// ImportSnapshot writes the leases of the snapshot through the checkpointer, overwriting the checkpoints of shards
+// already in its lease table. Import into a lease table no worker is using: a lease held by a live worker can't be
+// taken and is reported in the returned error. Pins are imported through StickyAdmin.
+func ImportSnapshot(checkpointer Checkpointer, snapshot *Snapshot, options ImportOptions) error {
+	var errs []error
+	for _, lease := range snapshot.Leases {
+		if err := importLease(checkpointer, lease, options); err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", lease.ShardID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func importLease(checkpointer Checkpointer, lease SnapshotLease, options ImportOptions) error {
+	shard := &par.ShardStatus{
+		ID:                          lease.ShardID,
+		ParentShardId:               lease.ParentShardID,
+		AdjacentParentShardId:       lease.AdjacentParentShardID,
+		Checkpoint:                  lease.Checkpoint,
+		CheckpointSubSequenceNumber: lease.SubSequenceNumber,
+		Sticky:                      int(par.StickyUnset),
+		Mux:                         &sync.RWMutex{},
+	}
+
+	owner := lease.Owner
+	if options.StripOwnership || owner == "" {
+		owner = options.WorkerID
+	}
+	if err := checkpointer.GetLease(shard, owner); err != nil {
+		return err
+	}
+
+	if lease.Checkpoint != "" {
+		if err := checkpointer.CheckpointSequence(shard); err != nil {
+			return err
+		}
+	}
+
+	if lease.Sticky == int(par.StickyPinned) {
+		stickyAdmin, ok := checkpointer.(StickyAdmin)
+		if !ok {
+			return fmt.Errorf("%w: pin of shard", ErrNotSupported)
+		}
+
+		var err error
+		if lease.StickyGroup != "" {
+			err = stickyAdmin.PinShardToGroup(lease.ShardID, lease.StickyGroup)
+		} else {
+			var expiry time.Time
+			if lease.StickyExpiry != nil {
+				expiry = *lease.StickyExpiry
+			}
+			err = stickyAdmin.PinShardUntil(lease.ShardID, lease.StickyWorker, expiry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.StripOwnership || lease.Owner == "" {
+		return checkpointer.RemoveLeaseOwner(lease.ShardID)
+	}
+	return nil
+}
+
+// WriteSnapshot writes the snapshot as an indented JSON document
+func WriteSnapshot(w io.Writer, snapshot *Snapshot) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
+
+// ReadSnapshot reads a JSON document written by WriteSnapshot
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+		return nil, err
+	}
+
+	if snapshot.Version < 1 || snapshot.Version > SnapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSnapshotVersion, snapshot.Version)
+	}
+	return snapshot, nil
+}
+
+// ListLeases scans the lease table
+func (checkpointer *DynamoCheckpoint) ListLeases() ([]*par.ShardStatus, error) {
+	snapshot, err := checkpointer.ScanLeases()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.Leases(), nil
+}
+
+// readLease returns the shard status stored in a lease entry, or nil if the entry has no shard ID
+func readLease(item map[string]types.AttributeValue) *par.ShardStatus {
+	shardID := readString(item, LeaseKeyKey)
+	if shardID == "" {
+		return nil
+	}
+
+	shard := &par.ShardStatus{
+		ID:                          shardID,
+		ParentShardId:               readString(item, ParentShardIdKey),
+		AdjacentParentShardId:       readString(item, AdjacentParentShardIdKey),
+		Checkpoint:                  readString(item, SequenceNumberKey),
+		AssignedTo:                  readString(item, LeaseOwnerKey),
+		ClaimRequest:                readString(item, ClaimRequestKey),
+		Draining:                    readString(item, DrainingKey),
+		PendingCheckpoint:           readPendingCheckpoint(item),
+		CheckpointSubSequenceNumber: readSubSequenceNumber(item),
+		RewindRequest:               readRewindRequest(item),
+		Mux:                         &sync.RWMutex{},
+	}
+	if leaseTimeout, err := time.Parse(time.RFC3339Nano, readString(item, LeaseTimeoutKey)); err == nil {
+		shard.LeaseTimeout = leaseTimeout
+	}
+	readStickyColumns(shard, item)
+	return shard
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// importCheckpointer records the leases, checkpoints, releases and pins written by ImportSnapshot
+type importCheckpointer struct {
+	recordingCheckpointer
+	StickyAdmin
+}
+
+func (m *importCheckpointer) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.writes = append(m.writes, "lease "+shard.ID+" to "+newAssignTo)
+	shard.SetLeaseOwner(newAssignTo)
+	return nil
+}
+
+func (m *importCheckpointer) PinShardUntil(shardID, workerID string, expiry time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.writes = append(m.writes, "pin "+shardID+" to "+workerID+" until "+expiry.Format(time.RFC3339))
+	return nil
+}
+
+func (m *importCheckpointer) PinShardToGroup(shardID, selector string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.writes = append(m.writes, "pin "+shardID+" to "+selector)
+	return nil
+}
+
+func TestExportSnapshot(t *testing.T) {
+	expiry := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item:       map[string]types.AttributeValue{},
+		scanItems: []map[string]types.AttributeValue{
+			{
+				LeaseKeyKey:          &types.AttributeValueMemberS{Value: "0002"},
+				LeaseOwnerKey:        &types.AttributeValueMemberS{Value: "abc"},
+				SequenceNumberKey:    &types.AttributeValueMemberS{Value: "200"},
+				SubSequenceNumberKey: &types.AttributeValueMemberN{Value: "3"},
+				ParentShardIdKey:     &types.AttributeValueMemberS{Value: "0001"},
+				StickyKey:            &types.AttributeValueMemberN{Value: "10"},
+				StickyWorkerKey:      &types.AttributeValueMemberS{Value: "abc"},
+				StickyExpiryKey:      &types.AttributeValueMemberS{Value: expiry.Format(time.RFC3339Nano)},
+			},
+			{
+				LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+				SequenceNumberKey: &types.AttributeValueMemberS{Value: ShardEnd},
+				StickyKey:         &types.AttributeValueMemberN{Value: "20"},
+			},
+		},
+	}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	snapshot, err := ExportSnapshot(checkpointer)
+	assert.Nil(t, err)
+	assert.Equal(t, SnapshotVersion, snapshot.Version)
+
+	sub := int64(3)
+	assert.Equal(t, []SnapshotLease{
+		{ShardID: "0001", Checkpoint: ShardEnd},
+		{ShardID: "0002", Checkpoint: "200", SubSequenceNumber: &sub, ParentShardID: "0001", Owner: "abc",
+			Sticky: 10, StickyWorker: "abc", StickyExpiry: &expiry},
+	}, snapshot.Leases)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	sub := int64(1)
+	snapshot := &Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		Leases: []SnapshotLease{
+			{ShardID: "0001", Checkpoint: "100", SubSequenceNumber: &sub, Owner: "abc"},
+			{ShardID: "0002", Sticky: 10, StickyGroup: "zone=us-west-2a"},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteSnapshot(&buf, snapshot))
+	read, err := ReadSnapshot(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, snapshot, read)
+
+	_, err = ReadSnapshot(strings.NewReader(`{"version": 2, "leases": []}`))
+	assert.ErrorIs(t, err, ErrUnsupportedSnapshotVersion)
+}
+
+func TestImportSnapshot(t *testing.T) {
+	expiry := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := &Snapshot{
+		Version: SnapshotVersion,
+		Leases: []SnapshotLease{
+			{ShardID: "0001", Checkpoint: "100", Owner: "abc"},
+			{ShardID: "0002", Owner: "def", Sticky: 10, StickyWorker: "def", StickyExpiry: &expiry},
+			{ShardID: "0003", Checkpoint: "300", Sticky: 10, StickyGroup: "zone=us-west-2a"},
+		},
+	}
+
+	checkpointer := &importCheckpointer{}
+	assert.Nil(t, ImportSnapshot(checkpointer, snapshot, ImportOptions{WorkerID: "admin"}))
+	assert.Equal(t, []string{
+		"lease 0001 to abc", "0001=100",
+		"lease 0002 to def", "pin 0002 to def until 2024-07-01T00:00:00Z",
+		"lease 0003 to admin", "0003=300", "pin 0003 to zone=us-west-2a", "release 0003",
+	}, checkpointer.recorded())
+
+	checkpointer = &importCheckpointer{}
+	assert.Nil(t, ImportSnapshot(checkpointer, snapshot, ImportOptions{WorkerID: "admin", StripOwnership: true}))
+	assert.Equal(t, []string{
+		"lease 0001 to admin", "0001=100", "release 0001",
+		"lease 0002 to admin", "pin 0002 to def until 2024-07-01T00:00:00Z", "release 0002",
+		"lease 0003 to admin", "0003=300", "pin 0003 to zone=us-west-2a", "release 0003",
+	}, checkpointer.recorded())
+
+	// pins can't be imported into a checkpointer without StickyAdmin
+	err := ImportSnapshot(&recordingCheckpointer{Checkpointer: checkpointer}, snapshot, ImportOptions{WorkerID: "admin"})
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
@@ -0,0 +1,576 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+	"github.com/vmware/vmware-go-kcl-v2/logger"
+)
+
+// SQLPlaceholderStyle is the bind parameter syntax of a database/sql driver
+type SQLPlaceholderStyle int
+
+const (
+	// QuestionPlaceholders are the ? parameters of SQLite and MySQL
+	QuestionPlaceholders SQLPlaceholderStyle = iota
+	// DollarPlaceholders are the $1, $2... parameters of PostgreSQL
+	DollarPlaceholders
+)
+
+// ErrInvalidTableName is returned by SQLCheckpoint.Init when the table name isn't a plain SQL identifier
+var ErrInvalidTableName = errors.New("InvalidTableName")
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlMigrations are the schema versions of the lease table, applied in order by Init. Published migrations are never
+// edited: a schema change is a new migration.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS %[1]s (
+	shard_id VARCHAR(256) NOT NULL PRIMARY KEY,
+	assigned_to VARCHAR(256) NOT NULL DEFAULT '',
+	lease_timeout BIGINT NOT NULL DEFAULT 0,
+	checkpoint VARCHAR(256) NOT NULL DEFAULT '',
+	sub_sequence_number BIGINT,
+	parent_shard_id VARCHAR(256) NOT NULL DEFAULT '',
+	claim_request VARCHAR(256) NOT NULL DEFAULT ''
+)`,
+	`ALTER TABLE %[1]s ADD COLUMN adjacent_parent_shard_id VARCHAR(256) NOT NULL DEFAULT ''`,
+}
+
+// sqlLeaseColumns are the columns read by the lease queries, see scanLease
+const sqlLeaseColumns = "shard_id, assigned_to, lease_timeout, checkpoint, sub_sequence_number, parent_shard_id, " +
+	"adjacent_parent_shard_id, claim_request"
+
+// TxCheckpointer is implemented by checkpointers able to write a checkpoint in a transaction of the caller, so that
+// the output of the record processor and the checkpoint are committed atomically.
+type TxCheckpointer interface {
+	// CheckpointSequenceTx writes the checkpoint of the shard in the transaction, fenced by the lease
+	CheckpointSequenceTx(ctx context.Context, tx *sql.Tx, shard *par.ShardStatus) error
+}
+
+// sqlExecer is a *sql.DB or a *sql.Tx
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlScanner is a *sql.Row or *sql.Rows
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// SQLCheckpoint is a Checkpointer keeping the lease table in a database reached through database/sql. Leases are
+// acquired with a conditional UPDATE on the owner, the lease timeout and the claim request read before, so that only
+// one worker wins a race for a lease. The lease timeout is stored in Unix milliseconds and compared exactly.
+//
+// Unlike DynamoCheckpoint, renewing a lease never writes the checkpoint: checkpoints are only written by
+// CheckpointSequence and CheckpointSequenceTx, the latter in a transaction of the caller, and a checkpoint of a
+// transaction which was rolled back is never persisted. Sticky shards, draining, pending checkpoints and rewinds are
+// not supported.
+type SQLCheckpoint struct {
+	log           logger.Logger
+	db            *sql.DB
+	TableName     string
+	LeaseDuration int
+	kclConfig     *config.KinesisClientLibConfiguration
+	placeholders  SQLPlaceholderStyle
+}
+
+// NewSQLCheckpoint returns a checkpointer keeping the lease table in the database. The table is named after the
+// application, with the characters which aren't valid in an SQL identifier replaced by underscores.
+func NewSQLCheckpoint(kclConfig *config.KinesisClientLibConfiguration, db *sql.DB) *SQLCheckpoint {
+	return &SQLCheckpoint{
+		log:           kclConfig.Logger,
+		db:            db,
+		TableName:     sqlTableName(kclConfig.TableName),
+		LeaseDuration: kclConfig.FailoverTimeMillis,
+		kclConfig:     kclConfig,
+	}
+}
+
+// WithPlaceholders sets the bind parameter syntax of the driver, QuestionPlaceholders by default
+func (checkpointer *SQLCheckpoint) WithPlaceholders(style SQLPlaceholderStyle) *SQLCheckpoint {
+	checkpointer.placeholders = style
+	return checkpointer
+}
+
+// Init applies the schema migrations the lease table is missing. The applied versions are recorded in the
+// <table>_migrations table.
+func (checkpointer *SQLCheckpoint) Init() error {
+	if !sqlIdentifier.MatchString(checkpointer.TableName) {
+		return fmt.Errorf("%w: %q", ErrInvalidTableName, checkpointer.TableName)
+	}
+
+	ctx := context.TODO()
+	if _, err := checkpointer.db.ExecContext(ctx, checkpointer.query(
+		"CREATE TABLE IF NOT EXISTS %[1]s_migrations (version INTEGER NOT NULL PRIMARY KEY)")); err != nil {
+		return err
+	}
+
+	applied, err := checkpointer.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, migration := range sqlMigrations {
+		version := i + 1
+		if applied[version] {
+			continue
+		}
+		if err := checkpointer.migrate(ctx, version, migration); err != nil {
+			// another worker may have applied the migration concurrently, its version is recorded then
+			if applied, readErr := checkpointer.appliedMigrations(ctx); readErr == nil && applied[version] {
+				checkpointer.log.Debugf("Migration %d of lease table %s was applied by another worker", version, checkpointer.TableName)
+				continue
+			}
+			return fmt.Errorf("migration %d of lease table %s: %w", version, checkpointer.TableName, err)
+		}
+		checkpointer.log.Infof("Applied migration %d of lease table %s", version, checkpointer.TableName)
+	}
+	return nil
+}
+
+// appliedMigrations returns the versions recorded in the migrations table
+func (checkpointer *SQLCheckpoint) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	rows, err := checkpointer.db.QueryContext(ctx, checkpointer.query("SELECT version FROM %[1]s_migrations"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// migrate applies a migration and records its version in one transaction. A worker racing with another one on the
+// same version fails to apply or record it and its transaction is rolled back, Init then finds the version recorded.
+func (checkpointer *SQLCheckpoint) migrate(ctx context.Context, version int, migration string) error {
+	tx, err := checkpointer.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, checkpointer.query(migration)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, checkpointer.query("INSERT INTO %[1]s_migrations (version) VALUES (?)"), version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLease attempts to gain a lock on the given shard
+func (checkpointer *SQLCheckpoint) GetLease(shard *par.ShardStatus, newAssignTo string) error {
+	ctx := context.TODO()
+	current, err := checkpointer.getLease(ctx, shard.ID)
+	if err != nil {
+		return err
+	}
+
+	newLeaseTimeout := time.Now().Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
+	if current == nil {
+		if err := checkpointer.insertLease(ctx, shard, newAssignTo, newLeaseTimeout); err != nil {
+			return err
+		}
+		shard.Mux.Lock()
+		shard.AssignedTo = newAssignTo
+		shard.LeaseTimeout = newLeaseTimeout
+		shard.Mux.Unlock()
+		return nil
+	}
+
+	isClaimRequestExpired := shard.IsClaimRequestExpired(checkpointer.kclConfig)
+
+	claimRequest := current.ClaimRequest
+	if checkpointer.kclConfig.EnableLeaseStealing && claimRequest != "" && newAssignTo != claimRequest && !isClaimRequestExpired {
+		checkpointer.log.Debugf("another worker: %s has a claim on this shard. Not going to renew the lease", claimRequest)
+		return errors.New(ErrShardClaimed)
+	}
+
+	if current.AssignedTo != "" {
+		if current.LeaseTimeout.IsZero() {
+			return ErrLeaseNotAcquired{"lease of shard " + shard.ID + " has no lease timeout"}
+		}
+		if time.Now().UTC().Before(current.LeaseTimeout) && current.AssignedTo != newAssignTo &&
+			(!checkpointer.kclConfig.EnableLeaseStealing || !isClaimRequestExpired) {
+			return ErrLeaseNotAcquired{"current lease timeout not yet expired"}
+		}
+	}
+
+	// the lease is only taken if nobody renewed, took or claimed it since it was read
+	parentShardID := current.ParentShardId
+	if shard.ParentShardId != "" {
+		parentShardID = shard.ParentShardId
+	}
+	adjacentParentShardID := current.AdjacentParentShardId
+	if shard.AdjacentParentShardId != "" {
+		adjacentParentShardID = shard.AdjacentParentShardId
+	}
+	result, err := checkpointer.db.ExecContext(ctx, checkpointer.query(
+		"UPDATE %[1]s SET assigned_to = ?, lease_timeout = ?, parent_shard_id = ?, adjacent_parent_shard_id = ?, "+
+			"claim_request = '' WHERE shard_id = ? AND assigned_to = ? AND lease_timeout = ? AND claim_request = ?"),
+		newAssignTo, newLeaseTimeout.UnixMilli(), parentShardID, adjacentParentShardID,
+		shard.ID, current.AssignedTo, sqlLeaseTimeout(current.LeaseTimeout), claimRequest)
+	if err := checkpointer.updated(result, err); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrLeaseNotAcquired{"lease of shard " + shard.ID + " was updated by another worker"}
+		}
+		return err
+	}
+
+	shard.Mux.Lock()
+	shard.AssignedTo = newAssignTo
+	shard.LeaseTimeout = newLeaseTimeout
+	shard.ClaimRequest = ""
+	shard.Mux.Unlock()
+
+	return nil
+}
+
+// insertLease creates the lease of a shard missing from the lease table
+func (checkpointer *SQLCheckpoint) insertLease(ctx context.Context, shard *par.ShardStatus, newAssignTo string, leaseTimeout time.Time) error {
+	var subSequenceNumber interface{}
+	checkpoint := shard.GetCheckpoint()
+	if number := shard.GetCheckpointSubSequenceNumber(); checkpoint != "" && number != nil {
+		subSequenceNumber = *number
+	}
+
+	_, err := checkpointer.db.ExecContext(ctx, checkpointer.query(
+		"INSERT INTO %[1]s ("+sqlLeaseColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, '')"),
+		shard.ID, newAssignTo, leaseTimeout.UnixMilli(), checkpoint, subSequenceNumber, shard.ParentShardId,
+		shard.AdjacentParentShardId)
+	if err == nil {
+		return nil
+	}
+
+	// the error of a duplicate key is specific to the driver: the lease was created by another worker if it exists now
+	if current, getErr := checkpointer.getLease(ctx, shard.ID); getErr == nil && current != nil {
+		return ErrLeaseNotAcquired{"lease of shard " + shard.ID + " was created by another worker"}
+	}
+	return err
+}
+
+// CheckpointSequence writes a checkpoint at the designated sequence ID, fenced by the lease
+func (checkpointer *SQLCheckpoint) CheckpointSequence(shard *par.ShardStatus) error {
+	return checkpointer.checkpointSequence(context.TODO(), checkpointer.db, shard)
+}
+
+// CheckpointSequenceTx writes a checkpoint at the designated sequence ID in the transaction, fenced by the lease. The
+// checkpoint is committed along with the other statements of the transaction, the caller commits or rolls it back.
+func (checkpointer *SQLCheckpoint) CheckpointSequenceTx(ctx context.Context, tx *sql.Tx, shard *par.ShardStatus) error {
+	return checkpointer.checkpointSequence(ctx, tx, shard)
+}
+
+func (checkpointer *SQLCheckpoint) checkpointSequence(ctx context.Context, execer sqlExecer, shard *par.ShardStatus) error {
+	owner := shard.GetLeaseOwner()
+	checkpoint := shard.GetCheckpoint()
+
+	var subSequenceNumber interface{}
+	if number := shard.GetCheckpointSubSequenceNumber(); number != nil {
+		subSequenceNumber = *number
+	}
+
+	assignments := "checkpoint = ?, sub_sequence_number = ?"
+	args := []interface{}{checkpoint, subSequenceNumber}
+	if len(shard.ParentShardId) > 0 {
+		assignments += ", parent_shard_id = ?"
+		args = append(args, shard.ParentShardId)
+	}
+	if len(shard.AdjacentParentShardId) > 0 {
+		assignments += ", adjacent_parent_shard_id = ?"
+		args = append(args, shard.AdjacentParentShardId)
+	}
+
+	// A checkpoint supersedes any pending claim on the shard
+	result, err := execer.ExecContext(ctx, checkpointer.query(
+		"UPDATE %[1]s SET "+assignments+", claim_request = '' WHERE shard_id = ? AND assigned_to = ?"),
+		append(args, shard.ID, owner)...)
+	if err := checkpointer.updated(result, err); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return leaseLostError(shard.ID, owner)
+		}
+		return err
+	}
+	return nil
+}
+
+// FetchCheckpoint retrieves the checkpoint for the given shard
+func (checkpointer *SQLCheckpoint) FetchCheckpoint(shard *par.ShardStatus) error {
+	lease, err := checkpointer.getLease(context.TODO(), shard.ID)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Checkpoint == "" {
+		return ErrSequenceIDNotFound
+	}
+
+	shard.SetCheckpoint(lease.Checkpoint)
+	shard.SetCheckpointSubSequenceNumber(lease.CheckpointSubSequenceNumber)
+	if lease.AssignedTo != "" {
+		shard.SetLeaseOwner(lease.AssignedTo)
+	}
+	if !lease.LeaseTimeout.IsZero() {
+		shard.SetLeaseTimeout(lease.LeaseTimeout)
+	}
+	shard.SetClaimRequest(lease.ClaimRequest)
+	return nil
+}
+
+// RemoveLeaseInfo removes the lease of a shard which no longer exists
+func (checkpointer *SQLCheckpoint) RemoveLeaseInfo(shardID string) error {
+	if _, err := checkpointer.db.ExecContext(context.TODO(), checkpointer.query("DELETE FROM %[1]s WHERE shard_id = ?"), shardID); err != nil {
+		checkpointer.log.Errorf("Error in removing lease info for shard: %s, Error: %+v", shardID, err)
+		return err
+	}
+
+	checkpointer.log.Infof("Lease info for shard: %s has been removed.", shardID)
+	return nil
+}
+
+// RemoveLeaseOwner removes the lease owner of a shard held by this worker, making the shard available for reassignment
+func (checkpointer *SQLCheckpoint) RemoveLeaseOwner(shardID string) error {
+	workerID := checkpointer.kclConfig.WorkerID
+	result, err := checkpointer.db.ExecContext(context.TODO(), checkpointer.query(
+		"UPDATE %[1]s SET assigned_to = '' WHERE shard_id = ? AND assigned_to = ?"), shardID, workerID)
+	if err := checkpointer.updated(result, err); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrLeaseNotAcquired{"lease is not held by " + workerID}
+		}
+		return err
+	}
+	return nil
+}
+
+// GetLeaseOwner returns the current lease owner of the shard
+func (checkpointer *SQLCheckpoint) GetLeaseOwner(shardID string) (string, error) {
+	lease, err := checkpointer.getLease(context.TODO(), shardID)
+	if err != nil {
+		return "", err
+	}
+	if lease == nil || lease.AssignedTo == "" {
+		return "", NoLeaseOwnerErr
+	}
+	return lease.AssignedTo, nil
+}
+
+// ListActiveWorkers returns a map of workers and their shards. The owner and checkpoint of the shards are refreshed
+// from their lease.
+func (checkpointer *SQLCheckpoint) ListActiveWorkers(shardStatus map[string]*par.ShardStatus) (map[string][]*par.ShardStatus, error) {
+	leases, err := checkpointer.listLeases(context.TODO(), "")
+	if err != nil {
+		return nil, err
+	}
+	byShardID := make(map[string]*par.ShardStatus, len(leases))
+	for _, lease := range leases {
+		byShardID[lease.ID] = lease
+	}
+
+	workers := map[string][]*par.ShardStatus{}
+	for _, shard := range shardStatus {
+		if lease, ok := byShardID[shard.ID]; ok && lease.AssignedTo != "" && lease.Checkpoint != "" {
+			shard.SetLeaseOwner(lease.AssignedTo)
+			shard.SetCheckpoint(lease.Checkpoint)
+		}
+
+		if shard.GetCheckpoint() == ShardEnd {
+			continue
+		}
+
+		leaseOwner := shard.GetLeaseOwner()
+		if leaseOwner == "" {
+			checkpointer.log.Debugf("Shard Not Assigned Error. ShardID: %s, WorkerID: %s", shard.ID, checkpointer.kclConfig.WorkerID)
+			return nil, ErrShardNotAssigned
+		}
+		workers[leaseOwner] = append(workers[leaseOwner], shard)
+	}
+	return workers, nil
+}
+
+// ClaimShard places a claim request on a shard to signal a steal attempt. A shard has at most one claim request.
+func (checkpointer *SQLCheckpoint) ClaimShard(shard *par.ShardStatus, claimID string) error {
+	ctx := context.TODO()
+	result, err := checkpointer.db.ExecContext(ctx, checkpointer.query(
+		"UPDATE %[1]s SET claim_request = ? WHERE shard_id = ? AND claim_request = ''"), claimID, shard.ID)
+	err = checkpointer.updated(result, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		var current *par.ShardStatus
+		if current, err = checkpointer.getLease(ctx, shard.ID); err != nil {
+			return err
+		}
+		if current != nil {
+			return ErrLeaseNotAcquired{"shard " + shard.ID + " is already claimed by " + current.ClaimRequest}
+		}
+
+		// like DynamoCheckpoint, a claim on a shard without lease creates it
+		_, err = checkpointer.db.ExecContext(ctx, checkpointer.query(
+			"INSERT INTO %[1]s (shard_id, parent_shard_id, adjacent_parent_shard_id, claim_request) VALUES (?, ?, ?, ?)"),
+			shard.ID, shard.ParentShardId, shard.AdjacentParentShardId, claimID)
+	}
+	if err != nil {
+		return err
+	}
+
+	shard.SetClaimRequest(claimID)
+	return nil
+}
+
+// ListLeases returns the leases of all shards sorted by shard ID
+func (checkpointer *SQLCheckpoint) ListLeases() ([]*par.ShardStatus, error) {
+	return checkpointer.listLeases(context.TODO(), "")
+}
+
+// ListLeasesByOwner returns the leases held by the worker sorted by shard ID
+func (checkpointer *SQLCheckpoint) ListLeasesByOwner(workerID string) ([]*par.ShardStatus, error) {
+	return checkpointer.listLeases(context.TODO(), workerID)
+}
+
+// getLease reads the lease of a shard, nil if the shard has none
+func (checkpointer *SQLCheckpoint) getLease(ctx context.Context, shardID string) (*par.ShardStatus, error) {
+	row := checkpointer.db.QueryRowContext(ctx, checkpointer.query(
+		"SELECT "+sqlLeaseColumns+" FROM %[1]s WHERE shard_id = ?"), shardID)
+	lease, err := scanLease(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return lease, err
+}
+
+// listLeases reads the leases of all shards, or of the shards held by owner if it isn't empty
+func (checkpointer *SQLCheckpoint) listLeases(ctx context.Context, owner string) ([]*par.ShardStatus, error) {
+	var rows *sql.Rows
+	var err error
+	if owner == "" {
+		rows, err = checkpointer.db.QueryContext(ctx, checkpointer.query("SELECT "+sqlLeaseColumns+" FROM %[1]s"))
+	} else {
+		rows, err = checkpointer.db.QueryContext(ctx, checkpointer.query("SELECT "+sqlLeaseColumns+" FROM %[1]s WHERE assigned_to = ?"), owner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leases []*par.ShardStatus
+	for rows.Next() {
+		lease, err := scanLease(rows)
+		if err != nil {
+			return nil, err
+		}
+		leases = append(leases, lease)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(leases, func(i, j int) bool { return leases[i].ID < leases[j].ID })
+	return leases, nil
+}
+
+// scanLease reads a row of sqlLeaseColumns
+func scanLease(row sqlScanner) (*par.ShardStatus, error) {
+	var leaseTimeout int64
+	var subSequenceNumber sql.NullInt64
+	lease := &par.ShardStatus{Sticky: int(par.StickyUnset), Mux: &sync.RWMutex{}}
+	if err := row.Scan(&lease.ID, &lease.AssignedTo, &leaseTimeout, &lease.Checkpoint, &subSequenceNumber,
+		&lease.ParentShardId, &lease.AdjacentParentShardId, &lease.ClaimRequest); err != nil {
+		return nil, err
+	}
+
+	if leaseTimeout != 0 {
+		lease.LeaseTimeout = time.UnixMilli(leaseTimeout).UTC()
+	}
+	if subSequenceNumber.Valid {
+		lease.CheckpointSubSequenceNumber = &subSequenceNumber.Int64
+	}
+	return lease, nil
+}
+
+// updated returns the error of a statement, or sql.ErrNoRows if it changed no row
+func (checkpointer *SQLCheckpoint) updated(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// query returns the statement for the lease table, with the placeholders of the driver. %[1]s is the table name.
+func (checkpointer *SQLCheckpoint) query(statement string) string {
+	query := fmt.Sprintf(statement, checkpointer.TableName)
+	if checkpointer.placeholders != DollarPlaceholders {
+		return query
+	}
+
+	var builder strings.Builder
+	parameter := 0
+	for _, r := range query {
+		if r == '?' {
+			parameter++
+			builder.WriteString("$" + strconv.Itoa(parameter))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// sqlLeaseTimeout returns the stored value of a lease timeout, 0 for none
+func sqlLeaseTimeout(leaseTimeout time.Time) int64 {
+	if leaseTimeout.IsZero() {
+		return 0
+	}
+	return leaseTimeout.UnixMilli()
+}
+
+// sqlTableName replaces the characters of the table name which aren't valid in an SQL identifier
+func sqlTableName(tableName string) string {
+	name := []rune(tableName)
+	for i, r := range name {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
@@ -0,0 +1,344 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	_ "modernc.org/sqlite"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// openSQLite returns a new empty SQLite database. Concurrent writers wait for each other rather than failing.
+func openSQLite(t *testing.T) *sql.DB {
+	return openSQLiteFile(t, filepath.Join(t.TempDir(), "leases.db"))
+}
+
+// openSQLiteFile opens the SQLite database stored in the file, like another worker sharing the lease table would
+func openSQLiteFile(t *testing.T, path string) *sql.DB {
+	dsn := "file:" + path + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	assert.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// sqliteTables returns the names of the tables of the database
+func sqliteTables(t *testing.T, db *sql.DB) []string {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	assert.Nil(t, err)
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		assert.Nil(t, rows.Scan(&name))
+		tables = append(tables, name)
+	}
+	return tables
+}
+
+func newTestSQLCheckpoint(t *testing.T, kclConfig *config.KinesisClientLibConfiguration) *SQLCheckpoint {
+	checkpointer := NewSQLCheckpoint(kclConfig, openSQLite(t))
+	assert.Nil(t, checkpointer.Init())
+	return checkpointer
+}
+
+func TestSQLCheckpointInterfaces(t *testing.T) {
+	checkpointer := newTestSQLCheckpoint(t, config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"))
+
+	var _ Checkpointer = checkpointer
+	var _ TxCheckpointer = checkpointer
+	var _ LeaseLister = checkpointer
+	var _ LeaseOwnerLister = checkpointer
+}
+
+func TestSQLCheckpointMigrations(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("app-name", "test", "us-west-2", "abc")
+	checkpointer := newTestSQLCheckpoint(t, kclConfig)
+	assert.Equal(t, "app_name", checkpointer.TableName)
+	assert.Equal(t, []string{"app_name", "app_name_migrations"}, sqliteTables(t, checkpointer.db))
+
+	var versions int
+	assert.Nil(t, checkpointer.db.QueryRow("SELECT COUNT(*) FROM app_name_migrations").Scan(&versions))
+	assert.Equal(t, len(sqlMigrations), versions)
+
+	// the migrations already applied are skipped
+	assert.Nil(t, checkpointer.GetLease(newMemoryShard("0001"), "abc"))
+	assert.Nil(t, checkpointer.Init())
+	assert.Nil(t, checkpointer.db.QueryRow("SELECT COUNT(*) FROM app_name_migrations").Scan(&versions))
+	assert.Equal(t, len(sqlMigrations), versions)
+	owner, err := checkpointer.GetLeaseOwner("0001")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", owner)
+
+	checkpointer.TableName = "app name"
+	assert.ErrorIs(t, checkpointer.Init(), ErrInvalidTableName)
+}
+
+func TestSQLCheckpointAdjacentParentMigration(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewSQLCheckpoint(kclConfig, openSQLite(t))
+
+	// a lease table created before the adjacent parent column was added
+	_, err := checkpointer.db.Exec("CREATE TABLE appName_migrations (version INTEGER NOT NULL PRIMARY KEY)")
+	assert.Nil(t, err)
+	assert.Nil(t, checkpointer.migrate(context.Background(), 1, sqlMigrations[0]))
+	_, err = checkpointer.db.Exec("INSERT INTO appName (shard_id, parent_shard_id) VALUES ('0001', '0000')")
+	assert.Nil(t, err)
+
+	assert.Nil(t, checkpointer.Init())
+	leases, err := checkpointer.ListLeases()
+	assert.Nil(t, err)
+	if assert.Len(t, leases, 1) {
+		assert.Equal(t, "0000", leases[0].ParentShardId)
+		assert.Empty(t, leases[0].AdjacentParentShardId)
+	}
+
+	// both parents of a merged shard are stored
+	merged := newMemoryShard("0003")
+	merged.ParentShardId, merged.AdjacentParentShardId = "0001", "0002"
+	assert.Nil(t, checkpointer.GetLease(merged, "abc"))
+	merged.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(merged))
+
+	leases, err = checkpointer.ListLeasesByOwner("abc")
+	assert.Nil(t, err)
+	if assert.Len(t, leases, 1) {
+		assert.Equal(t, "0001", leases[0].ParentShardId)
+		assert.Equal(t, "0002", leases[0].AdjacentParentShardId)
+		assert.Equal(t, "100", leases[0].Checkpoint)
+	}
+}
+
+func TestSQLCheckpointConcurrentInit(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	path := filepath.Join(t.TempDir(), "leases.db")
+
+	// workers starting together race on every migration, each one is applied and recorded once
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		checkpointer := NewSQLCheckpoint(kclConfig, openSQLiteFile(t, path))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = checkpointer.Init()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.Nil(t, err)
+	}
+
+	checkpointer := NewSQLCheckpoint(kclConfig, openSQLiteFile(t, path))
+	var versions int
+	assert.Nil(t, checkpointer.db.QueryRow("SELECT COUNT(*) FROM appName_migrations").Scan(&versions))
+	assert.Equal(t, len(sqlMigrations), versions)
+}
+
+func TestSQLCheckpointLease(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := newTestSQLCheckpoint(t, kclConfig)
+
+	shard := newMemoryShard("0001")
+	assert.Equal(t, ErrSequenceIDNotFound, checkpointer.FetchCheckpoint(shard))
+
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, "abc", shard.GetLeaseOwner())
+	owner, err := checkpointer.GetLeaseOwner("0001")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", owner)
+
+	// the lease of another worker isn't acquired before it expires
+	other := newMemoryShard("0001")
+	err = checkpointer.GetLease(other, "def")
+	assert.True(t, errors.As(err, &ErrLeaseNotAcquired{}))
+
+	subSequenceNumber := int64(3)
+	shard.SetCheckpoint("100")
+	shard.SetCheckpointSubSequenceNumber(&subSequenceNumber)
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.Nil(t, checkpointer.FetchCheckpoint(other))
+	assert.Equal(t, "100", other.GetCheckpoint())
+	assert.Equal(t, int64(3), *other.GetCheckpointSubSequenceNumber())
+	assert.Equal(t, "abc", other.GetLeaseOwner())
+
+	// a renewal keeps the checkpoint of the lease table
+	shard.SetCheckpoint("150")
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Nil(t, checkpointer.FetchCheckpoint(other))
+	assert.Equal(t, "100", other.GetCheckpoint())
+
+	// an expired lease is taken over and the previous owner can't checkpoint anymore
+	checkpointer.LeaseDuration = 0
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	time.Sleep(2 * time.Millisecond)
+	assert.Nil(t, checkpointer.GetLease(other, "def"))
+	shard.SetCheckpoint("200")
+	assert.ErrorIs(t, checkpointer.CheckpointSequence(shard), ErrLeaseLost)
+
+	workers, err := checkpointer.ListActiveWorkers(map[string]*par.ShardStatus{"0001": newMemoryShard("0001")})
+	assert.Nil(t, err)
+	assert.Len(t, workers["def"], 1)
+	leases, err := checkpointer.ListLeasesByOwner("def")
+	assert.Nil(t, err)
+	assert.Len(t, leases, 1)
+
+	// the worker releases its lease
+	assert.True(t, errors.As(checkpointer.RemoveLeaseOwner("0001"), &ErrLeaseNotAcquired{}))
+	kclConfig.WorkerID = "def"
+	assert.Nil(t, checkpointer.RemoveLeaseOwner("0001"))
+	_, err = checkpointer.GetLeaseOwner("0001")
+	assert.Equal(t, NoLeaseOwnerErr, err)
+
+	assert.Nil(t, checkpointer.RemoveLeaseInfo("0001"))
+	assert.Equal(t, ErrSequenceIDNotFound, checkpointer.FetchCheckpoint(newMemoryShard("0001")))
+}
+
+func TestSQLCheckpointConcurrentLease(t *testing.T) {
+	checkpointer := newTestSQLCheckpoint(t, config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"))
+	shardIDs := []string{"0001", "0002", "0003"}
+
+	// the workers racing for a lease read the same row, the conditional write lets only one of them take it
+	race := func() map[string]int {
+		var wg sync.WaitGroup
+		var mux sync.Mutex
+		acquired := map[string]int{}
+		for _, workerID := range []string{"abc", "def", "ghi", "jkl"} {
+			wg.Add(1)
+			go func(workerID string) {
+				defer wg.Done()
+				for _, shardID := range shardIDs {
+					err := checkpointer.GetLease(newMemoryShard(shardID), workerID)
+					if err == nil {
+						mux.Lock()
+						acquired[shardID]++
+						mux.Unlock()
+					} else {
+						assert.True(t, errors.As(err, &ErrLeaseNotAcquired{}), err)
+					}
+				}
+			}(workerID)
+		}
+		wg.Wait()
+		return acquired
+	}
+
+	// new leases are inserted once
+	assert.Equal(t, map[string]int{"0001": 1, "0002": 1, "0003": 1}, race())
+
+	// expired leases are taken over once
+	_, err := checkpointer.db.Exec("UPDATE appName SET lease_timeout = ? WHERE claim_request = ''",
+		time.Now().Add(-time.Second).UnixMilli())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"0001": 1, "0002": 1, "0003": 1}, race())
+
+	leases, err := checkpointer.ListLeases()
+	assert.Nil(t, err)
+	assert.Len(t, leases, 3)
+}
+
+func TestSQLCheckpointTx(t *testing.T) {
+	checkpointer := newTestSQLCheckpoint(t, config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"))
+
+	shard := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	shard.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	// a checkpoint of a transaction rolled back isn't persisted
+	tx, err := checkpointer.db.BeginTx(context.TODO(), nil)
+	assert.Nil(t, err)
+	shard.SetCheckpoint("200")
+	assert.Nil(t, checkpointer.CheckpointSequenceTx(context.TODO(), tx, shard))
+	assert.Nil(t, tx.Rollback())
+
+	fetched := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.FetchCheckpoint(fetched))
+	assert.Equal(t, "100", fetched.GetCheckpoint())
+
+	// and it is once the transaction commits
+	tx, err = checkpointer.db.BeginTx(context.TODO(), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, checkpointer.CheckpointSequenceTx(context.TODO(), tx, shard))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, checkpointer.FetchCheckpoint(fetched))
+	assert.Equal(t, "200", fetched.GetCheckpoint())
+
+	// the checkpoint is fenced by the lease
+	tx, err = checkpointer.db.BeginTx(context.TODO(), nil)
+	assert.Nil(t, err)
+	shard.SetLeaseOwner("def")
+	assert.ErrorIs(t, checkpointer.CheckpointSequenceTx(context.TODO(), tx, shard), ErrLeaseLost)
+	assert.Nil(t, tx.Rollback())
+}
+
+func TestSQLCheckpointClaim(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseStealing(true)
+	checkpointer := newTestSQLCheckpoint(t, kclConfig)
+
+	shard := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	shard.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	claimed := newMemoryShard("0001")
+	assert.Nil(t, checkpointer.ClaimShard(claimed, "def"))
+	assert.Equal(t, "def", claimed.GetClaimRequest())
+	assert.True(t, errors.As(checkpointer.ClaimShard(newMemoryShard("0001"), "ghi"), &ErrLeaseNotAcquired{}))
+
+	// the owner doesn't renew a claimed lease
+	assert.EqualError(t, checkpointer.GetLease(shard, "abc"), ErrShardClaimed)
+
+	// the claimant acquires the lease once it expired, which drops the claim
+	assert.True(t, errors.As(checkpointer.GetLease(claimed, "def"), &ErrLeaseNotAcquired{}))
+	_, err := checkpointer.db.Exec("UPDATE appName SET lease_timeout = ? WHERE shard_id = ?",
+		time.Now().Add(-time.Second).UnixMilli(), "0001")
+	assert.Nil(t, err)
+	assert.Nil(t, checkpointer.GetLease(claimed, "def"))
+	assert.Nil(t, checkpointer.FetchCheckpoint(claimed))
+	assert.Equal(t, "", claimed.GetClaimRequest())
+	assert.Equal(t, "100", claimed.GetCheckpoint())
+
+	// a claim on a shard without lease creates it
+	assert.Nil(t, checkpointer.ClaimShard(newMemoryShard("0002"), "def"))
+	assert.Nil(t, checkpointer.GetLease(newMemoryShard("0002"), "def"))
+}
+
+func TestSQLCheckpointPlaceholders(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := NewSQLCheckpoint(kclConfig, nil)
+	assert.Equal(t, "DELETE FROM appName WHERE shard_id = ?", checkpointer.query("DELETE FROM %[1]s WHERE shard_id = ?"))
+
+	checkpointer.WithPlaceholders(DollarPlaceholders)
+	assert.Equal(t, "UPDATE appName SET assigned_to = '' WHERE shard_id = $1 AND assigned_to = $2",
+		checkpointer.query("UPDATE %[1]s SET assigned_to = '' WHERE shard_id = ? AND assigned_to = ?"))
+}
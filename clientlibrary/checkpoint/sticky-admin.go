@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package checkpoint
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+var (
+	// ErrShardNotFound is returned by the sticky administration API when the lease table has no entry for the shard
+	ErrShardNotFound = errors.New("ShardNotFoundInLeaseTable")
+
+	// ErrInvalidShardID is returned by the sticky administration API when the shard id is empty
+	ErrInvalidShardID = errors.New("InvalidShardID")
+)
+
+// StickyAdmin is implemented by checkpointers that allow the Sticky and StickyWorker columns
+// of the lease table to be managed programmatically. Workers never change these columns: a lease
+// write keeps the values it read and fails if they were updated since, so an update of the
+// StickyAdmin API is never overwritten. The StickyAdmin API is meant for operators and automation.
+type StickyAdmin interface {
+	// PinShard pins the shard to the given worker (Sticky=10). If workerID is empty the shard is
+	// pinned to whichever worker currently holds its lease.
+	PinShard(shardID, workerID string) error
+
+	// PinShardUntil pins the shard like PinShard until the given expiry, after which the shard goes
+	// back to normal assignment. A zero expiry never expires. It replaces any StickyGroup binding.
+	PinShardUntil(shardID, workerID string, expiry time.Time) error
+
+	// PinShardToGroup pins the shard (Sticky=10) to any worker whose labels match the given selector,
+	// e.g. "zone=us-west-2a,tier!=spot". It replaces any StickyWorker binding and StickyExpiry.
+	PinShardToGroup(shardID, selector string) error
+
+	// UnpinShard removes the Sticky and StickyWorker columns so the shard goes back to normal assignment
+	UnpinShard(shardID string) error
+
+	// RequestShardRelease asks the worker holding the shard to gracefully release it (Sticky=20)
+	RequestShardRelease(shardID string) error
+
+	// ListPinnedShards returns the shards currently pinned (Sticky=10)
+	ListPinnedShards() ([]*par.ShardStatus, error)
+}
+
+// PinShard pins the shard to the given worker by setting Sticky=10 and StickyWorker.
+// The update is conditional on the shard already being present in the lease table.
+func (checkpointer *DynamoCheckpoint) PinShard(shardID, workerID string) error {
+	return checkpointer.PinShardUntil(shardID, workerID, time.Time{})
+}
+
+// PinShardUntil pins the shard to the given worker by setting Sticky=10, StickyWorker and StickyExpiry. The
+// StickyGroup of a shard pinned to a worker group is removed.
+func (checkpointer *DynamoCheckpoint) PinShardUntil(shardID, workerID string, expiry time.Time) error {
+	set := []string{StickyKey + " = :sticky"}
+	remove := []string{StickyGroupKey}
+	expressionAttributeValues := map[string]types.AttributeValue{
+		":sticky": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", par.StickyPinned)},
+	}
+
+	if workerID = strings.TrimSpace(workerID); workerID == "" {
+		remove = append(remove, StickyWorkerKey)
+	} else {
+		set = append(set, StickyWorkerKey+" = :sticky_worker")
+		expressionAttributeValues[":sticky_worker"] = &types.AttributeValueMemberS{Value: workerID}
+	}
+
+	if expiry.IsZero() {
+		remove = append(remove, StickyExpiryKey)
+	} else {
+		set = append(set, StickyExpiryKey+" = :sticky_expiry")
+		expressionAttributeValues[":sticky_expiry"] = &types.AttributeValueMemberS{Value: expiry.UTC().Format(time.RFC3339Nano)}
+	}
+
+	updateExpression := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		updateExpression += " REMOVE " + strings.Join(remove, ", ")
+	}
+
+	return checkpointer.updateSticky(shardID, updateExpression, expressionAttributeValues)
+}
+
+// PinShardToGroup pins the shard to a worker group by setting Sticky=10 and StickyGroup, without expiry
+func (checkpointer *DynamoCheckpoint) PinShardToGroup(shardID, selector string) error {
+	labelSelector, err := config.ParseLabelSelector(selector)
+	if err != nil {
+		return err
+	}
+	if len(labelSelector) == 0 {
+		return fmt.Errorf("%w: empty selector", config.ErrInvalidLabelSelector)
+	}
+
+	return checkpointer.updateSticky(shardID, "SET "+StickyKey+" = :sticky, "+StickyGroupKey+" = :sticky_group REMOVE "+StickyWorkerKey+", "+StickyExpiryKey,
+		map[string]types.AttributeValue{
+			":sticky":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", par.StickyPinned)},
+			":sticky_group": &types.AttributeValueMemberS{Value: labelSelector.String()},
+		})
+}
+
+// UnpinShard removes the Sticky, StickyWorker, StickyGroup and StickyExpiry columns of the shard
+func (checkpointer *DynamoCheckpoint) UnpinShard(shardID string) error {
+	return checkpointer.updateSticky(shardID, "REMOVE "+StickyKey+", "+StickyWorkerKey+", "+StickyGroupKey+", "+StickyExpiryKey, nil)
+}
+
+// RequestShardRelease sets Sticky=20 so that the worker holding the shard checkpoints and releases it
+func (checkpointer *DynamoCheckpoint) RequestShardRelease(shardID string) error {
+	return checkpointer.updateSticky(shardID, "SET "+StickyKey+" = :sticky",
+		map[string]types.AttributeValue{
+			":sticky": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", par.StickyRelease)},
+		})
+}
+
+// ListPinnedShards scans the lease table for shards with Sticky=10, including pins whose StickyExpiry has passed
+func (checkpointer *DynamoCheckpoint) ListPinnedShards() ([]*par.ShardStatus, error) {
+	snapshot, err := checkpointer.ScanLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	var shards []*par.ShardStatus
+	for _, lease := range snapshot.Leases() {
+		if par.StickyState(lease.Sticky) == par.StickyPinned {
+			shards = append(shards, lease)
+		}
+	}
+	return shards, nil
+}
+
+// updateSticky applies the given update expression to the sticky columns of an existing lease entry
+func (checkpointer *DynamoCheckpoint) updateSticky(shardID, updateExpression string, expressionAttributeValues map[string]types.AttributeValue) error {
+	if strings.TrimSpace(shardID) == "" {
+		return ErrInvalidShardID
+	}
+
+	err := checkpointer.updateItem(shardID, updateExpression, "attribute_exists("+LeaseKeyKey+")", expressionAttributeValues)
+
+	var conditionalCheckErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckErr) {
+		return ErrShardNotFound
+	}
+
+	if err == nil {
+		checkpointer.log.Infof("Sticky columns of shard %s updated: %s", shardID, updateExpression)
+	}
+	return err
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+
+	cfg "github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func newStickyAdminTestCheckpoint(svc *mockDynamoDB) *DynamoCheckpoint {
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(cfg.LATEST).
+		WithFailoverTimeMillis(300000)
+
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+	_ = checkpoint.Init()
+	return checkpoint
+}
+
+// stickyColumns reads the sticky columns of a lease entry into a new shard status
+func stickyColumns(item map[string]types.AttributeValue) *par.ShardStatus {
+	shard := &par.ShardStatus{Mux: &sync.RWMutex{}}
+	readStickyColumns(shard, item)
+	return shard
+}
+
+func TestPinShard(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:   &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey: &types.AttributeValueMemberS{Value: "abcd-efgh"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+
+	err := checkpoint.PinShard("0001", "ijkl-mnop")
+	assert.Nil(t, err)
+
+	shard := stickyColumns(svc.item)
+	assert.Equal(t, par.StickyPinned, shard.GetStickyState())
+	assert.Equal(t, "ijkl-mnop", shard.GetStickyWorker())
+	assert.Equal(t, "attribute_exists(ShardID)", *svc.updateItemInput.ConditionExpression)
+
+	// pinning to the current owner drops any previous StickyWorker binding
+	err = checkpoint.PinShard("0001", "")
+	assert.Nil(t, err)
+
+	shard = stickyColumns(svc.item)
+	assert.Equal(t, par.StickyPinned, shard.GetStickyState())
+	assert.Equal(t, "", shard.GetStickyWorker())
+}
+
+func TestPinShardUntil(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:   &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey: &types.AttributeValueMemberS{Value: "abcd-efgh"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+
+	expiry := time.Now().Add(time.Hour).UTC()
+	err := checkpoint.PinShardUntil("0001", "abcd-efgh", expiry)
+	assert.Nil(t, err)
+
+	shard := stickyColumns(svc.item)
+	assert.Equal(t, par.StickyPinned, shard.GetStickyState())
+	assert.True(t, expiry.Equal(shard.GetStickyExpiry()))
+
+	// an expired pin is back to normal assignment
+	err = checkpoint.PinShardUntil("0001", "abcd-efgh", time.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	shard = stickyColumns(svc.item)
+	assert.Equal(t, 10, shard.GetSticky())
+	assert.Equal(t, par.StickyNormal, shard.GetStickyState())
+
+	// pinning without expiry removes the previous expiry
+	err = checkpoint.PinShard("0001", "abcd-efgh")
+	assert.Nil(t, err)
+	_, hasStickyExpiry := svc.item[StickyExpiryKey]
+	assert.False(t, hasStickyExpiry)
+
+	// pinning to a worker removes the worker group
+	assert.Nil(t, checkpoint.PinShardToGroup("0001", "zone=us-west-2a"))
+	assert.Nil(t, checkpoint.PinShardUntil("0001", "abcd-efgh", expiry))
+	_, hasStickyGroup := svc.item[StickyGroupKey]
+	assert.False(t, hasStickyGroup)
+}
+
+func TestPinShardToGroup(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:     &types.AttributeValueMemberS{Value: "0001"},
+			StickyWorkerKey: &types.AttributeValueMemberS{Value: "abcd-efgh"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+
+	err := checkpoint.PinShardToGroup("0001", "zone=us-west-2a, tier!=spot")
+	assert.Nil(t, err)
+
+	shard := stickyColumns(svc.item)
+	assert.Equal(t, par.StickyPinned, shard.GetStickyState())
+	assert.Equal(t, "tier!=spot,zone=us-west-2a", shard.GetStickyGroup())
+	assert.Equal(t, "", shard.GetStickyWorker())
+
+	// pinning to a worker group removes the expiry of a previous pin
+	assert.Nil(t, checkpoint.PinShardUntil("0001", "abcd-efgh", time.Now().Add(time.Hour)))
+	assert.Nil(t, checkpoint.PinShardToGroup("0001", "zone=us-west-2a, tier!=spot"))
+	_, hasStickyExpiry := svc.item[StickyExpiryKey]
+	assert.False(t, hasStickyExpiry)
+
+	svc.updateItemInput = nil
+	assert.True(t, errors.Is(checkpoint.PinShardToGroup("0001", "zone=="), cfg.ErrInvalidLabelSelector))
+	assert.True(t, errors.Is(checkpoint.PinShardToGroup("0001", " "), cfg.ErrInvalidLabelSelector))
+	assert.Nil(t, svc.updateItemInput)
+
+	err = checkpoint.UnpinShard("0001")
+	assert.Nil(t, err)
+	_, hasStickyGroup := svc.item[StickyGroupKey]
+	assert.False(t, hasStickyGroup)
+}
+
+func TestUnpinShardAndRequestRelease(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist:     true,
+		evalConditions: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:     &types.AttributeValueMemberS{Value: "0001"},
+			StickyKey:       &types.AttributeValueMemberN{Value: "10"},
+			StickyWorkerKey: &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			StickyExpiryKey: &types.AttributeValueMemberS{Value: "2030-01-01T00:00:00Z"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+
+	err := checkpoint.UnpinShard("0001")
+	assert.Nil(t, err)
+
+	_, hasSticky := svc.item[StickyKey]
+	_, hasStickyWorker := svc.item[StickyWorkerKey]
+	_, hasStickyExpiry := svc.item[StickyExpiryKey]
+	assert.False(t, hasSticky)
+	assert.False(t, hasStickyWorker)
+	assert.False(t, hasStickyExpiry)
+
+	err = checkpoint.RequestShardRelease("0001")
+	assert.Nil(t, err)
+
+	assert.Equal(t, par.StickyRelease, stickyColumns(svc.item).GetStickyState())
+}
+
+func TestStickyAdminInvalidShard(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: true, evalConditions: true, item: map[string]types.AttributeValue{}}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+
+	assert.Equal(t, ErrInvalidShardID, checkpoint.PinShard(" ", "abcd-efgh"))
+	assert.Equal(t, ErrInvalidShardID, checkpoint.PinShardUntil("", "abcd-efgh", time.Now()))
+	assert.Nil(t, svc.updateItemInput)
+
+	// the lease table has no entry for the shard, so no row must be created
+	assert.Equal(t, ErrShardNotFound, checkpoint.RequestShardRelease("0002"))
+	assert.Empty(t, svc.item)
+}
+
+func TestListPinnedShards(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item:       map[string]types.AttributeValue{},
+		scanItems: []map[string]types.AttributeValue{
+			{
+				LeaseKeyKey:     &types.AttributeValueMemberS{Value: "0001"},
+				LeaseOwnerKey:   &types.AttributeValueMemberS{Value: "abcd-efgh"},
+				StickyKey:       &types.AttributeValueMemberN{Value: "10"},
+				StickyWorkerKey: &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			},
+			{
+				LeaseKeyKey: &types.AttributeValueMemberS{Value: "0002"},
+				StickyKey:   &types.AttributeValueMemberN{Value: "20"},
+			},
+			{
+				LeaseKeyKey: &types.AttributeValueMemberS{Value: "0003"},
+			},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+
+	shards, err := checkpoint.ListPinnedShards()
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(shards)) {
+		assert.Equal(t, "0001", shards[0].ID)
+		assert.Equal(t, "abcd-efgh", shards[0].GetLeaseOwner())
+		assert.Equal(t, par.StickyPinned, shards[0].GetStickyState())
+		assert.Equal(t, "abcd-efgh", shards[0].GetStickyWorker())
+	}
+}
+
+func TestCheckpointSequencePreservesSticky(t *testing.T) {
+	svc := &mockDynamoDB{
+		tableExist: true,
+		item: map[string]types.AttributeValue{
+			LeaseKeyKey:     &types.AttributeValueMemberS{Value: "0001"},
+			LeaseOwnerKey:   &types.AttributeValueMemberS{Value: "abcd-efgh"},
+			StickyKey:       &types.AttributeValueMemberN{Value: "20"},
+			ClaimRequestKey: &types.AttributeValueMemberS{Value: "ijkl-mnop"},
+		},
+	}
+	checkpoint := newStickyAdminTestCheckpoint(svc)
+
+	// the worker still caches the sticky value it read before the release was requested
+	shard := &par.ShardStatus{
+		ID:           "0001",
+		AssignedTo:   "abcd-efgh",
+		Checkpoint:   "deadbeef",
+		LeaseTimeout: time.Now(),
+		Sticky:       -1,
+		Mux:          &sync.RWMutex{},
+	}
+	err := checkpoint.CheckpointSequence(shard)
+	assert.Nil(t, err)
+
+	assert.Equal(t, par.StickyRelease, stickyColumns(svc.item).GetStickyState())
+	assert.Equal(t, "deadbeef", svc.item[SequenceNumberKey].(*types.AttributeValueMemberS).Value)
+	_, hasClaimRequest := svc.item[ClaimRequestKey]
+	assert.False(t, hasClaimRequest)
+}
+
+// pinningDynamoDB pins the shard right after its lease row is read, like an operator racing with the worker
+type pinningDynamoDB struct {
+	leaseRowDynamoDB
+	pin func(item map[string]types.AttributeValue)
+}
+
+func (m *pinningDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	output, err := m.leaseRowDynamoDB.GetItem(ctx, params, optFns...)
+	if m.pin != nil {
+		m.pin(m.item)
+		m.pin = nil
+	}
+	return output, err
+}
+
+func TestGetLeaseKeepsConcurrentPin(t *testing.T) {
+	svc := &pinningDynamoDB{leaseRowDynamoDB: leaseRowDynamoDB{item: map[string]types.AttributeValue{
+		LeaseKeyKey:       &types.AttributeValueMemberS{Value: "0001"},
+		StickyKey:         &types.AttributeValueMemberN{Value: "10"},
+		StickyGroupKey:    &types.AttributeValueMemberS{Value: "zone=us-west-2a"},
+		SequenceNumberKey: &types.AttributeValueMemberS{Value: "100"},
+	}}}
+	kclConfig := cfg.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpoint := NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc)
+
+	// the lease write carrying the sticky columns it read fails instead of overwriting the new pin
+	svc.pin = func(item map[string]types.AttributeValue) {
+		item[StickyWorkerKey] = &types.AttributeValueMemberS{Value: "ijkl-mnop"}
+		delete(item, StickyGroupKey)
+	}
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.IsType(t, ErrLeaseNotAcquired{}, checkpoint.GetLease(shard, "abcd-efgh"))
+	assert.Equal(t, "ijkl-mnop", stickyColumns(svc.item).GetStickyWorker())
+	_, hasOwner := svc.item[LeaseOwnerKey]
+	assert.False(t, hasOwner)
+
+	// the next attempt reads the new pin and keeps it
+	assert.Nil(t, checkpoint.GetLease(shard, "abcd-efgh"))
+	assert.Equal(t, "abcd-efgh", shard.GetLeaseOwner())
+	assert.Equal(t, "ijkl-mnop", shard.GetStickyWorker())
+	pinned := stickyColumns(svc.item)
+	assert.Equal(t, par.StickyPinned, pinned.GetStickyState())
+	assert.Equal(t, "ijkl-mnop", pinned.GetStickyWorker())
+	assert.Empty(t, pinned.GetStickyGroup())
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package config
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidLabelSelector is returned when a label selector cannot be parsed
+var ErrInvalidLabelSelector = errors.New("InvalidLabelSelector")
+
+// ErrInvalidShardAffinityRule is returned when a shard affinity rule cannot be compiled
+var ErrInvalidShardAffinityRule = errors.New("InvalidShardAffinityRule")
+
+type (
+	// LabelSelector selects workers by their labels. It is parsed from a comma separated list of requirements:
+	// "key=value" (the label is set to value), "key!=value" (the label is not set to value) and "key" (the label
+	// is set). All requirements must match. An empty selector matches every worker.
+	LabelSelector []labelRequirement
+
+	labelRequirement struct {
+		key      string
+		value    string
+		operator string
+	}
+
+	// ShardAffinityRule restricts the shards it matches to the workers whose labels match Selector.
+	// A shard matches if its ID matches ShardIDPattern (a regular expression) and its starting hash key lies
+	// within [StartingHashKey, EndingHashKey]. Empty criteria match every shard.
+	ShardAffinityRule struct {
+		ShardIDPattern  string
+		StartingHashKey string
+		EndingHashKey   string
+		Selector        string
+	}
+
+	// ShardAffinity is the compiled form of a list of shard affinity rules. The first matching rule applies,
+	// shards matching no rule can be processed by any worker.
+	ShardAffinity struct {
+		rules []shardAffinityRule
+	}
+
+	shardAffinityRule struct {
+		shardID         *regexp.Regexp
+		startingHashKey *big.Int
+		endingHashKey   *big.Int
+		selector        LabelSelector
+	}
+)
+
+const (
+	labelOpEquals    = "="
+	labelOpNotEquals = "!="
+	labelOpExists    = ""
+)
+
+// ParseLabelSelector parses a label selector such as "zone=us-west-2a,tier!=spot"
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var requirements LabelSelector
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		requirement := labelRequirement{key: term, operator: labelOpExists}
+		if i := strings.Index(term, labelOpNotEquals); i >= 0 {
+			requirement = labelRequirement{key: term[:i], value: term[i+2:], operator: labelOpNotEquals}
+		} else if i := strings.Index(term, labelOpEquals); i >= 0 {
+			requirement = labelRequirement{key: term[:i], value: term[i+1:], operator: labelOpEquals}
+		}
+
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if requirement.key == "" || strings.ContainsAny(requirement.key+requirement.value, "=!") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidLabelSelector, selector)
+		}
+		requirements = append(requirements, requirement)
+	}
+
+	return requirements, nil
+}
+
+// Matches returns true if the labels satisfy every requirement of the selector
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		value, ok := labels[requirement.key]
+		switch requirement.operator {
+		case labelOpEquals:
+			if !ok || value != requirement.value {
+				return false
+			}
+		case labelOpNotEquals:
+			if ok && value == requirement.value {
+				return false
+			}
+		default:
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s LabelSelector) String() string {
+	terms := make([]string, 0, len(s))
+	for _, requirement := range s {
+		if requirement.operator == labelOpExists {
+			terms = append(terms, requirement.key)
+		} else {
+			terms = append(terms, requirement.key+requirement.operator+requirement.value)
+		}
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, ",")
+}
+
+// NewShardAffinity validates and compiles the given shard affinity rules
+func NewShardAffinity(rules []ShardAffinityRule) (*ShardAffinity, error) {
+	affinity := &ShardAffinity{}
+	for i, rule := range rules {
+		compiled, err := compileShardAffinityRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("%w: rule %d: %v", ErrInvalidShardAffinityRule, i, err)
+		}
+		affinity.rules = append(affinity.rules, compiled)
+	}
+	return affinity, nil
+}
+
+// SelectorFor returns the selector of the first rule matching the shard, or false if no rule matches
+func (a *ShardAffinity) SelectorFor(shardID, startingHashKey string) (LabelSelector, bool) {
+	if a == nil {
+		return nil, false
+	}
+
+	var hashKey *big.Int
+	if startingHashKey != "" {
+		hashKey, _ = new(big.Int).SetString(startingHashKey, 10)
+	}
+
+	for _, rule := range a.rules {
+		if rule.shardID != nil && !rule.shardID.MatchString(shardID) {
+			continue
+		}
+		if rule.startingHashKey != nil || rule.endingHashKey != nil {
+			// the hash key range of the shard is unknown, so it cannot match a hash key rule
+			if hashKey == nil {
+				continue
+			}
+			if rule.startingHashKey != nil && hashKey.Cmp(rule.startingHashKey) < 0 {
+				continue
+			}
+			if rule.endingHashKey != nil && hashKey.Cmp(rule.endingHashKey) > 0 {
+				continue
+			}
+		}
+		return rule.selector, true
+	}
+
+	return nil, false
+}
+
+func compileShardAffinityRule(rule ShardAffinityRule) (shardAffinityRule, error) {
+	var compiled shardAffinityRule
+	var err error
+
+	if compiled.selector, err = ParseLabelSelector(rule.Selector); err != nil {
+		return compiled, err
+	}
+
+	if rule.ShardIDPattern != "" {
+		if compiled.shardID, err = regexp.Compile(rule.ShardIDPattern); err != nil {
+			return compiled, err
+		}
+	}
+
+	if rule.StartingHashKey != "" {
+		var ok bool
+		if compiled.startingHashKey, ok = new(big.Int).SetString(rule.StartingHashKey, 10); !ok {
+			return compiled, fmt.Errorf("invalid starting hash key %q", rule.StartingHashKey)
+		}
+	}
+
+	if rule.EndingHashKey != "" {
+		var ok bool
+		if compiled.endingHashKey, ok = new(big.Int).SetString(rule.EndingHashKey, 10); !ok {
+			return compiled, fmt.Errorf("invalid ending hash key %q", rule.EndingHashKey)
+		}
+	}
+
+	if compiled.startingHashKey != nil && compiled.endingHashKey != nil && compiled.startingHashKey.Cmp(compiled.endingHashKey) > 0 {
+		return compiled, fmt.Errorf("starting hash key %s is greater than ending hash key %s", rule.StartingHashKey, rule.EndingHashKey)
+	}
+
+	return compiled, nil
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"zone": "us-west-2a", "tier": "ondemand"}
+
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"zone=us-west-2a", true},
+		{"zone=us-west-2a, tier=ondemand", true},
+		{"zone=us-west-2b", false},
+		{"tier!=spot", true},
+		{"tier!=ondemand", false},
+		{"version!=v2", true},
+		{"zone", true},
+		{"version", false},
+	}
+
+	for _, tt := range tests {
+		selector, err := ParseLabelSelector(tt.selector)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.matches, selector.Matches(labels), tt.selector)
+	}
+
+	for _, invalid := range []string{"=value", "zone==a", "zone=a=b", "!=spot"} {
+		_, err := ParseLabelSelector(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidLabelSelector), invalid)
+	}
+}
+
+func TestShardAffinity(t *testing.T) {
+	affinity, err := NewShardAffinity([]ShardAffinityRule{
+		{ShardIDPattern: "^shardId-00000000000[0-4]$", Selector: "tier=ondemand"},
+		{StartingHashKey: "0", EndingHashKey: "170141183460469231731687303715884105727", Selector: "zone=us-west-2a"},
+	})
+	assert.Nil(t, err)
+
+	selector, ok := affinity.SelectorFor("shardId-000000000001", "")
+	assert.True(t, ok)
+	assert.Equal(t, "tier=ondemand", selector.String())
+
+	selector, ok = affinity.SelectorFor("shardId-000000000007", "85070591730234615865843651857942052864")
+	assert.True(t, ok)
+	assert.Equal(t, "zone=us-west-2a", selector.String())
+
+	_, ok = affinity.SelectorFor("shardId-000000000007", "255211775190703847597530955573826158592")
+	assert.False(t, ok)
+
+	// unknown hash key range never matches a hash key rule
+	_, ok = affinity.SelectorFor("shardId-000000000007", "")
+	assert.False(t, ok)
+}
+
+func TestShardAffinityInvalidRules(t *testing.T) {
+	for _, rule := range []ShardAffinityRule{
+		{ShardIDPattern: "(", Selector: "tier=ondemand"},
+		{StartingHashKey: "abc", Selector: "tier=ondemand"},
+		{StartingHashKey: "10", EndingHashKey: "1", Selector: "tier=ondemand"},
+		{Selector: "tier=="},
+	} {
+		_, err := NewShardAffinity([]ShardAffinityRule{rule})
+		assert.True(t, errors.Is(err, ErrInvalidShardAffinityRule), "%+v", rule)
+	}
+
+	kclConfig := NewKinesisClientLibConfig("appName", "StreamName", "us-west-2", "workerId")
+	assert.Panics(t, func() { kclConfig.WithShardAffinityRules(ShardAffinityRule{ShardIDPattern: "("}) })
+}
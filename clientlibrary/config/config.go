@@ -55,7 +55,18 @@ const (
 	TRIM_HORIZON
 	// AT_TIMESTAMP start from the record at or after the specified server-side Timestamp.
 	AT_TIMESTAMP
+)
+
+const (
+	// LeaseTableSchemaKCLGo the lease table layout of this library: ShardID, AssignedTo, LeaseTimeout, Checkpoint...
+	LeaseTableSchemaKCLGo LeaseTableSchema = iota + 1
+	// LeaseTableSchemaJava the lease table layout of the Java KCL 2.x: leaseKey, leaseOwner, leaseCounter,
+	// checkpoint... Leases are always counter-based like in the Java KCL so a Go application can take over the lease
+	// table of a Java application.
+	LeaseTableSchemaJava
+)
 
+const (
 	// DefaultInitialPositionInStream The location in the shard from which the KinesisClientLibrary will start fetching records from
 	// when the application starts for the first time and there is no checkpoint for the shard.
 	DefaultInitialPositionInStream = LATEST
@@ -98,10 +109,14 @@ const (
 	// DefaultTaskBackoffTimeMillis Backoff time in milliseconds for Amazon Kinesis Client Library tasks (in the event of failures).
 	DefaultTaskBackoffTimeMillis = 500
 
-	// DefaultValidateSequenceNumberBeforeCheckpointing KCL will validate client provided sequence numbers with a call to Amazon Kinesis before
-	// checkpointing for calls to {@link RecordProcessorCheckpointer#checkpoint(String)} by default.
+	// DefaultValidateSequenceNumberBeforeCheckpointing KCL will validate client provided sequence numbers against the last checkpoint
+	// and the largest sequence number delivered to the record processor before checkpointing by default.
 	DefaultValidateSequenceNumberBeforeCheckpointing = true
 
+	// DefaultValidateSequenceNumberWithKinesis KCL doesn't check with Amazon Kinesis that a checkpointed sequence number
+	// belongs to the shard by default, as it costs a GetShardIterator call per checkpoint.
+	DefaultValidateSequenceNumberWithKinesis = false
+
 	// DefaultMaxLeasesForWorker The max number of leases (shards) this worker should process.
 	// This can be useful to avoid overloading (and thrashing) a worker when a host has resource constraints
 	// or during deployment.
@@ -137,11 +152,32 @@ const (
 	// DefaultLeaseStealingClaimTimeoutMillis Number of milliseconds to wait before another worker can aquire a claimed shard
 	DefaultLeaseStealingClaimTimeoutMillis = 120000
 
+	// DefaultStickyFailoverMillis Sticky failover is disabled by default: a shard pinned to a worker waits for that worker forever.
+	DefaultStickyFailoverMillis = 0
+
 	// DefaultLeaseSyncingIntervalMillis Number of milliseconds to wait before syncing with lease table (dynamodDB)
 	DefaultLeaseSyncingIntervalMillis = 60000
 
 	// DefaultMaxRetryCount The default maximum number of retries in case of error
 	DefaultMaxRetryCount = 5
+
+	// DefaultLeaseTableSchema The lease table layout of this library
+	DefaultLeaseTableSchema = LeaseTableSchemaKCLGo
+
+	// DefaultCounterBasedLeases Leases expire at their LeaseTimeout by default for backwards compatibility.
+	DefaultCounterBasedLeases = false
+
+	// DefaultLeaseTableActiveTimeoutMillis Number of milliseconds to wait for the lease table to become ACTIVE
+	DefaultLeaseTableActiveTimeoutMillis = 300000
+
+	// DefaultLeaseTableScanSegments The lease table is scanned sequentially by default
+	DefaultLeaseTableScanSegments = 1
+
+	// DefaultLeaseTableConsistentScans The lease table is scanned with eventually consistent reads by default
+	DefaultLeaseTableConsistentScans = false
+
+	// DefaultLeaseOwnerIndex The lease table has no index on the lease owner by default
+	DefaultLeaseOwnerIndex = false
 )
 
 type (
@@ -149,6 +185,9 @@ type (
 	// This is used during initial application bootstrap (when a checkpoint doesn't exist for a shard or its parents)
 	InitialPositionInStream int
 
+	// LeaseTableSchema Used to specify the attribute names and lease semantics of the lease table
+	LeaseTableSchema int
+
 	// InitialPositionInStreamExtended Class that houses the entities needed to specify the Position in the stream from where a new application should
 	// start.
 	InitialPositionInStreamExtended struct {
@@ -164,6 +203,46 @@ type (
 		Timestamp *time.Time `type:"Timestamp" timestampFormat:"unix"`
 	}
 
+	// CheckpointPolicy Automatic checkpointing done by the shard consumer on behalf of the record processor.
+	// Only the records of batches the record processor returned from are checkpointed, batches failed by an
+	// IRecordProcessorWithError never are. The zero value disables automatic checkpointing.
+	CheckpointPolicy struct {
+		// AfterEachBatch checkpoints the last record of each batch once ProcessRecords returned
+		AfterEachBatch bool
+
+		// EveryRecords checkpoints once that many records were processed since the last checkpoint, 0 disables it
+		EveryRecords int
+
+		// IntervalMillis checkpoints the processed records once that many milliseconds elapsed since the last
+		// checkpoint, 0 disables it
+		IntervalMillis int
+
+		// OnShutdown checkpoints the processed records when the record processor is shut down, and the end of the
+		// shard (SHARD_END) when it was fully processed
+		OnShutdown bool
+	}
+
+	// LeaseTableOptions Options of the lease table (and checkpoint history table) created by the worker. The zero value
+	// creates a PROVISIONED table with the default encryption.
+	LeaseTableOptions struct {
+		// PayPerRequest creates the table with on-demand (PAY_PER_REQUEST) billing instead of the provisioned
+		// InitialLeaseTableReadCapacity and InitialLeaseTableWriteCapacity
+		PayPerRequest bool
+
+		// Tags of the table
+		Tags map[string]string
+
+		// KMSKeyID encrypts the table with this KMS key (ID, ARN or alias, e.g. alias/aws/dynamodb for the AWS managed
+		// key). Empty keeps the default encryption with a key owned by DynamoDB.
+		KMSKeyID string
+
+		// PointInTimeRecovery turns on the continuous backups of the table
+		PointInTimeRecovery bool
+
+		// DeletionProtection prevents the deletion of the table
+		DeletionProtection bool
+	}
+
 	// KinesisClientLibConfiguration Configuration for the Kinesis Client Library.
 	// Note: There is no need to configure credential provider. Credential can be get from InstanceProfile.
 	KinesisClientLibConfiguration struct {
@@ -251,6 +330,10 @@ type (
 		// ValidateSequenceNumberBeforeCheckpointing whether KCL should validate client provided sequence numbers
 		ValidateSequenceNumberBeforeCheckpointing bool
 
+		// ValidateSequenceNumberWithKinesis whether KCL should also validate client provided sequence numbers
+		// with a GetShardIterator call to Amazon Kinesis. Requires ValidateSequenceNumberBeforeCheckpointing.
+		ValidateSequenceNumberWithKinesis bool
+
 		// RegionName The region name for the service
 		RegionName string
 
@@ -271,6 +354,12 @@ type (
 		// Write capacity to provision when creating the lease table.
 		InitialLeaseTableWriteCapacity int
 
+		// LeaseTableOptions Billing, tags, encryption and backups of the lease table when the worker creates it
+		LeaseTableOptions LeaseTableOptions
+
+		// LeaseTableActiveTimeoutMillis The number of milliseconds to wait for the lease table to become ACTIVE
+		LeaseTableActiveTimeoutMillis int
+
 		// Worker should skip syncing shards and leases at startup if leases are present
 		// This is useful for optimizing deployments to large fleets working on a stable stream.
 		SkipShardSyncAtWorkerInitializationIfLeasesExist bool
@@ -290,9 +379,43 @@ type (
 		// LeaseStealingClaimTimeoutMillis The number of milliseconds to wait before another worker can aquire a claimed shard
 		LeaseStealingClaimTimeoutMillis int
 
+		// StickyFailoverMillis The number of milliseconds a pinned shard waits for its StickyWorker to renew its lease
+		// after the lease expired. Once exceeded, other workers may temporarily acquire the shard; it is handed back
+		// as soon as the pinned worker returns. 0 disables the failover.
+		StickyFailoverMillis int
+
+		// WorkerLabels Labels of this worker (e.g. zone, tier, version) matched against the label selectors of
+		// shards pinned to a group (StickyGroup) and of ShardAffinityRules
+		WorkerLabels map[string]string
+
+		// ShardAffinityRules Restrict the shards matching a rule to the workers whose labels match its selector
+		ShardAffinityRules []ShardAffinityRule
+
+		// CheckpointPolicy Automatic checkpointing of the records processed by the record processors
+		CheckpointPolicy CheckpointPolicy
+
+		// LeaseTableSchema The layout of the lease table, LeaseTableSchemaJava to share it with the Java KCL
+		LeaseTableSchema LeaseTableSchema
+
+		// CounterBasedLeases Judge lease expiry from a lease counter incremented by every renewal, which a worker
+		// must see unchanged for FailoverTimeMillis on its own clock, rather than from the LeaseTimeout written by
+		// the lease owner. This tolerates clock skew between the hosts. All workers of an application must agree.
+		CounterBasedLeases bool
+
 		// LeaseSyncingTimeInterval The number of milliseconds to wait before syncing with lease table (dynamoDB)
 		LeaseSyncingTimeIntervalMillis int
 
+		// LeaseTableScanSegments The number of segments the lease table is scanned in parallel with when syncing
+		LeaseTableScanSegments int
+
+		// LeaseTableConsistentScans Scan the lease table with strongly consistent reads, at twice the read capacity.
+		// Leases are always acquired from a strongly consistent read of their row.
+		LeaseTableConsistentScans bool
+
+		// LeaseOwnerIndex Create and maintain a global secondary index of the lease table on AssignedTo, so that
+		// the leases of one worker are listed with a query instead of a scan of the whole table
+		LeaseOwnerIndex bool
+
 		// MaxRetryCount The maximum number of retries in case of error
 		MaxRetryCount int
 	}
@@ -327,3 +450,11 @@ func checkIsValuePositive(key string, value int) {
 		log.Panicf("Positive value expected for %v, actual: %v", key, value)
 	}
 }
+
+// checkIsValueNotNegative makes sure the value is zero or positive.
+func checkIsValueNotNegative(key string, value int) {
+	if value < 0 {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Non-negative value expected for %v, actual: %v", key, value)
+	}
+}
@@ -45,6 +45,9 @@ func TestConfig(t *testing.T) {
 
 	assert.Equal(t, false, kclConfig.EnableLeaseStealing)
 	assert.Equal(t, 5000, kclConfig.LeaseStealingIntervalMillis)
+	assert.Equal(t, 0, kclConfig.StickyFailoverMillis)
+	assert.True(t, kclConfig.ValidateSequenceNumberBeforeCheckpointing)
+	assert.False(t, kclConfig.ValidateSequenceNumberWithKinesis)
 
 	contextLogger := kclConfig.Logger.WithFields(logger.Fields{"key1": "value1"})
 	contextLogger.Debugf("Starting with default logger")
@@ -73,6 +76,14 @@ func TestConfigLeaseStealing(t *testing.T) {
 	contextLogger.Infof("Default logger is awesome")
 }
 
+func TestConfigStickyFailover(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "StreamName", "us-west-2", "workerId").
+		WithStickyFailoverMillis(30000)
+
+	assert.Equal(t, 30000, kclConfig.StickyFailoverMillis)
+	assert.Panics(t, func() { kclConfig.WithStickyFailoverMillis(-1) })
+}
+
 func TestConfigDefaultEnhancedFanOutConsumerName(t *testing.T) {
 	kclConfig := NewKinesisClientLibConfig("appName", "StreamName", "us-west-2", "workerId")
 
@@ -100,3 +111,14 @@ func TestEmptyEnhancedFanOutConsumerARN(t *testing.T) {
 		NewKinesisClientLibConfig("app", "stream", "us-west-2", "worker").WithEnhancedFanOutConsumerARN("")
 	})
 }
+
+func TestConfigCheckpointPolicy(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "StreamName", "us-west-2", "workerId")
+	assert.Equal(t, CheckpointPolicy{}, kclConfig.CheckpointPolicy)
+
+	kclConfig.WithCheckpointPolicy(CheckpointPolicy{EveryRecords: 100, IntervalMillis: 5000, OnShutdown: true})
+	assert.Equal(t, 100, kclConfig.CheckpointPolicy.EveryRecords)
+	assert.Equal(t, 5000, kclConfig.CheckpointPolicy.IntervalMillis)
+	assert.True(t, kclConfig.CheckpointPolicy.OnShutdown)
+	assert.Panics(t, func() { kclConfig.WithCheckpointPolicy(CheckpointPolicy{EveryRecords: -1}) })
+}
@@ -92,18 +92,26 @@ func NewKinesisClientLibConfigWithCredentials(applicationName, streamName, regio
 		CleanupTerminatedShardsBeforeExpiry:              DefaultCleanupLeasesUponShardsCompletion,
 		TaskBackoffTimeMillis:                            DefaultTaskBackoffTimeMillis,
 		ValidateSequenceNumberBeforeCheckpointing:        DefaultValidateSequenceNumberBeforeCheckpointing,
+		ValidateSequenceNumberWithKinesis:                DefaultValidateSequenceNumberWithKinesis,
 		ShutdownGraceMillis:                              DefaultShutdownGraceMillis,
 		MaxLeasesForWorker:                               DefaultMaxLeasesForWorker,
 		MaxLeasesToStealAtOneTime:                        DefaultMaxLeasesToStealAtOneTime,
 		InitialLeaseTableReadCapacity:                    DefaultInitialLeaseTableReadCapacity,
 		InitialLeaseTableWriteCapacity:                   DefaultInitialLeaseTableWriteCapacity,
+		LeaseTableActiveTimeoutMillis:                    DefaultLeaseTableActiveTimeoutMillis,
 		SkipShardSyncAtWorkerInitializationIfLeasesExist: DefaultSkipShardSyncAtStartupIfLeasesExist,
 		EnableLeaseStealing:                              DefaultEnableLeaseStealing,
 		LeaseStealingIntervalMillis:                      DefaultLeaseStealingIntervalMillis,
 		LeaseStealingClaimTimeoutMillis:                  DefaultLeaseStealingClaimTimeoutMillis,
+		StickyFailoverMillis:                             DefaultStickyFailoverMillis,
 		LeaseSyncingTimeIntervalMillis:                   DefaultLeaseSyncingIntervalMillis,
+		LeaseTableScanSegments:                           DefaultLeaseTableScanSegments,
+		LeaseTableConsistentScans:                        DefaultLeaseTableConsistentScans,
+		LeaseOwnerIndex:                                  DefaultLeaseOwnerIndex,
 		LeaseRefreshWaitTime:                             DefaultLeaseRefreshWaitTime,
 		MaxRetryCount:                                    DefaultMaxRetryCount,
+		LeaseTableSchema:                                 DefaultLeaseTableSchema,
+		CounterBasedLeases:                               DefaultCounterBasedLeases,
 		Logger:                                           logger.GetDefaultLogger(),
 	}
 }
@@ -205,6 +213,19 @@ func (c *KinesisClientLibConfiguration) WithCallProcessRecordsEvenForEmptyRecord
 	return c
 }
 
+// WithValidateSequenceNumberBeforeCheckpointing enables or disables the validation of checkpointed sequence numbers
+func (c *KinesisClientLibConfiguration) WithValidateSequenceNumberBeforeCheckpointing(validate bool) *KinesisClientLibConfiguration {
+	c.ValidateSequenceNumberBeforeCheckpointing = validate
+	return c
+}
+
+// WithValidateSequenceNumberWithKinesis enables or disables the check with Amazon Kinesis that a checkpointed
+// sequence number belongs to the shard
+func (c *KinesisClientLibConfiguration) WithValidateSequenceNumberWithKinesis(validate bool) *KinesisClientLibConfiguration {
+	c.ValidateSequenceNumberWithKinesis = validate
+	return c
+}
+
 func (c *KinesisClientLibConfiguration) WithTaskBackoffTimeMillis(taskBackoffTimeMillis int) *KinesisClientLibConfiguration {
 	checkIsValuePositive("TaskBackoffTimeMillis", taskBackoffTimeMillis)
 	c.TaskBackoffTimeMillis = taskBackoffTimeMillis
@@ -272,6 +293,81 @@ func (c *KinesisClientLibConfiguration) WithLeaseStealingIntervalMillis(leaseSte
 	return c
 }
 
+// WithStickyFailoverMillis sets how long a pinned shard waits for its StickyWorker before other workers may take it over
+func (c *KinesisClientLibConfiguration) WithStickyFailoverMillis(stickyFailoverMillis int) *KinesisClientLibConfiguration {
+	checkIsValueNotNegative("StickyFailoverMillis", stickyFailoverMillis)
+	c.StickyFailoverMillis = stickyFailoverMillis
+	return c
+}
+
+// WithLeaseTableOptions sets the billing, tags, encryption and backups of the lease table created by the worker
+func (c *KinesisClientLibConfiguration) WithLeaseTableOptions(options LeaseTableOptions) *KinesisClientLibConfiguration {
+	c.LeaseTableOptions = options
+	return c
+}
+
+// WithLeaseTableActiveTimeoutMillis sets how long to wait for the lease table to become ACTIVE
+func (c *KinesisClientLibConfiguration) WithLeaseTableActiveTimeoutMillis(timeoutMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseTableActiveTimeoutMillis", timeoutMillis)
+	c.LeaseTableActiveTimeoutMillis = timeoutMillis
+	return c
+}
+
+// WithLeaseTableScanSegments sets the number of segments the lease table is scanned in parallel with
+func (c *KinesisClientLibConfiguration) WithLeaseTableScanSegments(segments int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseTableScanSegments", segments)
+	c.LeaseTableScanSegments = segments
+	return c
+}
+
+// WithLeaseTableConsistentScans scans the lease table with strongly consistent reads
+func (c *KinesisClientLibConfiguration) WithLeaseTableConsistentScans(consistentScans bool) *KinesisClientLibConfiguration {
+	c.LeaseTableConsistentScans = consistentScans
+	return c
+}
+
+// WithLeaseOwnerIndex turns on the global secondary index of the lease table on the lease owner
+func (c *KinesisClientLibConfiguration) WithLeaseOwnerIndex(leaseOwnerIndex bool) *KinesisClientLibConfiguration {
+	c.LeaseOwnerIndex = leaseOwnerIndex
+	return c
+}
+
+// WithLeaseTableSchema sets the layout of the lease table
+func (c *KinesisClientLibConfiguration) WithLeaseTableSchema(schema LeaseTableSchema) *KinesisClientLibConfiguration {
+	c.LeaseTableSchema = schema
+	return c
+}
+
+// WithCounterBasedLeases turns on counter-based leases, which tolerate clock skew between the workers
+func (c *KinesisClientLibConfiguration) WithCounterBasedLeases(counterBasedLeases bool) *KinesisClientLibConfiguration {
+	c.CounterBasedLeases = counterBasedLeases
+	return c
+}
+
+// WithCheckpointPolicy sets the automatic checkpointing done on behalf of the record processors
+func (c *KinesisClientLibConfiguration) WithCheckpointPolicy(policy CheckpointPolicy) *KinesisClientLibConfiguration {
+	checkIsValueNotNegative("CheckpointPolicy.EveryRecords", policy.EveryRecords)
+	checkIsValueNotNegative("CheckpointPolicy.IntervalMillis", policy.IntervalMillis)
+	c.CheckpointPolicy = policy
+	return c
+}
+
+// WithWorkerLabels sets the labels of this worker used for group pinning and shard affinity
+func (c *KinesisClientLibConfiguration) WithWorkerLabels(labels map[string]string) *KinesisClientLibConfiguration {
+	c.WorkerLabels = labels
+	return c
+}
+
+// WithShardAffinityRules restricts the shards matching a rule to the workers whose labels match its selector
+func (c *KinesisClientLibConfiguration) WithShardAffinityRules(rules ...ShardAffinityRule) *KinesisClientLibConfiguration {
+	if _, err := NewShardAffinity(rules); err != nil {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Invalid shard affinity rules: %v", err)
+	}
+	c.ShardAffinityRules = rules
+	return c
+}
+
 func (c *KinesisClientLibConfiguration) WithLeaseSyncingIntervalMillis(leaseSyncingIntervalMillis int) *KinesisClientLibConfiguration {
 	c.LeaseSyncingTimeIntervalMillis = leaseSyncingIntervalMillis
 	return c
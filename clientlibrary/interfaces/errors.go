@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package interfaces
+package interfaces
+
+import "fmt"
+
+// The errors returned by IRecordProcessorCheckpointer and IPreparedCheckpointer. Each of them wraps the
+// underlying error, so they can be matched with errors.As and the cause with errors.Is:
+//
+//	var throttled interfaces.ThrottlingError
+//	if errors.As(err, &throttled) {
+//		// back off and retry
+//	}
+type (
+	// ThrottlingError is returned when the checkpoint store throttled the request. The record processor
+	// can back off and retry, or checkpoint less frequently.
+	ThrottlingError struct {
+		Message string
+		Err     error
+	}
+
+	// ShutdownError is returned when the record processor lost its lease. Another instance may have started
+	// processing the same records already, so the record processor should abort processing.
+	ShutdownError struct {
+		Message string
+		Err     error
+	}
+
+	// InvalidStateError is returned when the checkpoint store is not usable, e.g. the lease table doesn't exist.
+	InvalidStateError struct {
+		Message string
+		Err     error
+	}
+
+	// KinesisClientLibDependencyError is returned when a dependency of the KCL, like DynamoDB, failed.
+	// The record processor can back off and retry.
+	KinesisClientLibDependencyError struct {
+		Message string
+		Err     error
+	}
+
+	// IllegalArgumentError is returned when the sequence number to checkpoint is invalid or out of range.
+	IllegalArgumentError struct {
+		Message string
+		Err     error
+	}
+)
+
+func (e ThrottlingError) Error() string {
+	return formatError("ThrottlingError", e.Message, e.Err)
+}
+
+func (e ThrottlingError) Unwrap() error {
+	return e.Err
+}
+
+func (e ShutdownError) Error() string {
+	return formatError("ShutdownError", e.Message, e.Err)
+}
+
+func (e ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+func (e InvalidStateError) Error() string {
+	return formatError("InvalidStateError", e.Message, e.Err)
+}
+
+func (e InvalidStateError) Unwrap() error {
+	return e.Err
+}
+
+func (e KinesisClientLibDependencyError) Error() string {
+	return formatError("KinesisClientLibDependencyError", e.Message, e.Err)
+}
+
+func (e KinesisClientLibDependencyError) Unwrap() error {
+	return e.Err
+}
+
+func (e IllegalArgumentError) Error() string {
+	return formatError("IllegalArgumentError", e.Message, e.Err)
+}
+
+func (e IllegalArgumentError) Unwrap() error {
+	return e.Err
+}
+
+func formatError(name, message string, err error) string {
+	switch {
+	case message == "" && err == nil:
+		return name
+	case err == nil:
+		return fmt.Sprintf("%s: %s", name, message)
+	case message == "":
+		return fmt.Sprintf("%s: %v", name, err)
+	default:
+		return fmt.Sprintf("%s: %s: %v", name, message, err)
+	}
+}
@@ -64,8 +64,38 @@ const (
 	 * Processing will be moved to a different record processor (fail over, load balancing use cases).
 	 * Applications SHOULD NOT checkpoint their progress (as another record processor may have already started
 	 * processing data).
+	 *
+	 * Deprecated: the shard consumers report LEASE_LOST instead.
 	 */
 	ZOMBIE
+
+	/*
+	 * RELEASED Indicates that an operator asked the worker to release the shard (Sticky=20).
+	 * The lease is still held, so applications MAY flush downstream buffers and checkpoint their progress.
+	 * The last processed record is checkpointed by the KCL before the lease is released anyway.
+	 */
+	RELEASED
+
+	/*
+	 * LEASE_LOST Indicates that the lease could not be renewed, e.g. it expired and was taken by another worker.
+	 * Applications SHOULD NOT checkpoint their progress and SHOULD discard buffered data, as another record
+	 * processor may have already started processing the shard from its last checkpoint.
+	 */
+	LEASE_LOST
+
+	/*
+	 * LEASE_STOLEN Indicates that another worker claimed the shard (lease stealing or a pinned worker claiming
+	 * its shard back). The lease is still held until the handoff, so applications MAY flush downstream buffers
+	 * and checkpoint their progress. The last processed record is checkpointed by the KCL before the handoff anyway.
+	 */
+	LEASE_STOLEN
+
+	/*
+	 * REWOUND Indicates that an operator rewound the shard (see checkpoint.LeaseRewinder). Applications SHOULD NOT
+	 * checkpoint their progress, which would be rejected anyway, and SHOULD discard buffered data: the shard is
+	 * processed again from the rewind target.
+	 */
+	REWOUND
 )
 
 // Containers for the parameters to the IRecordProcessor
@@ -86,6 +116,10 @@ type (
 
 		// The last extended sequence number that was successfully checkpointed by the previous record processor.
 		ExtendedSequenceNumber *ExtendedSequenceNumber
+
+		// The pending extended sequence number prepared by the previous record processor, but not committed.
+		// It is nil if there is no pending checkpoint.
+		PendingCheckpointSequenceNumber *ExtendedSequenceNumber
 	}
 
 	ProcessRecordsInput struct {
@@ -98,6 +132,11 @@ type (
 		// The records received from Kinesis. These records may have been de-aggregated if they were published by the KPL.
 		Records []types.Record
 
+		// The sub-sequence number of each record in Records. User records de-aggregated from a KPL aggregated record
+		// share its sequence number and are numbered from 0 in the order they were aggregated. Records which were not
+		// aggregated have a sub-sequence number of 0. See IProgressRecordProcessorCheckpointer.CheckpointSubSequence.
+		SubSequenceNumbers []int64
+
 		// A checkpointer that the RecordProcessor can use to checkpoint its progress.
 		Checkpointer IRecordProcessorCheckpointer
 
@@ -115,11 +154,29 @@ type (
 )
 
 var shutdownReasonMap = map[ShutdownReason]*string{
-	REQUESTED: aws.String("REQUESTED"),
-	TERMINATE: aws.String("TERMINATE"),
-	ZOMBIE:    aws.String("ZOMBIE"),
+	REQUESTED:    aws.String("REQUESTED"),
+	TERMINATE:    aws.String("TERMINATE"),
+	ZOMBIE:       aws.String("ZOMBIE"),
+	RELEASED:     aws.String("RELEASED"),
+	LEASE_LOST:   aws.String("LEASE_LOST"),
+	LEASE_STOLEN: aws.String("LEASE_STOLEN"),
+	REWOUND:      aws.String("REWOUND"),
 }
 
 func ShutdownReasonMessage(reason ShutdownReason) *string {
 	return shutdownReasonMap[reason]
 }
+
+func (reason ShutdownReason) String() string {
+	return aws.ToString(ShutdownReasonMessage(reason))
+}
+
+// CanCheckpoint returns true if the worker still holds the lease when the record processor is shut down for
+// the given reason, i.e. checkpointing and flushing downstream buffers are safe.
+func (reason ShutdownReason) CanCheckpoint() bool {
+	switch reason {
+	case REQUESTED, TERMINATE, RELEASED, LEASE_STOLEN:
+		return true
+	}
+	return false
+}
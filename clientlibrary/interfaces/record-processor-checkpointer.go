@@ -36,6 +36,11 @@
 
 package interfaces
 
+import (
+	"context"
+	"database/sql"
+)
+
 type (
 	IPreparedCheckpointer interface {
 		GetPendingCheckpoint() *ExtendedSequenceNumber
@@ -115,4 +120,64 @@ type (
 		 */
 		PrepareCheckpoint(sequenceNumber *string) (IPreparedCheckpointer, error)
 	}
+
+	// IProgressRecordProcessorCheckpointer
+	/*
+	 * Implemented by the IRecordProcessorCheckpointer passed to RecordProcessors by the worker. RecordProcessors
+	 * checkpointing within records aggregated by the Kinesis Producer Library, or at the last record they processed,
+	 * type-assert their IRecordProcessorCheckpointer to it.
+	 */
+	IProgressRecordProcessorCheckpointer interface {
+		// CheckpointSubSequence
+		/*
+		 * This method will checkpoint the progress at the provided sequenceNumber and subSequenceNumber, i.e. within a
+		 * record aggregated by the Kinesis Producer Library. The user records of the aggregated record up to and
+		 * including subSequenceNumber are considered processed: upon failover, the Kinesis Client Library will skip them
+		 * and resume with the next user record.
+		 *
+		 * @param sequenceNumber A sequence number at which to checkpoint in this shard.
+		 * @param subSequenceNumber The sub-sequence number of the last processed user record of the aggregated record,
+		 *        see ProcessRecordsInput.SubSequenceNumbers.
+		 * @error The same errors as Checkpoint.
+		 */
+		CheckpointSubSequence(sequenceNumber *string, subSequenceNumber int64) error
+
+		// CheckpointLastProcessed
+		/*
+		 * This method will checkpoint the progress at the largest sequence number delivered to, and acknowledged by,
+		 * the record processor, i.e. the last record of the last batch for which ProcessRecords has returned.
+		 * Unlike Checkpoint(nil), it never marks the shard as fully processed (SHARD_END). If no record has been
+		 * processed yet, nothing is checkpointed.
+		 *
+		 * @error ThrottlingError Can't store checkpoint. Can be caused by checkpointing too frequently.
+		 *         Consider increasing the throughput/capacity of the checkpoint store or reducing checkpoint frequency.
+		 * @error ShutdownError The record processor instance has been shutdown. Another instance may have
+		 *         started processing some of these records already.
+		 *         The application should abort processing via this RecordProcessor instance.
+		 * @error InvalidStateError Can't store checkpoint.
+		 *         Unable to store the checkpoint in the DynamoDB table (e.g. table doesn't exist).
+		 * @error KinesisClientLibDependencyError Encountered an issue when storing the checkpoint. The application can
+		 *         backoff and retry.
+		 */
+		CheckpointLastProcessed() error
+	}
+
+	// ITxRecordProcessorCheckpointer
+	/*
+	 * Implemented by the IRecordProcessorCheckpointer of a worker whose checkpointer stores its leases in an SQL
+	 * database, see checkpoint.SQLCheckpoint. RecordProcessors writing their output to the same database can commit
+	 * the output and the checkpoint atomically.
+	 */
+	ITxRecordProcessorCheckpointer interface {
+		// CheckpointTx
+		/*
+		 * This method will checkpoint the progress at the provided sequenceNumber in the transaction. The checkpoint
+		 * is only persisted once the transaction commits. If the transaction is rolled back, the RecordProcessor
+		 * should return an error so that the records are processed again by a new consumer of the shard.
+		 *
+		 * @param sequenceNumber A sequence number at which to checkpoint in this shard, nil for SHARD_END.
+		 * @error The same errors as Checkpoint.
+		 */
+		CheckpointTx(ctx context.Context, tx *sql.Tx, sequenceNumber *string) error
+	}
 )
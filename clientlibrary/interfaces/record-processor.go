@@ -78,6 +78,22 @@ type (
 		Shutdown(shutdownInput *ShutdownInput)
 	}
 
+	// IRecordProcessorWithError can be implemented by a record processor, in addition to IRecordProcessor, to report
+	// the batches it failed to process. The KCL then calls ProcessRecordsWithError instead of ProcessRecords.
+	IRecordProcessorWithError interface {
+		// ProcessRecordsWithError
+		/*
+		 * Process data records, like ProcessRecords, and return an error if they could not be processed.
+		 * The records of a failed batch are not acknowledged: neither the automatic checkpointing nor
+		 * CheckpointLastProcessed checkpoint them. The KCL shuts the record processor down (REQUESTED) and gives the
+		 * shard up, so its records are delivered again from the last checkpoint.
+		 *
+		 * @param processRecordsInput Provides the records to be processed as well as information and capabilities related
+		 *        to them (eg checkpointing).
+		 */
+		ProcessRecordsWithError(processRecordsInput *ProcessRecordsInput) error
+	}
+
 	// IRecordProcessorFactory is interface for creating IRecordProcessor. Each Worker can have multiple threads
 	// for processing shard. Client can choose either creating one processor per shard or sharing them.
 	IRecordProcessorFactory interface {
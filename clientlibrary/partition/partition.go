@@ -39,17 +39,32 @@ import (
 type ShardStatus struct {
 	ID            string
 	ParentShardId string
-	Checkpoint    string
-	AssignedTo    string
-	Mux           *sync.RWMutex
-	LeaseTimeout  time.Time
+	// AdjacentParentShardId is the second parent of a shard created by merging two shards
+	AdjacentParentShardId string
+	Checkpoint            string
+	AssignedTo            string
+	Mux                   *sync.RWMutex
+	LeaseTimeout          time.Time
 	// Shard Range
 	StartingSequenceNumber string
 	// child shard doesn't have end sequence number
 	EndingSequenceNumber string
-	ClaimRequest         string
-	Sticky               int    // Sticky assignment: -1/0=normal, 10=pinned to worker, 20=release signal
-	StickyWorker         string // The worker ID this shard is pinned to (used when Sticky=10)
+	// Hash key range
+	StartingHashKey string
+	EndingHashKey   string
+	ClaimRequest    string
+	Sticky          int       // Sticky assignment: -1/0=normal, 10=pinned to worker, 20=release signal (see StickyState)
+	StickyWorker    string    // The worker ID this shard is pinned to (used when Sticky=10)
+	StickyGroup     string    // Label selector of the workers this shard is pinned to (used when Sticky=10)
+	StickyExpiry    time.Time // Optional expiry of the pin, zero means the pin never expires
+	Draining        string    // The worker that asked for this lease to be handed over while draining
+	// PendingCheckpoint is the sequence number prepared by a two-phase checkpoint but not yet committed
+	PendingCheckpoint string
+	// CheckpointSubSequenceNumber is the sub-sequence number of the last processed user record of the KPL
+	// aggregated record at Checkpoint. It is nil when the whole record at Checkpoint has been processed.
+	CheckpointSubSequenceNumber *int64
+	// RewindRequest is the rewind request the shard consumer started from, see checkpoint.RewindShard
+	RewindRequest string
 }
 
 func (ss *ShardStatus) GetLeaseOwner() string {
@@ -88,6 +103,18 @@ func (ss *ShardStatus) SetLeaseTimeout(timeout time.Time) {
 	ss.LeaseTimeout = timeout
 }
 
+func (ss *ShardStatus) GetClaimRequest() string {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.ClaimRequest
+}
+
+func (ss *ShardStatus) SetClaimRequest(claimRequest string) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.ClaimRequest = claimRequest
+}
+
 func (ss *ShardStatus) IsClaimRequestExpired(kclConfig *config.KinesisClientLibConfiguration) bool {
 	if leaseTimeout := ss.GetLeaseTimeout(); leaseTimeout.IsZero() {
 		return false
@@ -120,3 +147,59 @@ func (ss *ShardStatus) SetStickyWorker(worker string) {
 	defer ss.Mux.Unlock()
 	ss.StickyWorker = worker
 }
+
+func (ss *ShardStatus) GetCheckpointSubSequenceNumber() *int64 {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.CheckpointSubSequenceNumber
+}
+
+func (ss *ShardStatus) SetCheckpointSubSequenceNumber(subSequenceNumber *int64) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.CheckpointSubSequenceNumber = subSequenceNumber
+}
+
+func (ss *ShardStatus) GetPendingCheckpoint() string {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.PendingCheckpoint
+}
+
+func (ss *ShardStatus) SetPendingCheckpoint(pendingCheckpoint string) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.PendingCheckpoint = pendingCheckpoint
+}
+
+func (ss *ShardStatus) GetRewindRequest() string {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.RewindRequest
+}
+
+func (ss *ShardStatus) SetRewindRequest(rewindRequest string) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.RewindRequest = rewindRequest
+}
+
+func (ss *ShardStatus) GetDraining() string {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.Draining
+}
+
+func (ss *ShardStatus) SetDraining(draining string) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.Draining = draining
+}
+
+// IsDraining returns true if the current lease owner is draining and waits for another worker to claim the shard.
+// A marker left by a previous owner is ignored.
+func (ss *ShardStatus) IsDraining() bool {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.Draining != "" && ss.Draining == ss.AssignedTo
+}
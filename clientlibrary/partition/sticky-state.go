@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package partition
+package partition
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// StickyUnset is used when the lease table has no Sticky column for the shard (normal behavior)
+	StickyUnset StickyState = -1
+	// StickyNormal lets the shard participate in normal lease assignment and rebalancing
+	StickyNormal StickyState = 0
+	// StickyPinned pins the shard to its StickyWorker, or to its current owner if StickyWorker is empty
+	StickyPinned StickyState = 10
+	// StickyRelease signals the owning worker to gracefully release the shard
+	StickyRelease StickyState = 20
+)
+
+// ErrInvalidStickyState is returned when a sticky value is not one of the supported states
+var ErrInvalidStickyState = errors.New("InvalidStickyState")
+
+// StickyState is the sticky assignment state of a shard, stored in the Sticky column of the lease table.
+type StickyState int
+
+// ParseStickyState validates a raw Sticky column value
+func ParseStickyState(value int) (StickyState, error) {
+	state := StickyState(value)
+	if !state.IsValid() {
+		return StickyUnset, fmt.Errorf("%w: %d", ErrInvalidStickyState, value)
+	}
+	return state, nil
+}
+
+// IsValid returns true if the state is one of the supported sticky states
+func (s StickyState) IsValid() bool {
+	switch s {
+	case StickyUnset, StickyNormal, StickyPinned, StickyRelease:
+		return true
+	}
+	return false
+}
+
+func (s StickyState) String() string {
+	switch s {
+	case StickyUnset:
+		return "UNSET"
+	case StickyNormal:
+		return "NORMAL"
+	case StickyPinned:
+		return "PINNED"
+	case StickyRelease:
+		return "RELEASE"
+	}
+	return fmt.Sprintf("INVALID(%d)", int(s))
+}
+
+// GetStickyState returns the effective sticky state of the shard. Unknown values and missing columns
+// are treated as StickyNormal, and a pin whose StickyExpiry has passed is treated as StickyNormal as well.
+func (ss *ShardStatus) GetStickyState() StickyState {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+
+	state, err := ParseStickyState(ss.Sticky)
+	if err != nil || state == StickyUnset {
+		return StickyNormal
+	}
+
+	if state == StickyPinned && !ss.StickyExpiry.IsZero() && time.Now().UTC().After(ss.StickyExpiry) {
+		return StickyNormal
+	}
+
+	return state
+}
+
+func (ss *ShardStatus) GetStickyGroup() string {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.StickyGroup
+}
+
+func (ss *ShardStatus) SetStickyGroup(stickyGroup string) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.StickyGroup = stickyGroup
+}
+
+func (ss *ShardStatus) GetStickyExpiry() time.Time {
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+	return ss.StickyExpiry
+}
+
+func (ss *ShardStatus) SetStickyExpiry(expiry time.Time) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.StickyExpiry = expiry
+}
+
+// IsStickyWorkerExpired returns true if the shard is pinned to a worker that has not renewed its lease within
+// the failover window, i.e. the shard has been unowned, or still owned by the pinned worker, for longer than
+// failover after its last lease timeout. A shard whose lease has never been taken is considered expired.
+func (ss *ShardStatus) IsStickyWorkerExpired(failover time.Duration) bool {
+	if failover <= 0 {
+		return false
+	}
+
+	ss.Mux.RLock()
+	defer ss.Mux.RUnlock()
+
+	if ss.StickyWorker == "" || (ss.AssignedTo != "" && ss.AssignedTo != ss.StickyWorker) {
+		return false
+	}
+
+	if ss.LeaseTimeout.IsZero() {
+		return true
+	}
+
+	return time.Now().UTC().After(ss.LeaseTimeout.Add(failover))
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package partition
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStickyState(t *testing.T) {
+	tests := []struct {
+		value int
+		want  StickyState
+		valid bool
+	}{
+		{value: -1, want: StickyUnset, valid: true},
+		{value: 0, want: StickyNormal, valid: true},
+		{value: 10, want: StickyPinned, valid: true},
+		{value: 20, want: StickyRelease, valid: true},
+		{value: 1, want: StickyUnset},
+		{value: -2, want: StickyUnset},
+		{value: 30, want: StickyUnset},
+	}
+
+	for _, test := range tests {
+		state, err := ParseStickyState(test.value)
+		assert.Equal(t, test.want, state, "value %d", test.value)
+		if test.valid {
+			assert.Nil(t, err, "value %d", test.value)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidStickyState, "value %d", test.value)
+		}
+	}
+}
+
+func TestGetStickyState(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name   string
+		sticky int
+		expiry time.Time
+		want   StickyState
+	}{
+		{name: "unset", sticky: int(StickyUnset), want: StickyNormal},
+		{name: "invalid", sticky: 5, want: StickyNormal},
+		{name: "normal", sticky: int(StickyNormal), want: StickyNormal},
+		{name: "pinned", sticky: int(StickyPinned), want: StickyPinned},
+		{name: "pinned until later", sticky: int(StickyPinned), expiry: now.Add(time.Hour), want: StickyPinned},
+		{name: "pin expired", sticky: int(StickyPinned), expiry: now.Add(-time.Second), want: StickyNormal},
+		{name: "release ignores expiry", sticky: int(StickyRelease), expiry: now.Add(-time.Second), want: StickyRelease},
+	}
+
+	for _, test := range tests {
+		shard := &ShardStatus{Sticky: test.sticky, StickyExpiry: test.expiry, Mux: &sync.RWMutex{}}
+		assert.Equal(t, test.want, shard.GetStickyState(), test.name)
+	}
+}
+
+func TestIsStickyWorkerExpired(t *testing.T) {
+	now := time.Now().UTC()
+	failover := time.Minute
+	tests := []struct {
+		name         string
+		stickyWorker string
+		assignedTo   string
+		leaseTimeout time.Time
+		failover     time.Duration
+		want         bool
+	}{
+		{name: "no sticky worker", assignedTo: "abc", leaseTimeout: now.Add(-time.Hour), failover: failover},
+		{name: "never leased", stickyWorker: "abc", failover: failover, want: true},
+		{name: "disabled", stickyWorker: "abc", failover: 0},
+		{name: "owned by another worker", stickyWorker: "abc", assignedTo: "def", leaseTimeout: now.Add(-time.Hour), failover: failover},
+		{name: "renewed by the sticky worker", stickyWorker: "abc", assignedTo: "abc", leaseTimeout: now, failover: failover},
+		{name: "within failover", stickyWorker: "abc", leaseTimeout: now.Add(-30 * time.Second), failover: failover},
+		{name: "not renewed by the sticky worker", stickyWorker: "abc", assignedTo: "abc", leaseTimeout: now.Add(-2 * time.Minute), failover: failover, want: true},
+		{name: "unowned past failover", stickyWorker: "abc", leaseTimeout: now.Add(-2 * time.Minute), failover: failover, want: true},
+	}
+
+	for _, test := range tests {
+		shard := &ShardStatus{
+			StickyWorker: test.stickyWorker,
+			AssignedTo:   test.assignedTo,
+			LeaseTimeout: test.leaseTimeout,
+			Mux:          &sync.RWMutex{},
+		}
+		assert.Equal(t, test.want, shard.IsStickyWorkerExpired(test.failover), test.name)
+	}
+}
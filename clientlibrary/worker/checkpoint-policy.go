@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+)
+
+// autoCheckpoint checkpoints the processed records when the checkpoint policy says so. It is called by the shard
+// consumer after each batch with the number of records the record processor returned from, 0 for an empty batch.
+func (rc *RecordProcessorCheckpointer) autoCheckpoint(processed int) error {
+	policy := rc.policy
+
+	rc.mux.Lock()
+	rc.recordsSinceCheckpoint += processed
+	records := rc.recordsSinceCheckpoint
+	elapsed := time.Since(rc.lastCheckpointTime)
+	rc.mux.Unlock()
+
+	// nothing new to checkpoint
+	if records == 0 {
+		return nil
+	}
+
+	due := (policy.AfterEachBatch && processed > 0) ||
+		(policy.EveryRecords > 0 && records >= policy.EveryRecords) ||
+		(policy.IntervalMillis > 0 && elapsed >= time.Duration(policy.IntervalMillis)*time.Millisecond)
+	if !due {
+		return nil
+	}
+
+	return rc.CheckpointLastProcessed()
+}
+
+// checkpointOnShutdown checkpoints the processed records once the record processor has been shut down, if the
+// checkpoint policy says so. A closed shard is checkpointed at SHARD_END: the shard consumer only gets there
+// after every batch was processed.
+func (rc *RecordProcessorCheckpointer) checkpointOnShutdown(reason kcl.ShutdownReason) error {
+	if !rc.policy.OnShutdown {
+		return nil
+	}
+
+	switch reason {
+	case kcl.TERMINATE:
+		if rc.shard.GetCheckpoint() == chk.ShardEnd {
+			return nil
+		}
+		return rc.Checkpoint(nil)
+	case kcl.REQUESTED:
+		return rc.CheckpointLastProcessed()
+	default:
+		// released and stolen leases are checkpointed before the handoff, lost ones can't be
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package worker
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestAutoCheckpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      config.CheckpointPolicy
+		batches     []int
+		checkpoints []string
+	}{
+		{"no policy", config.CheckpointPolicy{}, []int{2, 2, 2}, nil},
+		{"after each batch", config.CheckpointPolicy{AfterEachBatch: true}, []int{2, 0, 2}, []string{"2", "4"}},
+		{"every records", config.CheckpointPolicy{EveryRecords: 3}, []int{2, 2, 1, 2}, []string{"4", "7"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkpointer := &mockCheckpointer{}
+			rc := newRecordProcessorCheckpointer(&par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}, checkpointer)
+			rc.policy = tt.policy
+
+			total := 0
+			for _, n := range tt.batches {
+				total += n
+				if n > 0 {
+					processed(rc, strconv.Itoa(total))
+				}
+				assert.Nil(t, rc.autoCheckpoint(n))
+			}
+			assert.Equal(t, tt.checkpoints, checkpointer.checkpoints)
+		})
+	}
+}
+
+func TestAutoCheckpointInterval(t *testing.T) {
+	checkpointer := &mockCheckpointer{}
+	rc := newRecordProcessorCheckpointer(&par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}, checkpointer)
+	rc.policy = config.CheckpointPolicy{IntervalMillis: 50}
+
+	processed(rc, "1")
+	assert.Nil(t, rc.autoCheckpoint(1))
+	assert.Empty(t, checkpointer.checkpoints)
+
+	// the interval has elapsed, but an idle shard has nothing to checkpoint
+	rc.lastCheckpointTime = time.Now().Add(-time.Second)
+	assert.Nil(t, rc.autoCheckpoint(0))
+	assert.Equal(t, []string{"1"}, checkpointer.checkpoints)
+
+	rc.lastCheckpointTime = time.Now().Add(-time.Second)
+	assert.Nil(t, rc.autoCheckpoint(0))
+	assert.Equal(t, []string{"1"}, checkpointer.checkpoints)
+}
+
+func TestCheckpointOnShutdown(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      config.CheckpointPolicy
+		reason      kcl.ShutdownReason
+		checkpoints []string
+	}{
+		{"disabled", config.CheckpointPolicy{}, kcl.REQUESTED, nil},
+		{"requested", config.CheckpointPolicy{OnShutdown: true}, kcl.REQUESTED, []string{"200"}},
+		{"shard end", config.CheckpointPolicy{OnShutdown: true}, kcl.TERMINATE, []string{chk.ShardEnd}},
+		{"lease lost", config.CheckpointPolicy{OnShutdown: true}, kcl.LEASE_LOST, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkpointer := &mockCheckpointer{}
+			rc := newRecordProcessorCheckpointer(&par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}, checkpointer)
+			rc.policy = tt.policy
+			processed(rc, "200")
+
+			assert.Nil(t, rc.checkpointOnShutdown(tt.reason))
+			assert.Equal(t, tt.checkpoints, checkpointer.checkpoints)
+		})
+	}
+}
+
+// failingRecordProcessor reports every batch as failed
+type failingRecordProcessor struct {
+	mockRecordProcessor
+}
+
+func (m *failingRecordProcessor) ProcessRecordsWithError(_ *kcl.ProcessRecordsInput) error {
+	return errors.New("downstream unavailable")
+}
+
+func TestProcessRecordsWithError(t *testing.T) {
+	checkpointer := &mockCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	processor := &failingRecordProcessor{}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithCheckpointPolicy(config.CheckpointPolicy{AfterEachBatch: true, OnShutdown: true})
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    checkpointer,
+		recordProcessor: processor,
+		kclConfig:       kclConfig,
+		mService:        metrics.NoopMonitoringService{},
+	}
+	rc := sc.newRecordProcessorCheckpointer()
+
+	records := []types.Record{{Data: []byte("a"), SequenceNumber: aws.String("300")}}
+	assert.NotNil(t, sc.processRecords(time.Now(), records, aws.Int64(0), rc))
+	assert.Nil(t, rc.LastProcessedSequenceNumber())
+
+	// the failed batch is neither checkpointed after the batch nor on shutdown
+	sc.shutdownRecordProcessor(kcl.REQUESTED, rc)
+	assert.Equal(t, []kcl.ShutdownReason{kcl.REQUESTED}, processor.shutdownReasons)
+	assert.Empty(t, checkpointer.checkpoints)
+}
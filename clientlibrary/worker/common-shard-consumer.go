@@ -22,6 +22,8 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -37,6 +39,9 @@ import (
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
+// errShardReleased is returned by the lease renewal when an operator asked for the shard to be released (Sticky=20)
+var errShardReleased = errors.New("ShardReleased")
+
 type shardConsumer interface {
 	getRecords() error
 }
@@ -55,6 +60,15 @@ type commonShardConsumer struct {
 	recordProcessor kcl.IRecordProcessor
 	kclConfig       *config.KinesisClientLibConfiguration
 	mService        metrics.MonitoringService
+
+	// leases is the lease snapshot of the worker, nil if the parent shard is read from the checkpointer
+	leases *leaseCache
+	// isShardListed tells whether a parent shard is still in the Kinesis shard listing, nil if it always is
+	isShardListed func(shardID string) bool
+
+	// resumeAfter is set when the shard resumes within a KPL aggregated record: the user records of that record
+	// up to and including the sub-sequence number have already been processed and are skipped.
+	resumeAfter *kcl.ExtendedSequenceNumber
 }
 
 // Cleanup the internal lease cache
@@ -82,7 +96,26 @@ func (sc *commonShardConsumer) getStartingPosition() (*types.StartingPosition, e
 		return nil, err
 	}
 
+	sc.resumeAfter = nil
+	if rewindRequest := sc.shard.GetRewindRequest(); rewindRequest != "" {
+		target, err := chk.ParseRewindRequest(rewindRequest)
+		if err == nil {
+			return sc.rewind(target), nil
+		}
+		sc.kclConfig.Logger.Errorf("Ignoring rewind of shard %s: %+v", sc.shard.ID, err)
+	}
+
 	checkpoint := sc.shard.GetCheckpoint()
+	if subSequenceNumber := sc.shard.GetCheckpointSubSequenceNumber(); checkpoint != "" && checkpoint != chk.ShardEnd && subSequenceNumber != nil {
+		// read the aggregated record at the checkpoint again and skip its processed user records
+		sc.kclConfig.Logger.Debugf("Start shard: %v at checkpoint: %v, sub-sequence: %d", sc.shard.ID, checkpoint, *subSequenceNumber)
+		sc.resumeAfter = &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(checkpoint), SubSequenceNumber: *subSequenceNumber}
+		return &types.StartingPosition{
+			Type:           types.ShardIteratorTypeAtSequenceNumber,
+			SequenceNumber: &checkpoint,
+		}, nil
+	}
+
 	if checkpoint != "" {
 		sc.kclConfig.Logger.Debugf("Start shard: %v at checkpoint: %v", sc.shard.ID, checkpoint)
 		return &types.StartingPosition{
@@ -111,32 +144,208 @@ func (sc *commonShardConsumer) getStartingPosition() (*types.StartingPosition, e
 	}, nil
 }
 
-// Need to wait until the parent shard finished
-func (sc *commonShardConsumer) waitOnParentShard() error {
-	if len(sc.shard.ParentShardId) == 0 {
+// rewind starts the shard from the rewind target instead of its checkpoint. The rewind request stays in the lease
+// table until the first checkpoint, so the shard restarts from the target until then.
+func (sc *commonShardConsumer) rewind(target chk.RewindTarget) *types.StartingPosition {
+	sc.kclConfig.Logger.Infof("Start rewound shard: %v at %s", sc.shard.ID, target)
+
+	// the checkpoint of the shard is behind the rewind target from now on
+	sc.shard.SetCheckpoint("")
+	sc.shard.SetCheckpointSubSequenceNumber(nil)
+
+	switch target.Position {
+	case chk.RewindAtTimestamp:
+		timestamp := target.Timestamp
+		return &types.StartingPosition{
+			Type:      types.ShardIteratorTypeAtTimestamp,
+			Timestamp: &timestamp,
+		}
+	case chk.RewindAtSequenceNumber:
+		return &types.StartingPosition{
+			Type:           types.ShardIteratorTypeAtSequenceNumber,
+			SequenceNumber: aws.String(target.SequenceNumber),
+		}
+	default:
+		return &types.StartingPosition{
+			Type: types.ShardIteratorTypeTrimHorizon,
+		}
+	}
+}
+
+// newRecordProcessorCheckpointer creates the checkpointer given to the record processor of the shard
+func (sc *commonShardConsumer) newRecordProcessorCheckpointer() *RecordProcessorCheckpointer {
+	recordCheckpointer := newRecordProcessorCheckpointer(sc.shard, sc.checkpointer)
+	recordCheckpointer.validateSequenceNumbers = sc.kclConfig.ValidateSequenceNumberBeforeCheckpointing
+	recordCheckpointer.policy = sc.kclConfig.CheckpointPolicy
+	if sc.kclConfig.ValidateSequenceNumberWithKinesis {
+		recordCheckpointer.sequenceNumberProbe = sc.probeSequenceNumber
+	}
+	return recordCheckpointer
+}
+
+// probeSequenceNumber checks with Kinesis that the sequence number belongs to the shard by asking for
+// a shard iterator at that sequence number.
+func (sc *commonShardConsumer) probeSequenceNumber(sequenceNumber string) error {
+	_, err := sc.kc.GetShardIterator(context.TODO(), &kinesis.GetShardIteratorInput{
+		ShardId:                aws.String(sc.shard.ID),
+		ShardIteratorType:      types.ShardIteratorTypeAtSequenceNumber,
+		StartingSequenceNumber: aws.String(sequenceNumber),
+		StreamName:             aws.String(sc.kclConfig.StreamName),
+	})
+	if err == nil {
+		return nil
+	}
+
+	var invalidArgumentErr *types.InvalidArgumentException
+	if errors.As(err, &invalidArgumentErr) {
+		return kcl.IllegalArgumentError{
+			Message: fmt.Sprintf("%s is not a sequence number of shard %s", sequenceNumber, sc.shard.ID),
+			Err:     errors.Join(ErrInvalidSequenceNumber, err),
+		}
+	}
+
+	var throughputErr *types.ProvisionedThroughputExceededException
+	if errors.As(err, &throughputErr) {
+		return kcl.ThrottlingError{Message: "validating sequence number " + sequenceNumber, Err: err}
+	}
+
+	return kcl.KinesisClientLibDependencyError{Message: "validating sequence number " + sequenceNumber, Err: err}
+}
+
+// initializationInput tells the record processor where the shard starts and surfaces the checkpoint
+// prepared, but not committed, by the previous owner of the shard.
+func (sc *commonShardConsumer) initializationInput() *kcl.InitializationInput {
+	input := &kcl.InitializationInput{
+		ShardId:                sc.shard.ID,
+		ExtendedSequenceNumber: &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(sc.shard.GetCheckpoint())},
+	}
+
+	if subSequenceNumber := sc.shard.GetCheckpointSubSequenceNumber(); subSequenceNumber != nil {
+		input.ExtendedSequenceNumber.SubSequenceNumber = *subSequenceNumber
+	}
+
+	if pending := sc.shard.GetPendingCheckpoint(); pending != "" {
+		input.PendingCheckpointSequenceNumber = &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(pending)}
+	}
+
+	return input
+}
+
+// checkpointBeforeHandoff checkpoints the last processed record before the lease is handed over to another
+// worker, so the new owner resumes right after it instead of re-reading (or skipping) records.
+func (sc *commonShardConsumer) checkpointBeforeHandoff(recordCheckpointer *RecordProcessorCheckpointer) {
+	if err := recordCheckpointer.CheckpointLastProcessed(); err != nil {
+		sc.kclConfig.Logger.Errorf("Error checkpointing shard %s before handoff: %+v", sc.shard.ID, err)
+	}
+}
+
+// shutdownRecordProcessor shuts the record processor down and applies the checkpoint policy on shutdown
+func (sc *commonShardConsumer) shutdownRecordProcessor(reason kcl.ShutdownReason, recordCheckpointer *RecordProcessorCheckpointer) {
+	shutdownInput := &kcl.ShutdownInput{ShutdownReason: reason, Checkpointer: recordCheckpointer}
+	sc.recordProcessor.Shutdown(shutdownInput)
+
+	if err := recordCheckpointer.checkpointOnShutdown(reason); err != nil {
+		sc.kclConfig.Logger.Errorf("Error checkpointing shard %s on shutdown: %+v", sc.shard.ID, err)
+	}
+}
+
+// leaseShutdownReason maps the outcome of a failed lease renewal to the reason given to the record processor
+func leaseShutdownReason(err error) kcl.ShutdownReason {
+	switch {
+	case errors.Is(err, errShardReleased):
+		return kcl.RELEASED
+	case err.Error() == chk.ErrShardClaimed:
+		return kcl.LEASE_STOLEN
+	case errors.Is(err, chk.ErrRewindRequested):
+		return kcl.REWOUND
+	default:
+		return kcl.LEASE_LOST
+	}
+}
+
+// shutdownOnLeaseError shuts the record processor down after a failed lease renewal or a checkpoint
+// rejected because the lease was lost. When the lease is still
+// held (release request or claim by another worker) the last processed record is checkpointed before the
+// lease is handed over. It returns the error to report to the worker, if any.
+func (sc *commonShardConsumer) shutdownOnLeaseError(err error, recordCheckpointer *RecordProcessorCheckpointer) error {
+	log := sc.kclConfig.Logger
+	reason := leaseShutdownReason(err)
+
+	log.Infof("Shutting down record processor of shard %s: %s (%v)", sc.shard.ID, reason, err)
+	sc.shutdownRecordProcessor(reason, recordCheckpointer)
+
+	if reason.CanCheckpoint() {
+		// Checkpoint current progress (never SHARD_END: the shard has not been fully processed)
+		sc.checkpointBeforeHandoff(recordCheckpointer)
+		return nil
+	}
+
+	if errors.As(err, &chk.ErrLeaseNotAcquired{}) || errors.Is(err, chk.ErrLeaseLost) {
+		log.Warnf("Lost lease on shard: %s", sc.shard.ID)
+		return nil
+	}
+
+	if reason == kcl.REWOUND {
+		// the lease is released and the shard restarts from the rewind target
+		log.Infof("Restarting rewound shard: %s", sc.shard.ID)
 		return nil
 	}
 
+	log.Errorf("Error in refreshing lease on shard: %s. Error: %+v", sc.shard.ID, err)
+	return err
+}
+
+// Need to wait until the parent shards finished
+func (sc *commonShardConsumer) waitOnParentShard() error {
+	for _, parentShardID := range []string{sc.shard.ParentShardId, sc.shard.AdjacentParentShardId} {
+		if len(parentShardID) == 0 {
+			continue
+		}
+
+		if err := sc.waitOnShardEnd(parentShardID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitOnShardEnd waits until the shard has been processed to its end
+func (sc *commonShardConsumer) waitOnShardEnd(shardID string) error {
 	pshard := &par.ShardStatus{
-		ID:  sc.shard.ParentShardId,
+		ID:  shardID,
 		Mux: &sync.RWMutex{},
 	}
 
+	// the children of a shard poll the lease snapshot of their worker, not the lease table
+	pollInterval := time.Duration(sc.kclConfig.ParentShardPollIntervalMillis) * time.Millisecond
+	fetchCheckpoint := sc.checkpointer.FetchCheckpoint
+	if sc.leases != nil {
+		fetchCheckpoint = func(shard *par.ShardStatus) error { return sc.leases.fetchCheckpoint(shard, pollInterval) }
+	}
+
 	for {
-		if err := sc.checkpointer.FetchCheckpoint(pshard); err != nil {
+		err := fetchCheckpoint(pshard)
+		if err != nil && err != chk.ErrSequenceIDNotFound {
 			return err
 		}
 
 		// Parent shard is finished.
-		if pshard.GetCheckpoint() == chk.ShardEnd {
+		if err == nil && pshard.GetCheckpoint() == chk.ShardEnd {
 			return nil
 		}
 
-		time.Sleep(time.Duration(sc.kclConfig.ParentShardPollIntervalMillis) * time.Millisecond)
+		// A parent without checkpoint is still open or not leased yet, unless Kinesis deleted it already
+		if err == chk.ErrSequenceIDNotFound && sc.isShardListed != nil && !sc.isShardListed(shardID) {
+			return nil
+		}
+
+		time.Sleep(pollInterval)
 	}
 }
 
-func (sc *commonShardConsumer) processRecords(getRecordsStartTime time.Time, records []types.Record, millisBehindLatest *int64, recordCheckpointer kcl.IRecordProcessorCheckpointer) {
+// processRecords delivers a batch of records to the record processor and applies the checkpoint policy.
+// It returns an error if an IRecordProcessorWithError failed to process the batch.
+func (sc *commonShardConsumer) processRecords(getRecordsStartTime time.Time, records []types.Record, millisBehindLatest *int64, recordCheckpointer *RecordProcessorCheckpointer) error {
 	log := sc.kclConfig.Logger
 
 	getRecordsTime := time.Since(getRecordsStartTime).Milliseconds()
@@ -152,8 +361,11 @@ func (sc *commonShardConsumer) processRecords(getRecordsStartTime time.Time, rec
 		log.Errorf("Error in de-aggregating KPL records: %+v", err)
 	}
 
+	dars, subSequenceNumbers := sc.skipProcessedUserRecords(dars, subSequenceNumbersOf(dars))
+
 	input := &kcl.ProcessRecordsInput{
 		Records:            dars,
+		SubSequenceNumbers: subSequenceNumbers,
 		MillisBehindLatest: *millisBehindLatest,
 		Checkpointer:       recordCheckpointer,
 	}
@@ -172,7 +384,17 @@ func (sc *commonShardConsumer) processRecords(getRecordsStartTime time.Time, rec
 		// Delivery the events to the record processor
 		input.CacheEntryTime = &getRecordsStartTime
 		input.CacheExitTime = &processRecordsStartTime
-		sc.recordProcessor.ProcessRecords(input)
+		if recordLength > 0 {
+			// the records of the batch can be checkpointed while they are being processed
+			recordCheckpointer.setLargestDeliveredSequenceNumber(input.Records[recordLength-1].SequenceNumber)
+		}
+		if err := sc.deliverRecords(input); err != nil {
+			return fmt.Errorf("record processor failed to process %d records of shard %s: %w", recordLength, sc.shard.ID, err)
+		}
+		if recordLength > 0 {
+			// the processor has acknowledged the batch, so its last record is safe to checkpoint on release
+			recordCheckpointer.setLastProcessedSequenceNumber(input.Records[recordLength-1].SequenceNumber)
+		}
 		processedRecordsTiming := time.Since(processRecordsStartTime).Milliseconds()
 		sc.mService.RecordProcessRecordsTime(sc.shard.ID, float64(processedRecordsTiming))
 	}
@@ -180,4 +402,50 @@ func (sc *commonShardConsumer) processRecords(getRecordsStartTime time.Time, rec
 	sc.mService.IncrRecordsProcessed(sc.shard.ID, recordLength)
 	sc.mService.IncrBytesProcessed(sc.shard.ID, recordBytes)
 	sc.mService.MillisBehindLatest(sc.shard.ID, float64(*millisBehindLatest))
+
+	if err := recordCheckpointer.autoCheckpoint(recordLength); err != nil {
+		log.Errorf("Error checkpointing shard %s: %+v", sc.shard.ID, err)
+	}
+	return nil
+}
+
+// deliverRecords calls the record processor, ProcessRecordsWithError if it reports failed batches
+func (sc *commonShardConsumer) deliverRecords(input *kcl.ProcessRecordsInput) error {
+	if recordProcessor, ok := sc.recordProcessor.(kcl.IRecordProcessorWithError); ok {
+		return recordProcessor.ProcessRecordsWithError(input)
+	}
+
+	sc.recordProcessor.ProcessRecords(input)
+	return nil
+}
+
+// subSequenceNumbersOf numbers the de-aggregated user records sharing the sequence number of their aggregated record
+func subSequenceNumbersOf(records []types.Record) []int64 {
+	subSequenceNumbers := make([]int64, len(records))
+	for i := 1; i < len(records); i++ {
+		if aws.ToString(records[i].SequenceNumber) == aws.ToString(records[i-1].SequenceNumber) {
+			subSequenceNumbers[i] = subSequenceNumbers[i-1] + 1
+		}
+	}
+	return subSequenceNumbers
+}
+
+// skipProcessedUserRecords drops the user records already processed when the shard resumed within an aggregated record
+func (sc *commonShardConsumer) skipProcessedUserRecords(records []types.Record, subSequenceNumbers []int64) ([]types.Record, []int64) {
+	if sc.resumeAfter == nil || len(records) == 0 {
+		return records, subSequenceNumbers
+	}
+
+	skip := 0
+	for skip < len(records) && aws.ToString(records[skip].SequenceNumber) == aws.ToString(sc.resumeAfter.SequenceNumber) &&
+		subSequenceNumbers[skip] <= sc.resumeAfter.SubSequenceNumber {
+		skip++
+	}
+
+	// only the first aggregated record is affected
+	if skip < len(records) {
+		sc.resumeAfter = nil
+	}
+
+	return records[skip:], subSequenceNumbers[skip:]
 }
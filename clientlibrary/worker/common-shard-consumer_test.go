@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+func TestShutdownOnLeaseError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		reason      kcl.ShutdownReason
+		checkpoints []string
+		returnsErr  bool
+	}{
+		{"release requested", errShardReleased, kcl.RELEASED, []string{"200"}, false},
+		{"claimed by another worker", errors.New(chk.ErrShardClaimed), kcl.LEASE_STOLEN, []string{"200"}, false},
+		{"lease taken over", chk.ErrLeaseNotAcquired{}, kcl.LEASE_LOST, nil, false},
+		{"checkpoint rejected", chk.ErrLeaseLost, kcl.LEASE_LOST, nil, false},
+		{"shard rewound", chk.ErrRewindRequested, kcl.REWOUND, nil, false},
+		{"lease table error", errors.New("ResourceNotFoundException"), kcl.LEASE_LOST, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkpointer := &mockCheckpointer{}
+			processor := &mockRecordProcessor{}
+			shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+			sc := &commonShardConsumer{
+				shard:           shard,
+				checkpointer:    checkpointer,
+				recordProcessor: processor,
+				kclConfig:       config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", "workerId"),
+			}
+			rc := newRecordProcessorCheckpointer(shard, checkpointer)
+			processed(rc, "200")
+
+			err := sc.shutdownOnLeaseError(tt.err, rc)
+			assert.Equal(t, tt.returnsErr, err != nil)
+			assert.Equal(t, []kcl.ShutdownReason{tt.reason}, processor.shutdownReasons)
+			assert.Equal(t, tt.checkpoints, checkpointer.checkpoints)
+		})
+	}
+}
+
+func TestShutdownReasonCanCheckpoint(t *testing.T) {
+	for _, reason := range []kcl.ShutdownReason{kcl.REQUESTED, kcl.TERMINATE, kcl.RELEASED, kcl.LEASE_STOLEN} {
+		assert.True(t, reason.CanCheckpoint(), reason.String())
+	}
+	for _, reason := range []kcl.ShutdownReason{kcl.ZOMBIE, kcl.LEASE_LOST, kcl.REWOUND} {
+		assert.False(t, reason.CanCheckpoint(), reason.String())
+	}
+	assert.Equal(t, "LEASE_STOLEN", kcl.LEASE_STOLEN.String())
+}
+
+// probeKinesis answers the GetShardIterator probes of the sequence number validation
+type probeKinesis struct {
+	KinesisSubscriberGetter
+	err    error
+	probes []*kinesis.GetShardIteratorInput
+}
+
+func (m *probeKinesis) GetShardIterator(_ context.Context, params *kinesis.GetShardIteratorInput, _ ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error) {
+	m.probes = append(m.probes, params)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String("iterator")}, nil
+}
+
+func TestValidateSequenceNumberWithKinesis(t *testing.T) {
+	kc := &probeKinesis{}
+	checkpointer := &mockCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	sc := &commonShardConsumer{
+		shard:        shard,
+		kc:           kc,
+		checkpointer: checkpointer,
+		kclConfig: config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", "workerId").
+			WithValidateSequenceNumberWithKinesis(true),
+	}
+	rc := sc.newRecordProcessorCheckpointer()
+	processed(rc, "300")
+
+	assert.Nil(t, rc.Checkpoint(aws.String("200")))
+	assert.Equal(t, 1, len(kc.probes))
+	assert.Equal(t, types.ShardIteratorTypeAtSequenceNumber, kc.probes[0].ShardIteratorType)
+	assert.Equal(t, "200", aws.ToString(kc.probes[0].StartingSequenceNumber))
+	assert.Equal(t, "streamName", aws.ToString(kc.probes[0].StreamName))
+
+	// a sequence number of another shard
+	kc.err = &types.InvalidArgumentException{Message: aws.String("Invalid StartingSequenceNumber")}
+	err := rc.Checkpoint(aws.String("250"))
+	assert.ErrorIs(t, err, ErrInvalidSequenceNumber)
+	assert.True(t, errors.As(err, &kcl.IllegalArgumentError{}))
+
+	kc.err = errors.New("connection reset")
+	err = rc.Checkpoint(aws.String("250"))
+	assert.True(t, errors.As(err, &kcl.KinesisClientLibDependencyError{}))
+	assert.Equal(t, []string{"200"}, checkpointer.checkpoints)
+
+	// range checks don't need a probe
+	assert.ErrorIs(t, rc.Checkpoint(aws.String("301")), ErrInvalidSequenceNumber)
+	assert.Equal(t, 3, len(kc.probes))
+}
+
+// fetchCheckpointer returns a fixed checkpoint from FetchCheckpoint
+type fetchCheckpointer struct {
+	mockCheckpointer
+	checkpoint        string
+	subSequenceNumber *int64
+	rewindRequest     string
+}
+
+func (m *fetchCheckpointer) FetchCheckpoint(shard *par.ShardStatus) error {
+	shard.SetCheckpoint(m.checkpoint)
+	shard.SetCheckpointSubSequenceNumber(m.subSequenceNumber)
+	shard.SetRewindRequest(m.rewindRequest)
+	return nil
+}
+
+func TestResumeWithinAggregatedRecord(t *testing.T) {
+	subSequenceNumber := int64(1)
+	checkpointer := &fetchCheckpointer{checkpoint: "200", subSequenceNumber: &subSequenceNumber}
+	processor := &mockRecordProcessor{}
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    checkpointer,
+		recordProcessor: processor,
+		kclConfig:       config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", "workerId"),
+		mService:        metrics.NoopMonitoringService{},
+	}
+
+	// the aggregated record at the checkpoint is read again
+	position, err := sc.getStartingPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, types.ShardIteratorTypeAtSequenceNumber, position.Type)
+	assert.Equal(t, "200", aws.ToString(position.SequenceNumber))
+	assert.Equal(t, int64(1), sc.initializationInput().ExtendedSequenceNumber.SubSequenceNumber)
+
+	// user records de-aggregated from the record at 200, followed by a record which was not aggregated
+	records := []types.Record{
+		{Data: []byte("a"), SequenceNumber: aws.String("200")},
+		{Data: []byte("b"), SequenceNumber: aws.String("200")},
+		{Data: []byte("c"), SequenceNumber: aws.String("200")},
+		{Data: []byte("d"), SequenceNumber: aws.String("201")},
+	}
+	assert.Equal(t, []int64{0, 1, 2, 0}, subSequenceNumbersOf(records))
+
+	skipped, subSequenceNumbers := sc.skipProcessedUserRecords(records, subSequenceNumbersOf(records))
+	assert.Equal(t, []byte("c"), skipped[0].Data)
+	assert.Equal(t, []int64{2, 0}, subSequenceNumbers)
+	assert.Nil(t, sc.resumeAfter)
+
+	// only the first aggregated record is skipped
+	skipped, _ = sc.skipProcessedUserRecords(records, subSequenceNumbersOf(records))
+	assert.Equal(t, 4, len(skipped))
+
+	// a checkpoint of a whole record starts after it
+	checkpointer.subSequenceNumber = nil
+	position, err = sc.getStartingPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, types.ShardIteratorTypeAfterSequenceNumber, position.Type)
+	assert.Nil(t, sc.resumeAfter)
+}
+
+func TestStartingPositionRewound(t *testing.T) {
+	subSequenceNumber := int64(1)
+	checkpointer := &fetchCheckpointer{checkpoint: "200", subSequenceNumber: &subSequenceNumber}
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    checkpointer,
+		recordProcessor: &mockRecordProcessor{},
+		kclConfig:       config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", "workerId"),
+		mService:        metrics.NoopMonitoringService{},
+	}
+
+	tests := []struct {
+		rewindRequest string
+		position      types.StartingPosition
+	}{
+		{"TRIM_HORIZON@2024-06-01T00:00:00Z", types.StartingPosition{Type: types.ShardIteratorTypeTrimHorizon}},
+		{"AT_TIMESTAMP=2024-05-01T12:00:00Z@2024-06-01T00:00:00Z", types.StartingPosition{
+			Type: types.ShardIteratorTypeAtTimestamp, Timestamp: aws.Time(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))}},
+		{"AT_SEQUENCE_NUMBER=150@2024-06-01T00:00:00Z", types.StartingPosition{
+			Type: types.ShardIteratorTypeAtSequenceNumber, SequenceNumber: aws.String("150")}},
+	}
+
+	for _, tt := range tests {
+		checkpointer.rewindRequest = tt.rewindRequest
+		position, err := sc.getStartingPosition()
+		assert.Nil(t, err)
+		assert.Equal(t, tt.position, *position, tt.rewindRequest)
+		assert.Nil(t, sc.resumeAfter)
+
+		// records before the checkpoint are processed again
+		assert.Equal(t, "", shard.GetCheckpoint())
+		assert.Nil(t, shard.GetCheckpointSubSequenceNumber())
+		assert.Equal(t, tt.rewindRequest, shard.GetRewindRequest())
+	}
+}
+
+// parentCheckpointer returns the next checkpoint of a parent shard every time it is fetched, an empty checkpoint
+// while the parent has no checkpoint yet
+type parentCheckpointer struct {
+	chk.Checkpointer
+	checkpoints map[string][]string
+	fetched     []string
+}
+
+func (m *parentCheckpointer) FetchCheckpoint(shard *par.ShardStatus) error {
+	m.fetched = append(m.fetched, shard.ID)
+	checkpoints := m.checkpoints[shard.ID]
+	if len(checkpoints) == 0 {
+		return chk.ErrSequenceIDNotFound
+	}
+	if len(checkpoints) > 1 {
+		m.checkpoints[shard.ID] = checkpoints[1:]
+	}
+	if checkpoints[0] == "" {
+		return chk.ErrSequenceIDNotFound
+	}
+	shard.SetCheckpoint(checkpoints[0])
+	return nil
+}
+
+func TestWaitOnMergedParentShards(t *testing.T) {
+	checkpointer := &parentCheckpointer{checkpoints: map[string][]string{
+		"0000": {"", "", "100", chk.ShardEnd},
+		"0001": {"200", chk.ShardEnd},
+	}}
+	listed := map[string]bool{"0000": true, "0001": true, "0002": true}
+	kclConfig := config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", "workerId")
+	kclConfig.ParentShardPollIntervalMillis = 1
+	sc := &commonShardConsumer{
+		shard:         &par.ShardStatus{ID: "0002", ParentShardId: "0000", AdjacentParentShardId: "0001", Mux: &sync.RWMutex{}},
+		checkpointer:  checkpointer,
+		isShardListed: func(shardID string) bool { return listed[shardID] },
+		kclConfig:     kclConfig,
+	}
+
+	// the child of a merge waits until both parents have been processed to their end, including a parent which
+	// isn't leased or checkpointed yet
+	assert.Nil(t, sc.waitOnParentShard())
+	assert.Equal(t, []string{"0000", "0000", "0000", "0000", "0001", "0001"}, checkpointer.fetched)
+
+	// only a parent Kinesis deleted already doesn't hold back its child
+	checkpointer = &parentCheckpointer{checkpoints: map[string][]string{"0001": {"200", chk.ShardEnd}}}
+	sc.checkpointer = checkpointer
+	delete(listed, "0000")
+	assert.Nil(t, sc.waitOnParentShard())
+	assert.Equal(t, []string{"0000", "0001", "0001"}, checkpointer.fetched)
+}
@@ -22,15 +22,14 @@ package worker
 
 import (
 	"context"
-	"errors"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 
 	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
 	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
 // FanOutShardConsumer is  responsible for consuming data records of a (specified) shard.
@@ -75,12 +74,8 @@ func (sc *FanOutShardConsumer) getRecords() error {
 		}
 	}()
 
-	input := &kcl.InitializationInput{
-		ShardId:                sc.shard.ID,
-		ExtendedSequenceNumber: &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(sc.shard.GetCheckpoint())},
-	}
-	sc.recordProcessor.Initialize(input)
-	recordCheckpointer := NewRecordProcessorCheckpoint(sc.shard, sc.checkpointer)
+	sc.recordProcessor.Initialize(sc.initializationInput())
+	recordCheckpointer := sc.newRecordProcessorCheckpointer()
 
 	var continuationSequenceNumber *string
 	refreshLeaseTimer := time.After(time.Until(sc.shard.LeaseTimeout.Add(-time.Duration(sc.kclConfig.LeaseRefreshPeriodMillis) * time.Millisecond)))
@@ -88,42 +83,20 @@ func (sc *FanOutShardConsumer) getRecords() error {
 		getRecordsStartTime := time.Now()
 		select {
 		case <-*sc.stop:
-			shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.REQUESTED, Checkpointer: recordCheckpointer}
-			sc.recordProcessor.Shutdown(shutdownInput)
+			sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
 			return nil
 		case <-refreshLeaseTimer:
 			log.Debugf("Refreshing lease on shard: %s for worker: %s", sc.shard.ID, sc.consumerID)
 			err = sc.checkpointer.GetLease(sc.shard, sc.consumerID)
-			if err != nil {
-				if errors.As(err, &chk.ErrLeaseNotAcquired{}) {
-					log.Warnf("Failed in acquiring lease on shard: %s for worker: %s", sc.shard.ID, sc.consumerID)
-					return nil
-				}
-				log.Errorf("Error in refreshing lease on shard: %s for worker: %s. Error: %+v", sc.shard.ID, sc.consumerID, err)
-				return err
-			}
-
-			// Check if shard should be released (sticky=20)
-			// GetLease refreshes shard data including sticky value
-			if sc.shard.GetSticky() == 20 {
+			if err == nil && sc.shard.GetStickyState() == par.StickyRelease {
+				// Check if shard should be released (sticky=20)
+				// GetLease refreshes shard data including sticky value
 				log.Infof("Shard %s has sticky=20, initiating graceful release", sc.shard.ID)
-
-				// Checkpoint current progress
-				if err := recordCheckpointer.Checkpoint(nil); err != nil {
-					log.Errorf("Error checkpointing before release: %+v", err)
-				}
-
-				// Remove lease owner (clear AssignedTo field)
-				if err := sc.checkpointer.RemoveLeaseOwner(sc.shard.ID); err != nil {
-					log.Errorf("Error removing lease owner: %+v", err)
-				}
-
-				log.Infof("Successfully released shard %s (sticky=20)", sc.shard.ID)
-
-				// Shutdown gracefully
-				shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.REQUESTED, Checkpointer: recordCheckpointer}
-				sc.recordProcessor.Shutdown(shutdownInput)
-				return nil
+				err = errShardReleased
+			}
+			if err != nil {
+				// the lease is removed by releaseLease on return
+				return sc.shutdownOnLeaseError(err, recordCheckpointer)
 			}
 
 			refreshLeaseTimer = time.After(time.Until(sc.shard.LeaseTimeout.Add(-time.Duration(sc.kclConfig.LeaseRefreshPeriodMillis) * time.Millisecond)))
@@ -149,13 +122,21 @@ func (sc *FanOutShardConsumer) getRecords() error {
 				continue
 			}
 			continuationSequenceNumber = subEvent.Value.ContinuationSequenceNumber
-			sc.processRecords(getRecordsStartTime, subEvent.Value.Records, subEvent.Value.MillisBehindLatest, recordCheckpointer)
+			if err := sc.processRecords(getRecordsStartTime, subEvent.Value.Records, subEvent.Value.MillisBehindLatest, recordCheckpointer); err != nil {
+				// give the shard up, its records are read again from the last checkpoint
+				sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
+				return err
+			}
+
+			// Another worker owns the shard now or it was rewound, stop before processing records again
+			if err := recordCheckpointer.fenced(); err != nil {
+				return sc.shutdownOnLeaseError(err, recordCheckpointer)
+			}
 
 			// The shard has been closed, so no new records can be read from it
 			if continuationSequenceNumber == nil {
 				log.Infof("Shard %s closed", sc.shard.ID)
-				shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.TERMINATE, Checkpointer: recordCheckpointer}
-				sc.recordProcessor.Shutdown(shutdownInput)
+				sc.shutdownRecordProcessor(kcl.TERMINATE, recordCheckpointer)
 				return nil
 			}
 		}
@@ -44,6 +44,7 @@ import (
 	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
 	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
 	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
 const (
@@ -125,13 +126,9 @@ func (sc *PollingShardConsumer) getRecords() error {
 	}
 
 	// Start processing events and notify record processor on shard and starting checkpoint
-	input := &kcl.InitializationInput{
-		ShardId:                sc.shard.ID,
-		ExtendedSequenceNumber: &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(sc.shard.GetCheckpoint())},
-	}
-	sc.recordProcessor.Initialize(input)
+	sc.recordProcessor.Initialize(sc.initializationInput())
 
-	recordCheckpointer := NewRecordProcessorCheckpoint(sc.shard, sc.checkpointer)
+	recordCheckpointer := sc.newRecordProcessorCheckpointer()
 	retriedErrors := 0
 
 	// define API call rate limit starting window
@@ -143,7 +140,7 @@ func (sc *PollingShardConsumer) getRecords() error {
 	// starting async lease renewal thread
 	leaseRenewalErrChan := make(chan error, 1)
 	go func() {
-		leaseRenewalErrChan <- sc.renewLease(ctx, recordCheckpointer)
+		leaseRenewalErrChan <- sc.renewLease(ctx)
 	}()
 	for {
 		getRecordsStartTime := time.Now()
@@ -209,13 +206,21 @@ func (sc *PollingShardConsumer) getRecords() error {
 		// reset the retry count after success
 		retriedErrors = 0
 
-		sc.processRecords(getRecordsStartTime, getResp.Records, getResp.MillisBehindLatest, recordCheckpointer)
+		if err := sc.processRecords(getRecordsStartTime, getResp.Records, getResp.MillisBehindLatest, recordCheckpointer); err != nil {
+			// give the shard up, its records are read again from the last checkpoint
+			sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
+			return err
+		}
+
+		// Another worker owns the shard now or it was rewound, stop before processing records again
+		if err := recordCheckpointer.fenced(); err != nil {
+			return sc.shutdownOnLeaseError(err, recordCheckpointer)
+		}
 
 		// The shard has been closed, so no new records can be read from it
 		if getResp.NextShardIterator == nil {
 			log.Infof("Shard %s closed", sc.shard.ID)
-			shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.TERMINATE, Checkpointer: recordCheckpointer}
-			sc.recordProcessor.Shutdown(shutdownInput)
+			sc.shutdownRecordProcessor(kcl.TERMINATE, recordCheckpointer)
 			return nil
 		}
 		shardIterator = getResp.NextShardIterator
@@ -229,11 +234,13 @@ func (sc *PollingShardConsumer) getRecords() error {
 
 		select {
 		case <-*sc.stop:
-			shutdownInput := &kcl.ShutdownInput{ShutdownReason: kcl.REQUESTED, Checkpointer: recordCheckpointer}
-			sc.recordProcessor.Shutdown(shutdownInput)
+			sc.shutdownRecordProcessor(kcl.REQUESTED, recordCheckpointer)
 			return nil
 		case leaseRenewalErr := <-leaseRenewalErrChan:
-			return leaseRenewalErr
+			if leaseRenewalErr == nil {
+				return nil
+			}
+			return sc.shutdownOnLeaseError(leaseRenewalErr, recordCheckpointer)
 		default:
 		}
 	}
@@ -307,7 +314,9 @@ func (sc *PollingShardConsumer) callGetRecordsAPI(gri *kinesis.GetRecordsInput)
 	return getResp, 0, err
 }
 
-func (sc *PollingShardConsumer) renewLease(ctx context.Context, recordCheckpointer kcl.IRecordProcessorCheckpointer) error {
+// renewLease renews the lease of the shard until ctx is canceled. It returns errShardReleased when the shard must be
+// released, or the error of the failed renewal.
+func (sc *PollingShardConsumer) renewLease(ctx context.Context) error {
 	renewDuration := time.Duration(sc.kclConfig.LeaseRefreshWaitTime) * time.Millisecond
 	for {
 		timer := time.NewTimer(renewDuration)
@@ -316,29 +325,15 @@ func (sc *PollingShardConsumer) renewLease(ctx context.Context, recordCheckpoint
 			log.Debugf("Refreshing lease on shard: %s for worker: %s", sc.shard.ID, sc.consumerID)
 			err := sc.checkpointer.GetLease(sc.shard, sc.consumerID)
 			if err != nil {
-				// log and return error
-				log.Errorf("Error in refreshing lease on shard: %s for worker: %s. Error: %+v",
-					sc.shard.ID, sc.consumerID, err)
+				// the record processor is shut down by getRecords
 				return err
 			}
 
 			// Check if shard should be released (sticky=20)
 			// GetLease refreshes shard data including sticky value
-			if sc.shard.GetSticky() == 20 {
+			if sc.shard.GetStickyState() == par.StickyRelease {
 				log.Infof("Shard %s has sticky=20, initiating graceful release", sc.shard.ID)
-
-				// Checkpoint current progress
-				if err := recordCheckpointer.Checkpoint(nil); err != nil {
-					log.Errorf("Error checkpointing before release: %+v", err)
-				}
-
-				// Remove lease owner (clear AssignedTo field)
-				if err := sc.checkpointer.RemoveLeaseOwner(sc.shard.ID); err != nil {
-					log.Errorf("Error removing lease owner: %+v", err)
-				}
-
-				log.Infof("Successfully released shard %s (sticky=20)", sc.shard.ID)
-				return nil // Exit gracefully
+				return errShardReleased
 			}
 
 			// log metric for renewed lease for worker
@@ -21,12 +21,35 @@
 package worker
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
 	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
+var (
+	// ErrInvalidSequenceNumber is wrapped in the IllegalArgumentError returned when a sequence number is not valid
+	// or is smaller than the last checkpoint
+	ErrInvalidSequenceNumber = errors.New("InvalidSequenceNumber")
+
+	// ErrPrepareCheckpointNotSupported is returned by PrepareCheckpoint when the checkpointer
+	// can't persist pending checkpoints
+	ErrPrepareCheckpointNotSupported = errors.New("checkpointer does not support pending checkpoints")
+
+	// ErrTxCheckpointNotSupported is returned by CheckpointTx when the checkpointer can't write a checkpoint
+	// in a transaction
+	ErrTxCheckpointNotSupported = errors.New("checkpointer does not support transactional checkpoints")
+)
+
 type (
 
 	// PreparedCheckpointer
@@ -37,7 +60,7 @@ type (
 	 */
 	PreparedCheckpointer struct {
 		pendingCheckpointSequenceNumber *kcl.ExtendedSequenceNumber
-		checkpointer                    kcl.IRecordProcessorCheckpointer
+		checkpointer                    *RecordProcessorCheckpointer
 	}
 
 	//RecordProcessorCheckpointer
@@ -49,13 +72,39 @@ type (
 	RecordProcessorCheckpointer struct {
 		shard      *par.ShardStatus
 		checkpoint chk.Checkpointer
+
+		// validateSequenceNumbers enables the range checks of the checkpointed sequence numbers
+		validateSequenceNumbers bool
+		// sequenceNumberProbe, if set, checks that a sequence number belongs to the shard
+		sequenceNumberProbe func(sequenceNumber string) error
+		// policy is the automatic checkpointing applied by the shard consumer
+		policy config.CheckpointPolicy
+
+		mux sync.Mutex
+		// largestDeliveredSequenceNumber is the largest sequence number delivered to the record processor
+		largestDeliveredSequenceNumber *string
+		// lastProcessedSequenceNumber is the largest sequence number delivered to, and acknowledged by,
+		// the record processor (i.e. ProcessRecords has returned for the batch containing it).
+		lastProcessedSequenceNumber *string
+		// fencedErr is set once a checkpoint was rejected because another worker took the lease over or the shard
+		// was rewound
+		fencedErr error
+		// recordsSinceCheckpoint and lastCheckpointTime track the progress since the last checkpoint
+		recordsSinceCheckpoint int
+		lastCheckpointTime     time.Time
 	}
 )
 
 func NewRecordProcessorCheckpoint(shard *par.ShardStatus, checkpoint chk.Checkpointer) kcl.IRecordProcessorCheckpointer {
+	return newRecordProcessorCheckpointer(shard, checkpoint)
+}
+
+func newRecordProcessorCheckpointer(shard *par.ShardStatus, checkpoint chk.Checkpointer) *RecordProcessorCheckpointer {
 	return &RecordProcessorCheckpointer{
-		shard:      shard,
-		checkpoint: checkpoint,
+		shard:                   shard,
+		checkpoint:              checkpoint,
+		validateSequenceNumbers: true,
+		lastCheckpointTime:      time.Now(),
 	}
 }
 
@@ -63,21 +112,261 @@ func (pc *PreparedCheckpointer) GetPendingCheckpoint() *kcl.ExtendedSequenceNumb
 	return pc.pendingCheckpointSequenceNumber
 }
 
+// Checkpoint commits the pending checkpoint. The checkpoint is written and the pending checkpoint is
+// removed from the lease in a single update.
 func (pc *PreparedCheckpointer) Checkpoint() error {
 	return pc.checkpointer.Checkpoint(pc.pendingCheckpointSequenceNumber.SequenceNumber)
 }
 
 func (rc *RecordProcessorCheckpointer) Checkpoint(sequenceNumber *string) error {
+	return rc.checkpointAt(sequenceNumber, nil)
+}
+
+// CheckpointTx checkpoints the sequence number in the transaction of the record processor, with the same validation
+// as Checkpoint. The checkpoint is persisted when the transaction commits.
+func (rc *RecordProcessorCheckpointer) CheckpointTx(ctx context.Context, tx *sql.Tx, sequenceNumber *string) error {
+	txCheckpointer, ok := rc.checkpoint.(chk.TxCheckpointer)
+	if !ok {
+		return ErrTxCheckpointNotSupported
+	}
+
+	return rc.checkpointWith(sequenceNumber, nil, func() error {
+		return txCheckpointer.CheckpointSequenceTx(ctx, tx, rc.shard)
+	})
+}
+
+// CheckpointSubSequence checkpoints the progress within a record aggregated by the KPL
+func (rc *RecordProcessorCheckpointer) CheckpointSubSequence(sequenceNumber *string, subSequenceNumber int64) error {
+	if sequenceNumber == nil {
+		return invalidSequenceNumberError("a sub-sequence checkpoint needs a sequence number")
+	}
+
+	return rc.checkpointAt(sequenceNumber, &subSequenceNumber)
+}
+
+// checkpointAt checkpoints the sequence number, or the sub-sequence number within it if not nil
+func (rc *RecordProcessorCheckpointer) checkpointAt(sequenceNumber *string, subSequenceNumber *int64) error {
+	return rc.checkpointWith(sequenceNumber, subSequenceNumber, func() error {
+		return rc.checkpoint.CheckpointSequence(rc.shard)
+	})
+}
+
+// checkpointWith validates the checkpoint, sets it on the shard and writes it with write
+func (rc *RecordProcessorCheckpointer) checkpointWith(sequenceNumber *string, subSequenceNumber *int64, write func() error) error {
+	// never write over the progress of the worker which took the lease over, or over a rewind
+	if err := rc.fenced(); err != nil {
+		return err
+	}
+
+	if err := rc.validateSequenceNumber(sequenceNumber); err != nil {
+		return err
+	}
+	if err := rc.validateSubSequenceNumber(sequenceNumber, subSequenceNumber); err != nil {
+		return err
+	}
+
+	// the checkpointer writes the checkpoint set on the shard, the previous one is restored if the write fails so
+	// that the shard never holds a checkpoint which isn't in the lease table
+	previous, previousSubSequenceNumber := rc.shard.GetCheckpoint(), rc.shard.GetCheckpointSubSequenceNumber()
+
 	// checkpoint the last sequence of a closed shard
 	if sequenceNumber == nil {
 		rc.shard.SetCheckpoint(chk.ShardEnd)
 	} else {
 		rc.shard.SetCheckpoint(aws.ToString(sequenceNumber))
 	}
+	rc.shard.SetCheckpointSubSequenceNumber(subSequenceNumber)
+
+	if err := write(); err != nil {
+		rc.shard.SetCheckpoint(previous)
+		rc.shard.SetCheckpointSubSequenceNumber(previousSubSequenceNumber)
+		if errors.Is(err, chk.ErrLeaseLost) || errors.Is(err, chk.ErrRewindRequested) {
+			rc.setFenced(err)
+		}
+		return err
+	}
+
+	// the checkpoint commits or discards any pending checkpoint
+	rc.shard.SetPendingCheckpoint("")
+
+	rc.mux.Lock()
+	rc.recordsSinceCheckpoint = 0
+	rc.lastCheckpointTime = time.Now()
+	rc.mux.Unlock()
+	return nil
+}
+
+// CheckpointLastProcessed checkpoints the largest sequence number delivered to, and acknowledged by,
+// the record processor. It does nothing if no record has been processed since the shard was acquired.
+func (rc *RecordProcessorCheckpointer) CheckpointLastProcessed() error {
+	sequenceNumber := rc.LastProcessedSequenceNumber()
+	if sequenceNumber == nil {
+		return nil
+	}
+
+	// never move a finished shard back to an earlier position
+	if rc.shard.GetCheckpoint() == chk.ShardEnd {
+		return nil
+	}
+
+	return rc.Checkpoint(sequenceNumber)
+}
+
+// LastProcessedSequenceNumber returns the largest sequence number delivered to, and acknowledged by,
+// the record processor or nil if no record has been processed yet.
+func (rc *RecordProcessorCheckpointer) LastProcessedSequenceNumber() *string {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	return rc.lastProcessedSequenceNumber
+}
+
+// fenced returns the error of a checkpoint rejected because the lease is no longer held by this worker or the
+// shard was rewound, if any
+func (rc *RecordProcessorCheckpointer) fenced() error {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	return rc.fencedErr
+}
+
+func (rc *RecordProcessorCheckpointer) setFenced(err error) {
+	if !errors.As(err, &kcl.ShutdownError{}) {
+		err = kcl.ShutdownError{Message: "checkpoint of shard " + rc.shard.ID + " was rejected", Err: err}
+	}
+
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	rc.fencedErr = err
+}
+
+// setLargestDeliveredSequenceNumber is called by the shard consumer before a batch is delivered to the record processor.
+func (rc *RecordProcessorCheckpointer) setLargestDeliveredSequenceNumber(sequenceNumber *string) {
+	if sequenceNumber == nil {
+		return
+	}
+
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	rc.largestDeliveredSequenceNumber = sequenceNumber
+}
+
+func (rc *RecordProcessorCheckpointer) getLargestDeliveredSequenceNumber() *string {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	return rc.largestDeliveredSequenceNumber
+}
+
+// setLastProcessedSequenceNumber is called by the shard consumer once ProcessRecords returned for a batch.
+func (rc *RecordProcessorCheckpointer) setLastProcessedSequenceNumber(sequenceNumber *string) {
+	if sequenceNumber == nil {
+		return
+	}
+
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	rc.lastProcessedSequenceNumber = sequenceNumber
+}
+
+// PrepareCheckpoint validates the sequence number and stores it as the pending checkpoint of the lease.
+// A nil sequence number prepares the checkpoint of a closed shard (SHARD_END), like Checkpoint(nil).
+func (rc *RecordProcessorCheckpointer) PrepareCheckpoint(sequenceNumber *string) (kcl.IPreparedCheckpointer, error) {
+	pendingCheckpointer, ok := rc.checkpoint.(chk.PendingCheckpointer)
+	if !ok {
+		return nil, ErrPrepareCheckpointNotSupported
+	}
+
+	if err := rc.validateSequenceNumber(sequenceNumber); err != nil {
+		return nil, err
+	}
+
+	pending := chk.ShardEnd
+	if sequenceNumber != nil {
+		pending = aws.ToString(sequenceNumber)
+	}
+
+	previous := rc.shard.GetPendingCheckpoint()
+	rc.shard.SetPendingCheckpoint(pending)
+	if err := pendingCheckpointer.PrepareCheckpoint(rc.shard); err != nil {
+		rc.shard.SetPendingCheckpoint(previous)
+		return nil, err
+	}
+
+	return &PreparedCheckpointer{
+		pendingCheckpointSequenceNumber: &kcl.ExtendedSequenceNumber{SequenceNumber: aws.String(pending)},
+		checkpointer:                    rc,
+	}, nil
+}
+
+// validateSequenceNumber checks that a sequence number is a number which neither moves the checkpoint backwards
+// nor goes past the largest sequence number delivered to the record processor, unless it is the pending checkpoint
+// of the lease. If a probe is set, it also checks that the sequence number belongs to the shard. A nil sequence
+// number stands for SHARD_END.
+func (rc *RecordProcessorCheckpointer) validateSequenceNumber(sequenceNumber *string) error {
+	if !rc.validateSequenceNumbers || sequenceNumber == nil || aws.ToString(sequenceNumber) == chk.ShardEnd {
+		return nil
+	}
+
+	value, ok := new(big.Int).SetString(aws.ToString(sequenceNumber), 10)
+	if !ok || value.Sign() < 0 {
+		return invalidSequenceNumberError(fmt.Sprintf("%q is not a sequence number", aws.ToString(sequenceNumber)))
+	}
+
+	checkpoint := rc.shard.GetCheckpoint()
+	if checkpoint == chk.ShardEnd {
+		return invalidSequenceNumberError(fmt.Sprintf("shard %s is already checkpointed at %s", rc.shard.ID, chk.ShardEnd))
+	}
+
+	// the checkpoint may hold a non numeric value such as a sentinel, it can't be compared then
+	current, hasCurrent := new(big.Int).SetString(checkpoint, 10)
+	if hasCurrent && value.Cmp(current) < 0 {
+		return invalidSequenceNumberError(fmt.Sprintf("%s is smaller than the checkpoint %s", value, current))
+	}
+
+	// nothing past the checkpoint can be checkpointed before a record is delivered, except the checkpoint prepared
+	// by the previous owner of the shard which is committed before its records are delivered again
+	if pending, ok := new(big.Int).SetString(rc.shard.GetPendingCheckpoint(), 10); !ok || value.Cmp(pending) != 0 {
+		if delivered := rc.getLargestDeliveredSequenceNumber(); delivered != nil {
+			largest, ok := new(big.Int).SetString(aws.ToString(delivered), 10)
+			if ok && value.Cmp(largest) > 0 {
+				return invalidSequenceNumberError(fmt.Sprintf("%s is larger than the largest sequence number delivered %s", value, largest))
+			}
+		} else if !hasCurrent || value.Cmp(current) > 0 {
+			return invalidSequenceNumberError(fmt.Sprintf("%s is out of range, no record of shard %s has been delivered", value, rc.shard.ID))
+		}
+	}
+
+	if rc.sequenceNumberProbe != nil {
+		return rc.sequenceNumberProbe(value.String())
+	}
+
+	return nil
+}
+
+// validateSubSequenceNumber checks that a checkpoint within the aggregated record at the checkpoint doesn't move
+// the checkpoint backwards.
+func (rc *RecordProcessorCheckpointer) validateSubSequenceNumber(sequenceNumber *string, subSequenceNumber *int64) error {
+	if !rc.validateSequenceNumbers || subSequenceNumber == nil {
+		return nil
+	}
+
+	if *subSequenceNumber < 0 {
+		return invalidSequenceNumberError(fmt.Sprintf("%d is not a sub-sequence number", *subSequenceNumber))
+	}
+
+	if aws.ToString(sequenceNumber) != rc.shard.GetCheckpoint() {
+		return nil
+	}
+
+	current := rc.shard.GetCheckpointSubSequenceNumber()
+	if current == nil {
+		return invalidSequenceNumberError(fmt.Sprintf("the record %s is already checkpointed", aws.ToString(sequenceNumber)))
+	}
+	if *subSequenceNumber < *current {
+		return invalidSequenceNumberError(fmt.Sprintf("%d is smaller than the checkpointed sub-sequence number %d", *subSequenceNumber, *current))
+	}
 
-	return rc.checkpoint.CheckpointSequence(rc.shard)
+	return nil
 }
 
-func (rc *RecordProcessorCheckpointer) PrepareCheckpoint(_ *string) (kcl.IPreparedCheckpointer, error) {
-	return &PreparedCheckpointer{}, nil
+func invalidSequenceNumberError(message string) error {
+	return kcl.IllegalArgumentError{Message: message, Err: ErrInvalidSequenceNumber}
 }
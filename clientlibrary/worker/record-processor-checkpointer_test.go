@@ -0,0 +1,357 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	kcl "github.com/vmware/vmware-go-kcl-v2/clientlibrary/interfaces"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/metrics"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// mockCheckpointer records the checkpoints written through CheckpointSequence
+type mockCheckpointer struct {
+	chk.Checkpointer
+	checkpoints []string
+}
+
+func (m *mockCheckpointer) CheckpointSequence(shard *par.ShardStatus) error {
+	m.checkpoints = append(m.checkpoints, shard.GetCheckpoint())
+	return nil
+}
+
+type mockRecordProcessor struct {
+	processed       int
+	shutdownReasons []kcl.ShutdownReason
+}
+
+func (m *mockRecordProcessor) Initialize(_ *kcl.InitializationInput) {}
+
+func (m *mockRecordProcessor) ProcessRecords(input *kcl.ProcessRecordsInput) {
+	m.processed += len(input.Records)
+}
+
+func (m *mockRecordProcessor) Shutdown(input *kcl.ShutdownInput) {
+	m.shutdownReasons = append(m.shutdownReasons, input.ShutdownReason)
+}
+
+// processed simulates a batch ending at sequenceNumber delivered to, and acknowledged by, the record processor
+func processed(rc *RecordProcessorCheckpointer, sequenceNumber string) {
+	rc.setLargestDeliveredSequenceNumber(aws.String(sequenceNumber))
+	rc.setLastProcessedSequenceNumber(aws.String(sequenceNumber))
+}
+
+func TestCheckpointLastProcessedWithoutRecords(t *testing.T) {
+	checkpointer := &mockCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+
+	// nothing has been delivered yet: the existing checkpoint must be left untouched
+	assert.Nil(t, rc.CheckpointLastProcessed())
+	assert.Empty(t, checkpointer.checkpoints)
+	assert.Equal(t, "100", shard.GetCheckpoint())
+}
+
+func TestCheckpointLastProcessedNeverWritesShardEnd(t *testing.T) {
+	checkpointer := &mockCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+
+	processed(rc, "200")
+	assert.Nil(t, rc.CheckpointLastProcessed())
+	assert.Equal(t, []string{"200"}, checkpointer.checkpoints)
+
+	// a finished shard is never moved back
+	assert.Nil(t, rc.Checkpoint(nil))
+	assert.Nil(t, rc.CheckpointLastProcessed())
+	assert.Equal(t, []string{"200", chk.ShardEnd}, checkpointer.checkpoints)
+}
+
+func TestCheckpointValidation(t *testing.T) {
+	checkpointer := &mockCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+
+	// nothing has been delivered yet
+	assert.ErrorIs(t, rc.Checkpoint(aws.String("200")), ErrInvalidSequenceNumber)
+
+	rc.setLargestDeliveredSequenceNumber(aws.String("49590338271490256608559692538361571095921575989136588898"))
+	tests := []struct {
+		sequenceNumber string
+		valid          bool
+	}{
+		{"garbage", false},
+		{"-1", false},
+		{"99", false},
+		{"49590338271490256608559692538361571095921575989136588899", false},
+		{"100", true},
+		{"49590338271490256608559692538361571095921575989136588898", true},
+	}
+	for _, tt := range tests {
+		err := rc.Checkpoint(aws.String(tt.sequenceNumber))
+		assert.Equal(t, tt.valid, err == nil, tt.sequenceNumber)
+		if !tt.valid {
+			assert.True(t, errors.As(err, &kcl.IllegalArgumentError{}), tt.sequenceNumber)
+		}
+	}
+	assert.Equal(t, []string{"100", "49590338271490256608559692538361571095921575989136588898"}, checkpointer.checkpoints)
+
+	// the checkpoint can't move back, even with a sequence number that is larger as a string
+	assert.ErrorIs(t, rc.Checkpoint(aws.String("5")), ErrInvalidSequenceNumber)
+
+	// the validation can be turned off
+	rc.validateSequenceNumbers = false
+	assert.Nil(t, rc.Checkpoint(aws.String("5")))
+}
+
+func TestProcessRecordsTracksLastProcessedSequence(t *testing.T) {
+	checkpointer := &mockCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	processor := &mockRecordProcessor{}
+	sc := &commonShardConsumer{
+		shard:           shard,
+		checkpointer:    checkpointer,
+		recordProcessor: processor,
+		kclConfig:       config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"),
+		mService:        metrics.NoopMonitoringService{},
+	}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+
+	records := []types.Record{
+		{Data: []byte("a"), SequenceNumber: aws.String("300")},
+		{Data: []byte("b"), SequenceNumber: aws.String("301")},
+	}
+	sc.processRecords(time.Now(), records, aws.Int64(0), rc)
+	assert.Equal(t, 2, processor.processed)
+	assert.Equal(t, "301", aws.ToString(rc.LastProcessedSequenceNumber()))
+
+	// an empty batch does not reset the position
+	sc.processRecords(time.Now(), nil, aws.Int64(0), rc)
+	assert.Equal(t, "301", aws.ToString(rc.LastProcessedSequenceNumber()))
+
+	// the sticky=20 release path checkpoints the last processed record, not SHARD_END
+	assert.Nil(t, rc.CheckpointLastProcessed())
+	assert.Equal(t, []string{"301"}, checkpointer.checkpoints)
+}
+
+// pendingCheckpointer also records the pending checkpoints written through PrepareCheckpoint
+type pendingCheckpointer struct {
+	mockCheckpointer
+	pending []string
+}
+
+func (m *pendingCheckpointer) PrepareCheckpoint(shard *par.ShardStatus) error {
+	m.pending = append(m.pending, shard.GetPendingCheckpoint())
+	return nil
+}
+
+func TestPrepareCheckpoint(t *testing.T) {
+	checkpointer := &pendingCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+	rc.setLargestDeliveredSequenceNumber(aws.String("200"))
+
+	prepared, err := rc.PrepareCheckpoint(aws.String("200"))
+	assert.Nil(t, err)
+	assert.Equal(t, "200", aws.ToString(prepared.GetPendingCheckpoint().SequenceNumber))
+	assert.Equal(t, []string{"200"}, checkpointer.pending)
+	assert.Equal(t, "200", shard.GetPendingCheckpoint())
+	assert.Equal(t, "100", shard.GetCheckpoint())
+	assert.Empty(t, checkpointer.checkpoints)
+
+	// committing promotes the pending checkpoint
+	assert.Nil(t, prepared.Checkpoint())
+	assert.Equal(t, []string{"200"}, checkpointer.checkpoints)
+	assert.Equal(t, "200", shard.GetCheckpoint())
+	assert.Empty(t, shard.GetPendingCheckpoint())
+}
+
+func TestPrepareCheckpointValidation(t *testing.T) {
+	checkpointer := &pendingCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+	rc.setLargestDeliveredSequenceNumber(aws.String("300"))
+
+	_, err := rc.PrepareCheckpoint(aws.String("abc"))
+	assert.ErrorIs(t, err, ErrInvalidSequenceNumber)
+	assert.True(t, errors.As(err, &kcl.IllegalArgumentError{}))
+
+	_, err = rc.PrepareCheckpoint(aws.String("99"))
+	assert.ErrorIs(t, err, ErrInvalidSequenceNumber)
+	assert.Empty(t, checkpointer.pending)
+
+	// a prepared checkpoint overtaken by a later checkpoint can't move the checkpoint back
+	prepared, err := rc.PrepareCheckpoint(aws.String("200"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.Checkpoint(aws.String("300")))
+	assert.ErrorIs(t, prepared.Checkpoint(), ErrInvalidSequenceNumber)
+	assert.Equal(t, "300", shard.GetCheckpoint())
+
+	// checkpointers without pending checkpoint support are rejected
+	rc = newRecordProcessorCheckpointer(shard, &mockCheckpointer{})
+	_, err = rc.PrepareCheckpoint(aws.String("400"))
+	assert.ErrorIs(t, err, ErrPrepareCheckpointNotSupported)
+}
+
+func TestPrepareCheckpointHandoff(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig)
+
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	shard.SetCheckpoint("100")
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+	rc.setLargestDeliveredSequenceNumber(aws.String("200"))
+	_, err := rc.PrepareCheckpoint(aws.String("200"))
+	assert.Nil(t, err)
+	assert.Nil(t, checkpointer.RemoveLeaseOwner("0001"))
+
+	// the new owner commits the pending checkpoint before any record is delivered to it
+	handedOff := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(handedOff))
+	assert.Nil(t, checkpointer.GetLease(handedOff, "def"))
+	assert.Equal(t, "100", handedOff.GetCheckpoint())
+	assert.Equal(t, "200", handedOff.GetPendingCheckpoint())
+
+	rc = newRecordProcessorCheckpointer(handedOff, checkpointer)
+	assert.ErrorIs(t, rc.Checkpoint(aws.String("150")), ErrInvalidSequenceNumber)
+	assert.Nil(t, rc.Checkpoint(aws.String("200")))
+
+	fetched := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(fetched))
+	assert.Equal(t, "200", fetched.GetCheckpoint())
+	assert.Empty(t, fetched.GetPendingCheckpoint())
+}
+
+func TestInitializationInputPendingCheckpoint(t *testing.T) {
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	sc := &commonShardConsumer{shard: shard}
+
+	input := sc.initializationInput()
+	assert.Equal(t, "100", aws.ToString(input.ExtendedSequenceNumber.SequenceNumber))
+	assert.Nil(t, input.PendingCheckpointSequenceNumber)
+
+	shard.SetPendingCheckpoint("200")
+	input = sc.initializationInput()
+	assert.Equal(t, "200", aws.ToString(input.PendingCheckpointSequenceNumber.SequenceNumber))
+}
+
+// fencedCheckpointer rejects every checkpoint as if another worker took the lease over
+type fencedCheckpointer struct {
+	mockCheckpointer
+}
+
+func (m *fencedCheckpointer) CheckpointSequence(shard *par.ShardStatus) error {
+	m.checkpoints = append(m.checkpoints, shard.GetCheckpoint())
+	return chk.ErrLeaseLost
+}
+
+func TestCheckpointLeaseLost(t *testing.T) {
+	checkpointer := &fencedCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+	rc.setLargestDeliveredSequenceNumber(aws.String("300"))
+
+	assert.ErrorIs(t, rc.Checkpoint(aws.String("200")), chk.ErrLeaseLost)
+	assert.ErrorIs(t, rc.fenced(), chk.ErrLeaseLost)
+	// the rejected checkpoint isn't kept on the shard
+	assert.Equal(t, "100", shard.GetCheckpoint())
+
+	// later checkpoints fail without another write
+	err := rc.Checkpoint(aws.String("300"))
+	assert.ErrorIs(t, err, chk.ErrLeaseLost)
+	assert.True(t, errors.As(err, &kcl.ShutdownError{}))
+	assert.Equal(t, []string{"200"}, checkpointer.checkpoints)
+}
+
+func TestCheckpointSubSequence(t *testing.T) {
+	checkpointer := &mockCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	// record processors type-assert the checkpointer they are given
+	_, ok := NewRecordProcessorCheckpoint(shard, checkpointer).(kcl.IProgressRecordProcessorCheckpointer)
+	assert.True(t, ok)
+
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+	rc.setLargestDeliveredSequenceNumber(aws.String("200"))
+
+	assert.Nil(t, rc.CheckpointSubSequence(aws.String("200"), 2))
+	assert.Equal(t, "200", shard.GetCheckpoint())
+	assert.Equal(t, int64(2), *shard.GetCheckpointSubSequenceNumber())
+
+	// the checkpoint can't move back within the aggregated record
+	assert.ErrorIs(t, rc.CheckpointSubSequence(aws.String("200"), 1), ErrInvalidSequenceNumber)
+	assert.ErrorIs(t, rc.CheckpointSubSequence(aws.String("200"), -1), ErrInvalidSequenceNumber)
+	assert.ErrorIs(t, rc.CheckpointSubSequence(nil, 1), ErrInvalidSequenceNumber)
+	assert.Nil(t, rc.CheckpointSubSequence(aws.String("200"), 4))
+
+	// checkpointing the whole record clears the sub-sequence number
+	assert.Nil(t, rc.Checkpoint(aws.String("200")))
+	assert.Nil(t, shard.GetCheckpointSubSequenceNumber())
+	assert.ErrorIs(t, rc.CheckpointSubSequence(aws.String("200"), 5), ErrInvalidSequenceNumber)
+	assert.Equal(t, []string{"200", "200", "200"}, checkpointer.checkpoints)
+}
+
+// txCheckpointer records the checkpoints written in a transaction through CheckpointSequenceTx
+type txCheckpointer struct {
+	mockCheckpointer
+	txCheckpoints []string
+	err           error
+}
+
+func (m *txCheckpointer) CheckpointSequenceTx(_ context.Context, _ *sql.Tx, shard *par.ShardStatus) error {
+	m.txCheckpoints = append(m.txCheckpoints, shard.GetCheckpoint())
+	return m.err
+}
+
+func TestCheckpointTx(t *testing.T) {
+	checkpointer := &txCheckpointer{}
+	shard := &par.ShardStatus{ID: "0001", Checkpoint: "100", Mux: &sync.RWMutex{}}
+	rc := newRecordProcessorCheckpointer(shard, checkpointer)
+	rc.setLargestDeliveredSequenceNumber(aws.String("300"))
+
+	var _ kcl.ITxRecordProcessorCheckpointer = rc
+	assert.ErrorIs(t, rc.CheckpointTx(context.TODO(), nil, aws.String("99")), ErrInvalidSequenceNumber)
+	assert.Nil(t, rc.CheckpointTx(context.TODO(), nil, aws.String("200")))
+	assert.Equal(t, []string{"200"}, checkpointer.txCheckpoints)
+	assert.Empty(t, checkpointer.checkpoints)
+	assert.Equal(t, "200", shard.GetCheckpoint())
+
+	// a checkpoint fenced in the transaction fences the later checkpoints
+	checkpointer.err = chk.ErrLeaseLost
+	assert.ErrorIs(t, rc.CheckpointTx(context.TODO(), nil, aws.String("300")), chk.ErrLeaseLost)
+	assert.ErrorIs(t, rc.Checkpoint(aws.String("300")), chk.ErrLeaseLost)
+
+	// checkpointers without transaction support are rejected
+	rc = newRecordProcessorCheckpointer(shard, &mockCheckpointer{})
+	assert.ErrorIs(t, rc.CheckpointTx(context.TODO(), nil, aws.String("300")), ErrTxCheckpointNotSupported)
+}
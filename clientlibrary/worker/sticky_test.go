@@ -19,9 +19,13 @@
 package worker
 
 import (
+	"errors"
 	"sync"
 	"testing"
+	"time"
 
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
 	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
 )
 
@@ -412,3 +416,273 @@ func TestStickyRebalancingWithMixedNegativeValues(t *testing.T) {
 		t.Errorf("Expected 3 eligible shards, got %d", len(eligibleShards))
 	}
 }
+
+func TestStickyStateParse(t *testing.T) {
+	tests := []struct {
+		value    int
+		expected par.StickyState
+		valid    bool
+	}{
+		{-1, par.StickyUnset, true},
+		{0, par.StickyNormal, true},
+		{10, par.StickyPinned, true},
+		{20, par.StickyRelease, true},
+		{-5, par.StickyUnset, false},
+		{15, par.StickyUnset, false},
+		{100, par.StickyUnset, false},
+	}
+
+	for _, tt := range tests {
+		state, err := par.ParseStickyState(tt.value)
+		if tt.valid && (err != nil || state != tt.expected) {
+			t.Errorf("ParseStickyState(%d) = %s, %v, expected %s", tt.value, state, err, tt.expected)
+		}
+		if !tt.valid && !errors.Is(err, par.ErrInvalidStickyState) {
+			t.Errorf("ParseStickyState(%d) should fail with ErrInvalidStickyState, got %v", tt.value, err)
+		}
+	}
+
+	if par.StickyPinned.String() != "PINNED" || par.StickyState(15).String() != "INVALID(15)" {
+		t.Errorf("Unexpected sticky state names: %s, %s", par.StickyPinned, par.StickyState(15))
+	}
+}
+
+func TestGetStickyState(t *testing.T) {
+	tests := []struct {
+		name     string
+		sticky   int
+		expiry   time.Time
+		expected par.StickyState
+	}{
+		{"unset", -1, time.Time{}, par.StickyNormal},
+		{"invalid value", 15, time.Time{}, par.StickyNormal},
+		{"pinned", 10, time.Time{}, par.StickyPinned},
+		{"pinned until later", 10, time.Now().Add(time.Hour), par.StickyPinned},
+		{"expired pin", 10, time.Now().Add(-time.Second), par.StickyNormal},
+		{"release ignores expiry", 20, time.Now().Add(-time.Second), par.StickyRelease},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shard := &par.ShardStatus{ID: "shard-1", Sticky: tt.sticky, StickyExpiry: tt.expiry, Mux: &sync.RWMutex{}}
+			if state := shard.GetStickyState(); state != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, state)
+			}
+			// the raw value is preserved so that it is written back unchanged
+			if shard.GetSticky() != tt.sticky {
+				t.Errorf("Expected raw sticky value %d, got %d", tt.sticky, shard.GetSticky())
+			}
+		})
+	}
+}
+
+// claimCheckpointer records the claims placed through ClaimShard
+type claimCheckpointer struct {
+	chk.Checkpointer
+	claims []string
+}
+
+func (c *claimCheckpointer) ClaimShard(shard *par.ShardStatus, claimID string) error {
+	c.claims = append(c.claims, claimID)
+	shard.SetClaimRequest(claimID)
+	return nil
+}
+
+func newStickyTestWorker(workerID string, stickyFailoverMillis int) (*Worker, *claimCheckpointer) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", workerID).
+		WithStickyFailoverMillis(stickyFailoverMillis)
+	checkpointer := &claimCheckpointer{}
+	return NewWorker(nil, kclConfig).WithCheckpointer(checkpointer), checkpointer
+}
+
+func TestStickyFailover(t *testing.T) {
+	expiredLease := time.Now().Add(-time.Minute).UTC()
+	recentLease := time.Now().Add(-time.Second).UTC()
+
+	tests := []struct {
+		name         string
+		failover     int
+		leaseOwner   string
+		leaseTimeout time.Time
+		allowed      bool
+	}{
+		{"failover disabled", 0, "worker-1", expiredLease, false},
+		{"pinned worker within failover window", 30000, "worker-1", recentLease, false},
+		{"pinned worker dead", 30000, "worker-1", expiredLease, true},
+		{"pinned worker dead and lease released", 30000, "", expiredLease, true},
+		{"pinned shard never leased", 30000, "", time.Time{}, true},
+		{"taken over by another worker", 30000, "worker-3", expiredLease, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := newStickyTestWorker("worker-2", tt.failover)
+			shard := &par.ShardStatus{
+				ID:           "shard-1",
+				AssignedTo:   tt.leaseOwner,
+				LeaseTimeout: tt.leaseTimeout,
+				Sticky:       int(par.StickyPinned),
+				StickyWorker: "worker-1",
+				Mux:          &sync.RWMutex{},
+			}
+			if allowed := w.stickyAcquisitionAllowed(shard); allowed != tt.allowed {
+				t.Errorf("Expected acquisition allowed=%v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestStickyWorkerClaimsShardBack(t *testing.T) {
+	w, checkpointer := newStickyTestWorker("worker-1", 30000)
+	shard := &par.ShardStatus{
+		ID:           "shard-1",
+		AssignedTo:   "worker-2",
+		LeaseTimeout: time.Now().Add(time.Minute).UTC(),
+		Sticky:       int(par.StickyPinned),
+		StickyWorker: "worker-1",
+		Mux:          &sync.RWMutex{},
+	}
+
+	if !w.stickyAcquisitionAllowed(shard) {
+		t.Errorf("Pinned worker should be allowed to acquire its shard")
+	}
+	if len(checkpointer.claims) != 1 || checkpointer.claims[0] != "worker-1" {
+		t.Fatalf("Expected the pinned worker to claim the shard back, got claims %v", checkpointer.claims)
+	}
+
+	// the claim is only placed once
+	w.stickyAcquisitionAllowed(shard)
+	if len(checkpointer.claims) != 1 {
+		t.Errorf("Expected a single claim, got %v", checkpointer.claims)
+	}
+
+	// nothing to claim once the pinned worker holds the lease again
+	checkpointer.claims = nil
+	shard.SetLeaseOwner("worker-1")
+	shard.SetClaimRequest("")
+	w.stickyAcquisitionAllowed(shard)
+	if len(checkpointer.claims) != 0 {
+		t.Errorf("Expected no claim, got %v", checkpointer.claims)
+	}
+}
+
+func TestStickyReleaseNeverAcquired(t *testing.T) {
+	w, _ := newStickyTestWorker("worker-1", 30000)
+	shard := &par.ShardStatus{ID: "shard-1", Sticky: int(par.StickyRelease), StickyWorker: "worker-1", Mux: &sync.RWMutex{}}
+
+	if w.stickyAcquisitionAllowed(shard) {
+		t.Errorf("Shards marked for release must never be acquired")
+	}
+}
+
+func TestShardAffinityAllowed(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", "worker-1").
+		WithWorkerLabels(map[string]string{"zone": "us-west-2a", "tier": "ondemand"})
+	w := NewWorker(nil, kclConfig)
+
+	var err error
+	w.shardAffinity, err = config.NewShardAffinity([]config.ShardAffinityRule{
+		{ShardIDPattern: "^shard-1$", Selector: "zone=us-west-2a"},
+		{ShardIDPattern: "^shard-2$", Selector: "zone=us-west-2b"},
+		{StartingHashKey: "0", EndingHashKey: "100", Selector: "tier=spot"},
+	})
+	if err != nil {
+		t.Fatalf("Invalid affinity rules: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		shard   *par.ShardStatus
+		allowed bool
+	}{
+		{"no rule", &par.ShardStatus{ID: "shard-9", Mux: &sync.RWMutex{}}, true},
+		{"matching rule", &par.ShardStatus{ID: "shard-1", Mux: &sync.RWMutex{}}, true},
+		{"rule for another zone", &par.ShardStatus{ID: "shard-2", Mux: &sync.RWMutex{}}, false},
+		{"hash range rule", &par.ShardStatus{ID: "shard-9", StartingHashKey: "42", Mux: &sync.RWMutex{}}, false},
+		{"outside hash range rule", &par.ShardStatus{ID: "shard-9", StartingHashKey: "420", Mux: &sync.RWMutex{}}, true},
+		{"group pin overrides rules", &par.ShardStatus{ID: "shard-2", Sticky: int(par.StickyPinned), StickyGroup: "tier=ondemand", Mux: &sync.RWMutex{}}, true},
+		{"group pin of another group", &par.ShardStatus{ID: "shard-1", Sticky: int(par.StickyPinned), StickyGroup: "tier!=ondemand", Mux: &sync.RWMutex{}}, false},
+		{"group ignored when not pinned", &par.ShardStatus{ID: "shard-1", Sticky: int(par.StickyNormal), StickyGroup: "tier=spot", Mux: &sync.RWMutex{}}, true},
+		{"invalid group", &par.ShardStatus{ID: "shard-9", Sticky: int(par.StickyPinned), StickyGroup: "tier==", Mux: &sync.RWMutex{}}, false},
+		{"pinned to this worker", &par.ShardStatus{ID: "shard-2", Sticky: int(par.StickyPinned), StickyWorker: "worker-1", Mux: &sync.RWMutex{}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := w.shardAffinityAllowed(tt.shard); allowed != tt.allowed {
+				t.Errorf("Expected affinity allowed=%v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestRebalanceShardAffinityHashRange(t *testing.T) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", "worker-1").
+		WithWorkerLabels(map[string]string{"tier": "ondemand"})
+	checkpointer := chk.NewMemoryCheckpoint(kclConfig)
+	w := NewWorker(nil, kclConfig).WithCheckpointer(checkpointer)
+
+	var err error
+	w.shardAffinity, err = config.NewShardAffinity([]config.ShardAffinityRule{
+		{StartingHashKey: "0", EndingHashKey: "100", Selector: "tier=spot"},
+	})
+	if err != nil {
+		t.Fatalf("Invalid affinity rules: %v", err)
+	}
+
+	// worker-2 holds all shards, only shard-3 is outside of the hash key range restricted to spot workers
+	w.shardStatus = map[string]*par.ShardStatus{}
+	for shardID, startingHashKey := range map[string]string{"shard-1": "10", "shard-2": "42", "shard-3": "420"} {
+		w.shardStatus[shardID] = &par.ShardStatus{ID: shardID, StartingHashKey: startingHashKey, Mux: &sync.RWMutex{}}
+
+		lease := &par.ShardStatus{ID: shardID, Sticky: int(par.StickyUnset), Mux: &sync.RWMutex{}}
+		if err := checkpointer.GetLease(lease, "worker-2"); err != nil {
+			t.Fatalf("GetLease failed: %v", err)
+		}
+		lease.SetCheckpoint("100")
+		if err := checkpointer.CheckpointSequence(lease); err != nil {
+			t.Fatalf("CheckpointSequence failed: %v", err)
+		}
+	}
+
+	// the leases listed from the lease table have no hash key range, the one of the shard listing applies
+	if err := w.rebalance(); err != nil {
+		t.Fatalf("rebalance failed: %v", err)
+	}
+
+	leases, err := checkpointer.ListLeases()
+	if err != nil {
+		t.Fatalf("ListLeases failed: %v", err)
+	}
+	for _, lease := range leases {
+		expected := ""
+		if lease.ID == "shard-3" {
+			expected = "worker-1"
+		}
+		if lease.ClaimRequest != expected {
+			t.Errorf("Expected claim request %q on %s, got %q", expected, lease.ID, lease.ClaimRequest)
+		}
+	}
+}
+
+func TestStickyGroupShardAcquisition(t *testing.T) {
+	w, _ := newStickyTestWorker("worker-2", 0)
+	shard := &par.ShardStatus{
+		ID:           "shard-1",
+		AssignedTo:   "worker-1",
+		Sticky:       int(par.StickyPinned),
+		StickyGroup:  "tier=ondemand",
+		LeaseTimeout: time.Now().Add(-time.Minute),
+		Mux:          &sync.RWMutex{},
+	}
+
+	// unlike a plain pin, a group pin does not stick to the current owner: any member can take the expired lease
+	if !w.stickyAcquisitionAllowed(shard) {
+		t.Errorf("Group members should be allowed to acquire a group pinned shard")
+	}
+
+	shard.SetStickyGroup("")
+	if w.stickyAcquisitionAllowed(shard) {
+		t.Errorf("A pinned shard without group must stay with its current owner")
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package worker
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// ErrDrainNotSupported is returned by Drain when the checkpointer cannot hand leases over (see checkpoint.LeaseDrainer)
+var ErrDrainNotSupported = errors.New("DrainNotSupported")
+
+// DrainStatus is the drain state reported by the DrainHandler
+type DrainStatus struct {
+	WorkerID string `json:"workerId"`
+	Draining bool   `json:"draining"`
+	Leases   int    `json:"leases"`
+	Drained  bool   `json:"drained"`
+}
+
+// Drain stops the worker from acquiring new leases and hands the leases it holds over to other workers, one shard at
+// a time. A shard is checkpointed and released only once another live worker has claimed it, so shards are never
+// left unprocessed. Wait on Drained before calling Shutdown, e.g. in a preStop hook.
+func (w *Worker) Drain() error {
+	if w.checkpointer != nil {
+		if _, ok := w.checkpointer.(chk.LeaseDrainer); !ok {
+			return ErrDrainNotSupported
+		}
+	}
+
+	if w.draining.CompareAndSwap(false, true) {
+		w.kclConfig.Logger.Infof("Worker %s is draining", w.workerID)
+	}
+	return nil
+}
+
+// IsDraining returns true once Drain has been called
+func (w *Worker) IsDraining() bool {
+	return w.draining.Load()
+}
+
+// LeaseCount returns the number of leases held by the worker, as of the last event loop iteration
+func (w *Worker) LeaseCount() int {
+	return int(w.leaseCount.Load())
+}
+
+// Drained returns a channel which is closed once the worker is draining and holds no lease
+func (w *Worker) Drained() <-chan struct{} {
+	return w.drained
+}
+
+// DrainStatus returns the current drain state of the worker
+func (w *Worker) DrainStatus() DrainStatus {
+	status := DrainStatus{WorkerID: w.workerID, Draining: w.IsDraining(), Leases: w.LeaseCount()}
+	select {
+	case <-w.drained:
+		status.Drained = true
+	default:
+	}
+	return status
+}
+
+// DrainHandler returns an HTTP handler to drain the worker. GET reports the DrainStatus, POST starts draining and,
+// with the "wait" query parameter, blocks until the worker holds no lease or the request is canceled.
+func (w *Worker) DrainHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := w.Drain(); err != nil {
+				http.Error(rw, err.Error(), http.StatusNotImplemented)
+				return
+			}
+			if r.URL.Query().Has("wait") {
+				select {
+				case <-w.drained:
+				case <-r.Context().Done():
+					return
+				}
+			}
+		default:
+			rw.Header().Set("Allow", "GET, POST")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(w.DrainStatus())
+	})
+}
+
+// drainLeases hands over the leases held by a draining worker: it flags one lease at a time for other workers
+// to claim, and signals Drained once no lease is left.
+func (w *Worker) drainLeases(leaseCount int) {
+	log := w.kclConfig.Logger
+
+	if leaseCount == 0 {
+		w.drainedOnce.Do(func() {
+			log.Infof("Worker %s is drained: no lease held", w.workerID)
+			close(w.drained)
+		})
+		return
+	}
+
+	// wait until the lease being handed over has been released
+	if shard, ok := w.shardStatus[w.drainingShard]; ok && shard.GetLeaseOwner() == w.workerID {
+		log.Debugf("Waiting for another worker to claim shard %s, %d leases left", shard.ID, leaseCount)
+		return
+	}
+
+	drainer, ok := w.checkpointer.(chk.LeaseDrainer)
+	if !ok {
+		return
+	}
+
+	for _, shard := range w.shardStatus {
+		if shard.GetLeaseOwner() != w.workerID || shard.GetCheckpoint() == chk.ShardEnd {
+			continue
+		}
+
+		if err := drainer.MarkLeaseDraining(shard); err != nil {
+			log.Warnf("Cannot hand over shard %s: %+v", shard.ID, err)
+			continue
+		}
+		w.drainingShard = shard.ID
+		return
+	}
+}
+
+// claimDrainingShard claims a shard whose owner is draining. The owner checkpoints and releases the shard on its
+// next lease renewal, after which this worker acquires it.
+func (w *Worker) claimDrainingShard(shard *par.ShardStatus) {
+	log := w.kclConfig.Logger
+
+	if shard.GetClaimRequest() != "" || shard.GetStickyState() == par.StickyRelease || !w.shardAffinityAllowed(shard) {
+		return
+	}
+
+	if err := w.checkpointer.ClaimShard(shard, w.workerID); err != nil {
+		log.Debugf("Cannot claim shard %s from draining worker %s: %+v", shard.ID, shard.GetLeaseOwner(), err)
+		return
+	}
+	log.Infof("Claimed shard %s from draining worker %s", shard.ID, shard.GetLeaseOwner())
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// drainCheckpointer records the leases flagged for handover and the claims placed through ClaimShard
+type drainCheckpointer struct {
+	claimCheckpointer
+	drained []string
+}
+
+func (d *drainCheckpointer) MarkLeaseDraining(shard *par.ShardStatus) error {
+	d.drained = append(d.drained, shard.ID)
+	shard.SetDraining(shard.GetLeaseOwner())
+	return nil
+}
+
+func newDrainTestWorker(workerID string) (*Worker, *drainCheckpointer) {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "streamName", "us-west-2", workerID)
+	checkpointer := &drainCheckpointer{}
+	return NewWorker(nil, kclConfig).WithCheckpointer(checkpointer), checkpointer
+}
+
+func TestDrainNotSupported(t *testing.T) {
+	w, _ := newStickyTestWorker("worker-1", 0)
+	assert.Equal(t, ErrDrainNotSupported, w.Drain())
+	assert.False(t, w.IsDraining())
+}
+
+func TestDrainLeasesOneAtATime(t *testing.T) {
+	w, checkpointer := newDrainTestWorker("worker-1")
+	w.shardStatus = map[string]*par.ShardStatus{
+		"shard-1": {ID: "shard-1", AssignedTo: "worker-1", Mux: &sync.RWMutex{}},
+		"shard-2": {ID: "shard-2", AssignedTo: "worker-1", Mux: &sync.RWMutex{}},
+		"shard-3": {ID: "shard-3", AssignedTo: "worker-2", Mux: &sync.RWMutex{}},
+		"shard-4": {ID: "shard-4", AssignedTo: "worker-1", Checkpoint: chk.ShardEnd, Mux: &sync.RWMutex{}},
+	}
+	assert.Nil(t, w.Drain())
+
+	w.drainLeases(2)
+	assert.Equal(t, 1, len(checkpointer.drained))
+	first := checkpointer.drained[0]
+
+	// nothing else is flagged until the first shard has been claimed and released
+	w.drainLeases(2)
+	assert.Equal(t, 1, len(checkpointer.drained))
+
+	w.shardStatus[first].SetLeaseOwner("")
+	w.drainLeases(1)
+	if assert.Equal(t, 2, len(checkpointer.drained)) {
+		assert.NotEqual(t, first, checkpointer.drained[1])
+	}
+
+	select {
+	case <-w.Drained():
+		t.Fatal("Worker reported drained while holding a lease")
+	default:
+	}
+
+	w.drainLeases(0)
+	select {
+	case <-w.Drained():
+	default:
+		t.Fatal("Worker holding no lease should be drained")
+	}
+	assert.True(t, w.DrainStatus().Drained)
+}
+
+func TestClaimDrainingShard(t *testing.T) {
+	w, checkpointer := newDrainTestWorker("worker-2")
+	shard := &par.ShardStatus{ID: "shard-1", AssignedTo: "worker-1", Draining: "worker-1", Mux: &sync.RWMutex{}}
+
+	w.claimDrainingShard(shard)
+	assert.Equal(t, []string{"worker-2"}, checkpointer.claims)
+
+	// already claimed
+	w.claimDrainingShard(shard)
+	assert.Equal(t, 1, len(checkpointer.claims))
+
+	// shards marked for release stay where they are
+	released := &par.ShardStatus{ID: "shard-2", AssignedTo: "worker-1", Draining: "worker-1", Sticky: int(par.StickyRelease), Mux: &sync.RWMutex{}}
+	w.claimDrainingShard(released)
+	assert.Equal(t, 1, len(checkpointer.claims))
+
+	// a shard pinned to the draining worker is acquired by the claimant once released
+	pinned := &par.ShardStatus{ID: "shard-3", Sticky: int(par.StickyPinned), StickyWorker: "worker-1", ClaimRequest: "worker-2", Mux: &sync.RWMutex{}}
+	assert.True(t, w.stickyAcquisitionAllowed(pinned))
+}
+
+func TestDrainHandler(t *testing.T) {
+	w, _ := newDrainTestWorker("worker-1")
+	w.leaseCount.Store(3)
+	handler := w.DrainHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/drain", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var status DrainStatus
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, DrainStatus{WorkerID: "worker-1", Leases: 3}, status)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/drain", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, w.IsDraining())
+
+	// blocks until the worker holds no lease
+	w.drainLeases(0)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/drain?wait", nil))
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.True(t, status.Drained)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/drain", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package worker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// leaseCache is the lease table snapshot shared by the shard acquisition loop, the rebalancer and the shard
+// consumers waiting on their parent shard. The lease table is scanned when the snapshot is older than what the
+// reader accepts, so a worker reads it once per scan instead of once per shard: only the conditional writes
+// acquiring a lease go to the lease table directly, after a strongly consistent read of the lease row.
+type leaseCache struct {
+	checkpointer chk.Checkpointer
+	// reader is nil if the checkpointer can't scan the lease table, its checkpoints are then fetched one by one
+	reader chk.LeaseSnapshotReader
+
+	mux         sync.Mutex
+	snapshot    *chk.LeaseSnapshot
+	err         error
+	refreshedAt time.Time
+}
+
+func newLeaseCache(checkpointer chk.Checkpointer) *leaseCache {
+	reader, _ := checkpointer.(chk.LeaseSnapshotReader)
+	return &leaseCache{checkpointer: checkpointer, reader: reader}
+}
+
+// fetchCheckpoint is Checkpointer.FetchCheckpoint reading the lease of the shard from a snapshot at most maxAge old
+func (c *leaseCache) fetchCheckpoint(shard *par.ShardStatus, maxAge time.Duration) error {
+	if c.reader == nil {
+		return c.checkpointer.FetchCheckpoint(shard)
+	}
+
+	snapshot, err := c.refresh(maxAge)
+	if errors.Is(err, chk.ErrNotSupported) {
+		// a decorator such as AsyncCheckpointer wrapping a checkpointer that can't scan the lease table
+		return c.checkpointer.FetchCheckpoint(shard)
+	}
+	if err != nil {
+		return err
+	}
+	return c.reader.FetchCheckpointFromSnapshot(snapshot, shard)
+}
+
+// refresh returns the snapshot, scanning the lease table again if the last scan is older than maxAge. Concurrent
+// readers share a single scan, and a failed scan isn't retried before maxAge either.
+func (c *leaseCache) refresh(maxAge time.Duration) (*chk.LeaseSnapshot, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if !c.refreshedAt.IsZero() && time.Since(c.refreshedAt) < maxAge {
+		return c.snapshot, c.err
+	}
+
+	c.refreshedAt = time.Now()
+	snapshot, err := c.reader.ScanLeases()
+	if err != nil {
+		c.err = err
+		return nil, err
+	}
+	c.snapshot, c.err = snapshot, nil
+	return snapshot, nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package worker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// scanCheckpointer serves the checkpoints of its leases from lease table scans, counting them
+type scanCheckpointer struct {
+	chk.Checkpointer
+	leases []*par.ShardStatus
+	scans  int
+	err    error
+}
+
+func (s *scanCheckpointer) ScanLeases() (*chk.LeaseSnapshot, error) {
+	s.scans++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return chk.NewLeaseSnapshot(time.Now(), s.leases), nil
+}
+
+func (s *scanCheckpointer) FetchCheckpointFromSnapshot(snapshot *chk.LeaseSnapshot, shard *par.ShardStatus) error {
+	lease, ok := snapshot.Lease(shard.ID)
+	if !ok || lease.Checkpoint == "" {
+		return chk.ErrSequenceIDNotFound
+	}
+	shard.SetCheckpoint(lease.Checkpoint)
+	shard.SetLeaseOwner(lease.AssignedTo)
+	return nil
+}
+
+func TestLeaseCacheSharesScans(t *testing.T) {
+	checkpointer := &scanCheckpointer{leases: []*par.ShardStatus{
+		{ID: "0001", Checkpoint: chk.ShardEnd, Mux: &sync.RWMutex{}},
+		{ID: "0002", Checkpoint: "100", AssignedTo: "worker-2", Mux: &sync.RWMutex{}},
+	}}
+	leases := newLeaseCache(checkpointer)
+
+	parent := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+	shard := &par.ShardStatus{ID: "0002", Mux: &sync.RWMutex{}}
+	assert.Nil(t, leases.fetchCheckpoint(parent, time.Minute))
+	assert.Nil(t, leases.fetchCheckpoint(shard, time.Minute))
+	assert.ErrorIs(t, leases.fetchCheckpoint(&par.ShardStatus{ID: "0003", Mux: &sync.RWMutex{}}, time.Minute),
+		chk.ErrSequenceIDNotFound)
+	assert.Equal(t, chk.ShardEnd, parent.GetCheckpoint())
+	assert.Equal(t, "worker-2", shard.GetLeaseOwner())
+	assert.Equal(t, 1, checkpointer.scans)
+
+	// an older snapshot is scanned again
+	assert.Nil(t, leases.fetchCheckpoint(shard, 0))
+	assert.Equal(t, 2, checkpointer.scans)
+}
+
+func TestLeaseCacheScanError(t *testing.T) {
+	checkpointer := &scanCheckpointer{err: errors.New("throttled")}
+	leases := newLeaseCache(checkpointer)
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+
+	// a failed scan is not retried by every shard
+	assert.Equal(t, checkpointer.err, leases.fetchCheckpoint(shard, time.Minute))
+	assert.Equal(t, checkpointer.err, leases.fetchCheckpoint(shard, time.Minute))
+	assert.Equal(t, 1, checkpointer.scans)
+
+	checkpointer.err = nil
+	assert.ErrorIs(t, leases.fetchCheckpoint(shard, 0), chk.ErrSequenceIDNotFound)
+	assert.Equal(t, 2, checkpointer.scans)
+}
+
+// countingCheckpointer records the checkpoints fetched one by one
+type countingCheckpointer struct {
+	chk.Checkpointer
+	fetched []string
+}
+
+func (f *countingCheckpointer) FetchCheckpoint(shard *par.ShardStatus) error {
+	f.fetched = append(f.fetched, shard.ID)
+	shard.SetCheckpoint("100")
+	return nil
+}
+
+func TestLeaseCacheWithoutScans(t *testing.T) {
+	checkpointer := &countingCheckpointer{}
+	leases := newLeaseCache(checkpointer)
+	shard := &par.ShardStatus{ID: "0001", Mux: &sync.RWMutex{}}
+
+	assert.Nil(t, leases.fetchCheckpoint(shard, time.Minute))
+	assert.Nil(t, leases.fetchCheckpoint(shard, time.Minute))
+	assert.Equal(t, []string{"0001", "0001"}, checkpointer.fetched)
+	assert.Equal(t, "100", shard.GetCheckpoint())
+}
@@ -35,6 +35,7 @@ import (
 	"errors"
 	"math/big"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -71,7 +72,18 @@ type Worker struct {
 	randomSeed int64
 
 	shardStatus          map[string]*par.ShardStatus
+	leases               *leaseCache
 	shardStealInProgress bool
+	shardAffinity        *config.ShardAffinity
+
+	// listedShards are the shard IDs of the last Kinesis shard listing, read by the consumers waiting on a parent
+	listedShards atomic.Pointer[map[string]bool]
+
+	draining      atomic.Bool
+	drained       chan struct{}
+	drainedOnce   sync.Once
+	drainingShard string
+	leaseCount    atomic.Int32
 }
 
 // NewWorker constructs a Worker instance for processing Kinesis stream data.
@@ -91,6 +103,7 @@ func NewWorker(factory kcl.IRecordProcessorFactory, kclConfig *config.KinesisCli
 		mService:         mService,
 		done:             false,
 		randomSeed:       time.Now().UTC().UnixNano(),
+		drained:          make(chan struct{}),
 	}
 }
 
@@ -145,6 +158,13 @@ func (w *Worker) Shutdown() {
 	w.done = true
 	w.waitGroup.Wait()
 
+	// write the checkpoints buffered by the stopped shard consumers
+	if flusher, ok := w.checkpointer.(chk.CheckpointFlusher); ok {
+		if err := flusher.Flush(); err != nil {
+			log.Errorf("Failed to flush checkpoints: %+v", err)
+		}
+	}
+
 	w.mService.Shutdown()
 	log.Infof("Worker loop is complete. Exiting from worker.")
 }
@@ -198,11 +218,16 @@ func (w *Worker) initialize() error {
 		log.Infof("Use custom checkpointer implementation.")
 	}
 
+	var err error
+	if w.shardAffinity, err = config.NewShardAffinity(w.kclConfig.ShardAffinityRules); err != nil {
+		log.Errorf("Invalid shard affinity rules: %+v", err)
+		return err
+	}
+
 	if w.kclConfig.EnableEnhancedFanOutConsumer {
 		log.Debugf("Enhanced fan-out is enabled")
 		w.consumerARN = w.kclConfig.EnhancedFanOutConsumerARN
 		if w.consumerARN == "" {
-			var err error
 			w.consumerARN, err = w.fetchConsumerARNWithRetry()
 			if err != nil {
 				log.Errorf("Failed to fetch consumer ARN for: %s, %v", w.kclConfig.EnhancedFanOutConsumerName, err)
@@ -211,7 +236,7 @@ func (w *Worker) initialize() error {
 		}
 	}
 
-	err := w.mService.Init(w.kclConfig.ApplicationName, w.streamName, w.workerID)
+	err = w.mService.Init(w.kclConfig.ApplicationName, w.streamName, w.workerID)
 	if err != nil {
 		log.Errorf("Failed to start monitoring service: %+v", err)
 	}
@@ -223,6 +248,7 @@ func (w *Worker) initialize() error {
 	}
 
 	w.shardStatus = make(map[string]*par.ShardStatus)
+	w.leases = newLeaseCache(w.checkpointer)
 
 	stopChan := make(chan struct{})
 	w.stop = &stopChan
@@ -240,6 +266,8 @@ func (w *Worker) newShardConsumer(shard *par.ShardStatus) shardConsumer {
 		shard:           shard,
 		kc:              w.kc,
 		checkpointer:    w.checkpointer,
+		leases:          w.leases,
+		isShardListed:   w.isShardListed,
 		recordProcessor: w.processorFactory.CreateProcessor(),
 		kclConfig:       w.kclConfig,
 		mService:        w.mService,
@@ -303,16 +331,25 @@ func (w *Worker) eventLoop() {
 				counter++
 			}
 		}
+		w.leaseCount.Store(int32(counter))
+
+		// A draining worker hands its leases over and neither acquires nor steals shards
+		if w.draining.Load() {
+			w.drainLeases(counter)
+			continue
+		}
 
 		// max number of lease has not been reached yet
 		if counter < w.kclConfig.MaxLeasesForWorker {
+			// the leases are read from one scan of the lease table per iteration, shared with the shard consumers
+			snapshotMaxAge := time.Duration(w.kclConfig.ShardSyncIntervalMillis/2) * time.Millisecond
 			for _, shard := range w.shardStatus {
 				// already owner of the shard
 				if shard.GetLeaseOwner() == w.workerID {
 					continue
 				}
 
-				err := w.checkpointer.FetchCheckpoint(shard)
+				err := w.leases.fetchCheckpoint(shard, snapshotMaxAge)
 				if err != nil {
 					// checkpoint may not exist yet is not an error condition.
 					if err != chk.ErrSequenceIDNotFound {
@@ -327,35 +364,20 @@ func (w *Worker) eventLoop() {
 					continue
 				}
 
-				// Skip sticky shards (sticky=10) that are assigned to other workers
-				// Sticky shards are pinned and cannot be acquired by other workers
-				// BUT allow the current worker to renew its own sticky shard leases
-				//
-				// IMPORTANT: Also check StickyWorker attribute for persistent binding
-				// If StickyWorker is set, ONLY that specific worker can acquire this shard
-				// This ensures sticky shards return to their designated worker even after restart
-				if shard.GetSticky() == 10 {
-					stickyWorker := shard.GetStickyWorker()
-
-					// If shard has a StickyWorker binding, only that worker can claim it
-					if stickyWorker != "" && stickyWorker != w.workerID {
-						log.Debugf("Shard %s is pinned to worker %s (current: %s), skipping",
-							shard.ID, stickyWorker, w.workerID)
-						continue
-					}
+				// The owner is draining: claim the shard, it is handed over on the owner's next lease renewal
+				if shard.IsDraining() {
+					w.claimDrainingShard(shard)
+					continue
+				}
 
-					// If shard is currently assigned to a different worker (but no StickyWorker binding)
-					// respect that assignment (prevent rebalancing)
-					if shard.GetLeaseOwner() != "" && shard.GetLeaseOwner() != w.workerID {
-						log.Debugf("Shard %s is sticky and assigned to worker %s, skipping",
-							shard.ID, shard.GetLeaseOwner())
-						continue
-					}
+				// Skip shards pinned to or reserved for a worker group this worker is not part of
+				if !w.shardAffinityAllowed(shard) {
+					log.Debugf("Shard %s is reserved for another worker group, skipping", shard.ID)
+					continue
 				}
 
-				// Skip shards marked for release (sticky=20) - no worker should acquire these
-				if shard.GetSticky() == 20 {
-					log.Debugf("Shard %s has sticky=20 (release signal), skipping acquisition", shard.ID)
+				// Skip pinned shards that belong to other workers and shards marked for release
+				if !w.stickyAcquisitionAllowed(shard) {
 					continue
 				}
 
@@ -410,6 +432,99 @@ func (w *Worker) eventLoop() {
 	}
 }
 
+// stickyAcquisitionAllowed returns true if the sticky state of the shard lets this worker try to acquire it.
+//
+// A shard pinned with a StickyWorker can only be acquired by that worker, which ensures sticky shards return
+// to their designated worker even after restart. If the pinned worker has not renewed its lease within
+// StickyFailoverMillis, other workers may acquire the shard temporarily; once the pinned worker is back it
+// claims the shard and the temporary owner hands it over on its next lease renewal.
+// A shard pinned without StickyWorker stays with its current owner, and shards marked for release are
+// never acquired.
+func (w *Worker) stickyAcquisitionAllowed(shard *par.ShardStatus) bool {
+	log := w.kclConfig.Logger
+
+	switch shard.GetStickyState() {
+	case par.StickyRelease:
+		log.Debugf("Shard %s is marked for release, skipping acquisition", shard.ID)
+		return false
+
+	case par.StickyPinned:
+		stickyWorker := shard.GetStickyWorker()
+		leaseOwner := shard.GetLeaseOwner()
+
+		if stickyWorker == w.workerID {
+			// Taken over by another worker while this worker was gone: ask for it back
+			if leaseOwner != "" && leaseOwner != w.workerID && shard.GetClaimRequest() != w.workerID {
+				if err := w.checkpointer.ClaimShard(shard, w.workerID); err != nil {
+					log.Debugf("Cannot claim pinned shard %s back from worker %s: %+v", shard.ID, leaseOwner, err)
+				} else {
+					log.Infof("Claimed pinned shard %s back from worker %s", shard.ID, leaseOwner)
+				}
+			}
+			return true
+		}
+
+		// Handed over by its previous owner, e.g. a draining worker
+		if shard.GetClaimRequest() == w.workerID {
+			return true
+		}
+
+		if stickyWorker != "" {
+			failover := time.Duration(w.kclConfig.StickyFailoverMillis) * time.Millisecond
+			if shard.IsStickyWorkerExpired(failover) {
+				log.Infof("Pinned worker %s of shard %s has not renewed its lease within %s, taking over the shard",
+					stickyWorker, shard.ID, failover)
+				return true
+			}
+			log.Debugf("Shard %s is pinned to worker %s (current: %s), skipping", shard.ID, stickyWorker, w.workerID)
+			return false
+		}
+
+		// Pinned to a worker group: any member may acquire the shard once its lease expires
+		if shard.GetStickyGroup() != "" {
+			return true
+		}
+
+		// No StickyWorker binding: respect the current assignment (prevent rebalancing)
+		if leaseOwner != "" && leaseOwner != w.workerID {
+			log.Debugf("Shard %s is sticky and assigned to worker %s, skipping", shard.ID, leaseOwner)
+			return false
+		}
+	}
+
+	return true
+}
+
+// shardAffinityAllowed returns true if this worker's labels match the worker group the shard is restricted to.
+// A StickyGroup pin in the lease table takes precedence over the ShardAffinityRules of the configuration;
+// shards matching neither can be processed by any worker. The StickyWorker of a pinned shard is always allowed.
+func (w *Worker) shardAffinityAllowed(shard *par.ShardStatus) bool {
+	return w.shardAffinityAllowedAt(shard, shard.StartingHashKey)
+}
+
+// shardAffinityAllowedAt is shardAffinityAllowed for a lease read from the lease table, which has no hash key range:
+// the ShardAffinityRules are matched against the starting hash key of the shard listing.
+func (w *Worker) shardAffinityAllowedAt(shard *par.ShardStatus, startingHashKey string) bool {
+	if shard.GetStickyState() == par.StickyPinned && shard.GetStickyWorker() == w.workerID {
+		return true
+	}
+
+	if stickyGroup := shard.GetStickyGroup(); stickyGroup != "" && shard.GetStickyState() == par.StickyPinned {
+		selector, err := config.ParseLabelSelector(stickyGroup)
+		if err != nil {
+			w.kclConfig.Logger.Warnf("Shard %s has an invalid StickyGroup: %+v", shard.ID, err)
+			return false
+		}
+		return selector.Matches(w.kclConfig.WorkerLabels)
+	}
+
+	if selector, ok := w.shardAffinity.SelectorFor(shard.ID, startingHashKey); ok {
+		return selector.Matches(w.kclConfig.WorkerLabels)
+	}
+
+	return true
+}
+
 func (w *Worker) rebalance() error {
 	log := w.kclConfig.Logger
 
@@ -481,18 +596,19 @@ func (w *Worker) rebalance() error {
 	}
 
 	// Steal a random shard from the worker with the most shards
-	// Filter out sticky shards (sticky=10 and sticky=20) as they cannot be stolen
+	// Filter out pinned shards and shards marked for release as they cannot be stolen
 	var eligibleShards []*par.ShardStatus
 	for _, shard := range workers[workerSteal] {
 		// Check if shard still exists in shardStatus (could have been deleted by syncShard)
-		shardStatus, exists := w.shardStatus[shard.ID]
+		local, exists := w.shardStatus[shard.ID]
 		if !exists {
 			log.Debugf("Shard %s no longer exists in shardStatus, skipping", shard.ID)
 			continue
 		}
 
-		// Skip shards with sticky=10 (pinned to their worker) or sticky=20 (marked for release)
-		if shardStatus.GetSticky() != 10 && shardStatus.GetSticky() != 20 {
+		// The sticky state is the one of the lease table scan the workers were listed from, the hash key range
+		// the one of the shard listing. An expired pin is back to StickyNormal and can be stolen again
+		if shard.GetStickyState() == par.StickyNormal && w.shardAffinityAllowedAt(shard, local.StartingHashKey) {
 			eligibleShards = append(eligibleShards, shard)
 		}
 	}
@@ -552,10 +668,15 @@ func (w *Worker) getShardIDs(nextToken string, shardInfo map[string]bool) error
 			w.shardStatus[*s.ShardId] = &par.ShardStatus{
 				ID:                     *s.ShardId,
 				ParentShardId:          aws.ToString(s.ParentShardId),
+				AdjacentParentShardId:  aws.ToString(s.AdjacentParentShardId),
 				Mux:                    &sync.RWMutex{},
 				StartingSequenceNumber: aws.ToString(s.SequenceNumberRange.StartingSequenceNumber),
 				EndingSequenceNumber:   aws.ToString(s.SequenceNumberRange.EndingSequenceNumber),
 			}
+			if s.HashKeyRange != nil {
+				w.shardStatus[*s.ShardId].StartingHashKey = aws.ToString(s.HashKeyRange.StartingHashKey)
+				w.shardStatus[*s.ShardId].EndingHashKey = aws.ToString(s.HashKeyRange.EndingHashKey)
+			}
 		}
 	}
 
@@ -570,6 +691,15 @@ func (w *Worker) getShardIDs(nextToken string, shardInfo map[string]bool) error
 	return nil
 }
 
+// isShardListed returns false if the shard was missing from the last Kinesis shard listing
+func (w *Worker) isShardListed(shardID string) bool {
+	listed := w.listedShards.Load()
+	if listed == nil {
+		return true
+	}
+	return (*listed)[shardID]
+}
+
 // syncShard to sync the cached shard info with actual shard info from Kinesis
 func (w *Worker) syncShard() error {
 	log := w.kclConfig.Logger
@@ -579,6 +709,7 @@ func (w *Worker) syncShard() error {
 	if err != nil {
 		return err
 	}
+	w.listedShards.Store(&shardInfo)
 
 	for _, shard := range w.shardStatus {
 		// The cached shard no longer existed, remove it.
@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2018 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Command kcl-admin operates on the lease table of a KCL application.
+//
+// Usage:
+//
+//	kcl-admin -app <application> -stream <stream> -region <region> rewind -shard <shard id> -to <target>
+//	kcl-admin -app <application> -stream <stream> -region <region> rewind -all -to <target>
+//	kcl-admin -app <application> -stream <stream> -region <region> export [-o <file>]
+//	kcl-admin -app <application> -stream <stream> -region <region> import [-i <file>] [-strip-owners]
+//	kcl-admin -app <application> -stream <stream> -region <region> leases [-owner <worker id>]
+//
+// A rewind target is TRIM_HORIZON, AT_TIMESTAMP=<RFC3339 timestamp> or, for a single shard,
+// AT_SEQUENCE_NUMBER=<sequence number>. Rewinds are applied by running workers; imports must be run while no
+// worker of the application is running. The lease table must exist: kcl-admin never creates it.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+const adminWorkerID = "kcl-admin"
+
+var errUsage = errors.New("usage: kcl-admin -app <application> -stream <stream> -region <region> <command> [flags]; commands: rewind, export, import, leases")
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// adminCheckpointer is the lease table the commands operate on
+type adminCheckpointer interface {
+	chk.Checkpointer
+	chk.LeaseRewinder
+	chk.LeaseLister
+	chk.LeaseOwnerLister
+}
+
+// openCheckpointer connects to the lease table of the application. A missing lease table is an error: a mistyped
+// application name must not create a new one.
+var openCheckpointer = func(kclConfig *config.KinesisClientLibConfiguration) (adminCheckpointer, error) {
+	checkpointer := chk.NewDynamoCheckpoint(kclConfig)
+	if err := checkpointer.Open(); err != nil {
+		return nil, err
+	}
+	return checkpointer, nil
+}
+
+func run(args []string) error {
+	kclConfig, commandArgs, err := parseConfig(args)
+	if err != nil {
+		return err
+	}
+
+	checkpointer, err := openCheckpointer(kclConfig)
+	if err != nil {
+		return err
+	}
+
+	switch command := commandArgs[0]; command {
+	case "rewind":
+		return rewind(checkpointer, commandArgs[1:])
+	case "export":
+		return export(checkpointer, commandArgs[1:])
+	case "import":
+		return importSnapshot(checkpointer, commandArgs[1:])
+	case "leases":
+		return leases(checkpointer, checkpointer, commandArgs[1:])
+	default:
+		return fmt.Errorf("unknown command %q: %w", command, errUsage)
+	}
+}
+
+// parseConfig parses the global flags, and returns the configuration of the application and the command with its
+// flags
+func parseConfig(args []string) (*config.KinesisClientLibConfiguration, []string, error) {
+	flags := flag.NewFlagSet("kcl-admin", flag.ContinueOnError)
+	app := flags.String("app", "", "application name, the name of the lease table")
+	stream := flags.String("stream", "", "stream name")
+	region := flags.String("region", "", "AWS region")
+	endpoint := flags.String("dynamodb-endpoint", "", "DynamoDB endpoint, if not the default one")
+	javaSchema := flags.Bool("java-schema", false, "the lease table has the layout of the Java KCL 2.x")
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if *app == "" || *stream == "" || *region == "" || flags.NArg() == 0 {
+		return nil, nil, errUsage
+	}
+
+	kclConfig := config.NewKinesisClientLibConfig(*app, *stream, *region, adminWorkerID)
+	if *endpoint != "" {
+		kclConfig.WithDynamoDBEndpoint(*endpoint)
+	}
+	if *javaSchema {
+		kclConfig.WithLeaseTableSchema(config.LeaseTableSchemaJava)
+	}
+	return kclConfig, flags.Args(), nil
+}
+
+// rewind asks the owners of the shards to restart them from the target
+func rewind(rewinder chk.LeaseRewinder, args []string) error {
+	flags := flag.NewFlagSet("rewind", flag.ContinueOnError)
+	shardID := flags.String("shard", "", "shard to rewind")
+	all := flags.Bool("all", false, "rewind all shards which aren't closed")
+	to := flags.String("to", "", "TRIM_HORIZON, AT_TIMESTAMP=<RFC3339 timestamp> or AT_SEQUENCE_NUMBER=<sequence number>")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (*shardID == "") == !*all {
+		return errors.New("rewind: exactly one of -shard and -all is required")
+	}
+
+	target, err := chk.ParseRewindTarget(*to)
+	if err != nil {
+		return err
+	}
+
+	if *all {
+		shards, err := rewinder.RewindAllShards(target)
+		for _, shard := range shards {
+			fmt.Printf("%s rewound to %s\n", shard, target)
+		}
+		return err
+	}
+
+	if err := rewinder.RewindShard(*shardID, target); err != nil {
+		return err
+	}
+	fmt.Printf("%s rewound to %s\n", *shardID, target)
+	return nil
+}
+
+// export writes a snapshot of the lease table
+func export(lister chk.LeaseLister, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the snapshot to, standard output if not set")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	snapshot, err := chk.ExportSnapshot(lister)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return chk.WriteSnapshot(os.Stdout, snapshot)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := chk.WriteSnapshot(f, snapshot); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// importSnapshot writes the leases of a snapshot into the lease table
+func importSnapshot(checkpointer chk.Checkpointer, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	input := flags.String("i", "", "file to read the snapshot from, standard input if not set")
+	stripOwners := flags.Bool("strip-owners", false, "import the leases without owner")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	r := os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	snapshot, err := chk.ReadSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	options := chk.ImportOptions{WorkerID: adminWorkerID, StripOwnership: *stripOwners}
+	if err := chk.ImportSnapshot(checkpointer, snapshot, options); err != nil {
+		return err
+	}
+	fmt.Printf("%d leases imported\n", len(snapshot.Leases))
+	return nil
+}
+
+// leases prints the leases of the lease table, or of one worker
+func leases(lister chk.LeaseLister, ownerLister chk.LeaseOwnerLister, args []string) error {
+	flags := flag.NewFlagSet("leases", flag.ContinueOnError)
+	owner := flags.String("owner", "", "list the leases of this worker only")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var leases []*par.ShardStatus
+	var err error
+	if *owner != "" {
+		leases, err = ownerLister.ListLeasesByOwner(*owner)
+	} else {
+		leases, err = lister.ListLeases()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, lease := range leases {
+		fmt.Printf("%s\t%s\t%s\n", lease.ID, lease.GetLeaseOwner(), lease.GetCheckpoint())
+	}
+	return nil
+}
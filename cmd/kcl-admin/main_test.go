@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2021 VMware, Inc.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is furnished to do
+ * so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial
+ * portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+ * WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	chk "github.com/vmware/vmware-go-kcl-v2/clientlibrary/checkpoint"
+	"github.com/vmware/vmware-go-kcl-v2/clientlibrary/config"
+	par "github.com/vmware/vmware-go-kcl-v2/clientlibrary/partition"
+)
+
+// mockCheckpointer is an in-memory lease table recording the rewinds
+type mockCheckpointer struct {
+	*chk.MemoryCheckpoint
+	rewinds []string
+}
+
+func (m *mockCheckpointer) RewindShard(shardID string, target chk.RewindTarget) error {
+	m.rewinds = append(m.rewinds, shardID+" "+target.String())
+	return nil
+}
+
+func (m *mockCheckpointer) RewindAllShards(target chk.RewindTarget) ([]string, error) {
+	m.rewinds = append(m.rewinds, "all "+target.String())
+	return []string{"0001"}, nil
+}
+
+// withMockCheckpointer makes run use a lease table with the leases of worker-1 on the given shards
+func withMockCheckpointer(t *testing.T, shardIDs ...string) *mockCheckpointer {
+	kclConfig := config.NewKinesisClientLibConfig("appName", "test", "us-west-2", adminWorkerID)
+	checkpointer := &mockCheckpointer{MemoryCheckpoint: chk.NewMemoryCheckpoint(kclConfig)}
+	for _, shardID := range shardIDs {
+		shard := &par.ShardStatus{ID: shardID, Sticky: int(par.StickyUnset), Mux: &sync.RWMutex{}}
+		assert.Nil(t, checkpointer.GetLease(shard, "worker-1"))
+		shard.SetCheckpoint("100")
+		assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	}
+
+	open := openCheckpointer
+	openCheckpointer = func(*config.KinesisClientLibConfiguration) (adminCheckpointer, error) {
+		return checkpointer, nil
+	}
+	t.Cleanup(func() { openCheckpointer = open })
+	return checkpointer
+}
+
+func TestParseConfig(t *testing.T) {
+	_, _, err := parseConfig([]string{"-app", "appName", "-stream", "test", "leases"})
+	assert.ErrorIs(t, err, errUsage)
+	_, _, err = parseConfig([]string{"-app", "appName", "-stream", "test", "-region", "us-west-2"})
+	assert.ErrorIs(t, err, errUsage)
+	_, _, err = parseConfig([]string{"-unknown"})
+	assert.NotNil(t, err)
+
+	kclConfig, args, err := parseConfig([]string{"-app", "appName", "-stream", "test", "-region", "us-west-2",
+		"-dynamodb-endpoint", "http://localhost:8000", "-java-schema", "leases", "-owner", "worker-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"leases", "-owner", "worker-1"}, args)
+	assert.Equal(t, "appName", kclConfig.TableName)
+	assert.Equal(t, "test", kclConfig.StreamName)
+	assert.Equal(t, "us-west-2", kclConfig.RegionName)
+	assert.Equal(t, adminWorkerID, kclConfig.WorkerID)
+	assert.Equal(t, "http://localhost:8000", kclConfig.DynamoDBEndpoint)
+	assert.Equal(t, config.LeaseTableSchemaJava, kclConfig.LeaseTableSchema)
+}
+
+func TestRunMissingLeaseTable(t *testing.T) {
+	open := openCheckpointer
+	openCheckpointer = func(*config.KinesisClientLibConfiguration) (adminCheckpointer, error) {
+		return nil, chk.ErrLeaseTableNotFound
+	}
+	defer func() { openCheckpointer = open }()
+
+	err := run([]string{"-app", "appNme", "-stream", "test", "-region", "us-west-2", "leases"})
+	assert.ErrorIs(t, err, chk.ErrLeaseTableNotFound)
+}
+
+func TestRunCommands(t *testing.T) {
+	checkpointer := withMockCheckpointer(t, "0001", "0002")
+	global := []string{"-app", "appName", "-stream", "test", "-region", "us-west-2"}
+	command := func(args ...string) []string { return append(append([]string{}, global...), args...) }
+
+	assert.Nil(t, run(command("leases")))
+	assert.Nil(t, run(command("leases", "-owner", "worker-1")))
+
+	assert.Nil(t, run(command("rewind", "-shard", "0001", "-to", "TRIM_HORIZON")))
+	assert.Nil(t, run(command("rewind", "-all", "-to", "TRIM_HORIZON")))
+	assert.Equal(t, []string{"0001 TRIM_HORIZON", "all TRIM_HORIZON"}, checkpointer.rewinds)
+	assert.NotNil(t, run(command("rewind", "-to", "TRIM_HORIZON")))
+	assert.NotNil(t, run(command("rewind", "-shard", "0001", "-to", "LATEST")))
+
+	// the export is imported into another lease table without its owners
+	snapshot := filepath.Join(t.TempDir(), "leases.json")
+	assert.Nil(t, run(command("export", "-o", snapshot)))
+
+	imported := withMockCheckpointer(t)
+	assert.Nil(t, run(command("import", "-i", snapshot, "-strip-owners")))
+	leases, err := imported.ListLeases()
+	assert.Nil(t, err)
+	if assert.Len(t, leases, 2) {
+		assert.Equal(t, "0001", leases[0].ID)
+		assert.Equal(t, "100", leases[0].Checkpoint)
+		assert.Equal(t, "", leases[0].AssignedTo)
+	}
+
+	err = run(command("unknown"))
+	assert.True(t, errors.Is(err, errUsage))
+}